@@ -1,23 +1,50 @@
 package main
 
 import (
-	// "fmt"
 	// "gopkg.in/mgo.v2"
 	// "gopkg.in/mgo.v2/bson"
-	// "fmt"
+	"fmt"
 	"github.com/spf13/cobra"
 	"log"
 	"net"
-	"time"
-	// "os"
+	"os"
+	"os/signal"
 	"proxy"
+	"syscall"
+	"time"
 	// "strings"
 )
 
+// Port the proxy listens to
+const listenPort = 50000
+
+// Accept connections on a listener routing them with the routing table
+func acceptRoutedConnections(table *proxy.RoutingTable, port int, ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+
+		// Fire off our handler
+		go proxy.HandleRoutedConnection(table, port, conn)
+	}
+}
+
 func main() {
 	// Parser flags
 	var uri string
 	var timeout int
+	var sharded bool
+	var config string
+	var mirrorUri string
+	var mirrorSample float64
+	var mirrorWrites bool
+	var mirrorWorkers int
+	var record string
+	var replayFile string
+	var replayTarget string
+	var replaySpeed float64
 
 	// Proxy command
 	var proxyCmd = &cobra.Command{
@@ -29,20 +56,114 @@ func main() {
             monitoring or who need to centralize connections due to single
             threaded application platforms`,
 		Run: func(cmd *cobra.Command, args []string) {
+			// Mirroring is only supported in front of a single replicaset
+			if mirrorUri != "" && (config != "" || sharded) {
+				log.Fatalf("--mirror-uri can not be combined with --config or --sharded")
+			}
+
 			// Listen to the tcp socket
-			ln, err := net.Listen("tcp", ":50000")
+			ln, err := net.Listen("tcp", fmt.Sprintf(":%d", listenPort))
 			if err != nil {
 				log.Fatalf("%s", err)
 			}
 
+			// Record all the traffic going through the proxy
+			var recorder *proxy.Recorder
+			if record != "" {
+				recorder, err = proxy.NewRecorder(record)
+				if err != nil {
+					log.Fatalf("failed to create recording %s", err)
+				}
+
+				// Flush the recording on shutdown
+				go func() {
+					signals := make(chan os.Signal, 1)
+					signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+					<-signals
+					recorder.Close()
+					os.Exit(0)
+				}()
+			}
+
+			// Route to several replicasets using a routing table
+			if config != "" {
+				// Create RoutingTable
+				table := proxy.NewRoutingTable(config, time.Duration(timeout))
+				table.Recorder = recorder
+				// Attempt to Connect to all the replicasets
+				err = table.Start()
+				if err != nil {
+					log.Fatalf("failed to load routing table %s", err)
+				}
+
+				// Reload the routing table on SIGHUP
+				go func() {
+					signals := make(chan os.Signal, 1)
+					signal.Notify(signals, syscall.SIGHUP)
+
+					for range signals {
+						err := table.Reload()
+						if err != nil {
+							log.Printf("failed to reload routing table %s", err)
+						}
+					}
+				}()
+
+				// Listen to every port with a route, ports routed by a later
+				// reload are only listened to after a restart
+				for _, port := range table.Ports() {
+					portLn, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+					if err != nil {
+						log.Fatalf("%s", err)
+					}
+
+					go acceptRoutedConnections(table, port, portLn)
+				}
+
+				acceptRoutedConnections(table, listenPort, ln)
+			}
+
+			// Front a set of mongos routers
+			if sharded {
+				// Create MongosSet
+				mongosSet := proxy.NewMongosSet(uri, time.Duration(timeout))
+				mongosSet.Recorder = recorder
+				// Attempt to Connect to the routers
+				err = mongosSet.Start()
+				if err != nil {
+					log.Fatalf("failed to connect to mongos routers %s", err)
+				}
+
+				// Accept incoming socket connection
+				for {
+					conn, err := ln.Accept()
+					if err != nil {
+						log.Fatalf("%s", err)
+					}
+
+					// Fire off our handler
+					go proxy.HandleMongosConnection(mongosSet, conn)
+				}
+			}
+
 			// Create ReplSet
 			set := proxy.NewReplSet(uri, time.Duration(timeout))
+			set.Recorder = recorder
 			// Attempt to Connect to the replicaset
 			err = set.Start()
 			if err != nil {
 				log.Fatalf("failed to connect to replicaset %s", err)
 			}
 
+			// Mirror a sample of the traffic to a shadow replicaset
+			if mirrorUri != "" {
+				set.Mirror = proxy.NewMirror(proxy.NewReplSet(mirrorUri, time.Duration(timeout)), mirrorSample, mirrorWrites)
+				err = set.Mirror.Start(mirrorWorkers)
+				if err != nil {
+					log.Fatalf("failed to connect to mirror replicaset %s", err)
+				}
+			}
+
 			// Accept incoming socket connection
 			for {
 				conn, err := ln.Accept()
@@ -56,10 +177,53 @@ func main() {
 		},
 	}
 
+	// Replay command
+	var replayCmd = &cobra.Command{
+		Use:   "replay",
+		Short: "Replay a recording against a server",
+		Long: `Replay the client requests of a recording made with --record
+            against a server, at the original or a scaled speed, reporting
+            the replies that differ from the recorded replies`,
+		Run: func(cmd *cobra.Command, args []string) {
+			report, err := proxy.Replay(replayFile, replayTarget, replaySpeed, time.Duration(timeout)*time.Millisecond)
+			if err != nil {
+				log.Fatalf("failed to replay recording %s", err)
+			}
+
+			for _, divergence := range report.Divergences {
+				fmt.Printf("divergence: %s\n", divergence)
+			}
+
+			for _, replayError := range report.Errors {
+				fmt.Printf("error: %s\n", replayError)
+			}
+
+			fmt.Printf("replayed %v connections, %v requests, %v replies, %v divergences, %v errors\n",
+				report.Connections, report.Requests, report.Replies, len(report.Divergences), len(report.Errors))
+
+			if len(report.Divergences) > 0 || len(report.Errors) > 0 {
+				os.Exit(1)
+			}
+		},
+	}
+
 	// Set default timeout
 	timeout = 10000
 
 	// Set up the uri flag
 	proxyCmd.Flags().StringVarP(&uri, "uri", "u", "mongodb://localhost:31000/admin?maxPoolSize=1", "replicaset connection uri")
+	proxyCmd.Flags().BoolVarP(&sharded, "sharded", "s", false, "uri lists mongos routers of a sharded cluster")
+	proxyCmd.Flags().StringVarP(&config, "config", "c", "", "routing table file mapping databases, users and ports to replicasets")
+	proxyCmd.Flags().StringVarP(&mirrorUri, "mirror-uri", "", "", "shadow replicaset connection uri to mirror traffic to, not supported with --config or --sharded")
+	proxyCmd.Flags().Float64VarP(&mirrorSample, "mirror-sample", "", 0.1, "fraction of the operations mirrored to the shadow replicaset")
+	proxyCmd.Flags().BoolVarP(&mirrorWrites, "mirror-writes", "", false, "mirror write operations as well as reads")
+	proxyCmd.Flags().IntVarP(&mirrorWorkers, "mirror-workers", "", 4, "number of connections replaying mirrored operations")
+	proxyCmd.Flags().StringVarP(&record, "record", "r", "", "file to record all client requests and replies to")
+
+	// Set up the replay flags
+	replayCmd.Flags().StringVarP(&replayFile, "file", "f", "", "recording to replay")
+	replayCmd.Flags().StringVarP(&replayTarget, "target", "t", "localhost:27017", "address of the server to replay against")
+	replayCmd.Flags().Float64VarP(&replaySpeed, "speed", "", 1, "replay speed relative to the recording, 0 replays as fast as possible")
+	proxyCmd.AddCommand(replayCmd)
 	proxyCmd.Execute()
 }
@@ -0,0 +1,680 @@
+// Generates AppendBSON, MarshalBSON and UnmarshalBSON methods for structs
+// so the mongo package encodes and decodes them without reflection. Add a
+// directive to the file declaring the structs and run go generate
+//
+//	//go:generate go run bsongen -type Item,Order
+//
+// Fields of the types the generated code does not handle itself are
+// encoded and decoded with the reflection based encoder and decoder, so
+// are the fields of types with a codec registered in mongo.DefaultRegistry.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A struct field to generate the code for
+type field struct {
+	name      string
+	key       string
+	expr      ast.Expr
+	omitEmpty bool
+	minSize   bool
+}
+
+// A struct to generate the methods for
+type structInfo struct {
+	name   string
+	fields []*field
+}
+
+type generator struct {
+	buffer bytes.Buffer
+	// Qualifier of the mongo package, empty inside the mongo package
+	prefix string
+	// The structs methods are generated for
+	structs map[string]bool
+	// Import paths of the source file by package name
+	imports map[string]string
+	// Import paths used by the generated code and their names
+	used map[string]string
+	// Set when the method being generated checks an error
+	checksError bool
+	// Set when the method being generated checks the registry
+	checksRegistry bool
+}
+
+func main() {
+	typeNames := flag.String("type", "", "comma separated list of the struct names")
+	output := flag.String("output", "", "output file, defaults to <file>_bsongen.go")
+	flag.Parse()
+
+	// The file is passed by go generate or as an argument
+	file := os.Getenv("GOFILE")
+	if flag.NArg() > 0 {
+		file = flag.Arg(0)
+	}
+
+	if file == "" || *typeNames == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *output == "" {
+		*output = outputFileName(file)
+	}
+
+	code, err := generate(file, strings.Split(*typeNames, ","))
+	if err != nil {
+		log.Fatalf("bsongen: %v", err)
+	}
+
+	err = ioutil.WriteFile(*output, code, 0644)
+	if err != nil {
+		log.Fatalf("bsongen: %v", err)
+	}
+}
+
+// The generated file for item.go is item_bsongen.go and for item_test.go
+// item_bsongen_test.go so test types stay in the test build
+func outputFileName(file string) string {
+	name := strings.TrimSuffix(file, ".go")
+	if strings.HasSuffix(name, "_test") {
+		return strings.TrimSuffix(name, "_test") + "_bsongen_test.go"
+	}
+
+	return name + "_bsongen.go"
+}
+
+// Generate the formatted code for the structs declared in file
+func generate(file string, typeNames []string) ([]byte, error) {
+	fileSet := token.NewFileSet()
+	source, err := parser.ParseFile(fileSet, file, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &generator{prefix: "mongo.", structs: make(map[string]bool), imports: make(map[string]string), used: make(map[string]string)}
+	if source.Name.Name == "mongo" {
+		p.prefix = ""
+	} else {
+		p.used["mongo"] = "mongo"
+	}
+
+	for _, spec := range source.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := filepath.Base(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+
+		p.imports[name] = path
+	}
+
+	for _, name := range typeNames {
+		p.structs[name] = true
+	}
+
+	// Collect the fields of the structs in the order they were requested
+	structs := make([]*structInfo, 0)
+	for _, name := range typeNames {
+		structType := findStruct(source, name)
+		if structType == nil {
+			return nil, errors.New(fmt.Sprintf("struct %v not found in %v", name, file))
+		}
+
+		info, err := parseStruct(name, structType)
+		if err != nil {
+			return nil, err
+		}
+
+		structs = append(structs, info)
+	}
+
+	for _, info := range structs {
+		p.generateAppend(info)
+		p.generateUnmarshal(info)
+	}
+
+	// Header and imports
+	var code bytes.Buffer
+	fmt.Fprintf(&code, "// Code generated by bsongen -type %v; DO NOT EDIT.\n\n", strings.Join(typeNames, ","))
+	fmt.Fprintf(&code, "package %v\n\n", source.Name.Name)
+
+	if len(p.used) > 0 {
+		paths := make([]string, 0, len(p.used))
+		for path := range p.used {
+			paths = append(paths, path)
+		}
+
+		sort.Strings(paths)
+		code.WriteString("import (\n")
+		for _, path := range paths {
+			if name := p.used[path]; name != filepath.Base(path) {
+				fmt.Fprintf(&code, "%v ", name)
+			}
+
+			fmt.Fprintf(&code, "%q\n", path)
+		}
+
+		code.WriteString(")\n")
+	}
+
+	code.Write(p.buffer.Bytes())
+	return format.Source(code.Bytes())
+}
+
+func findStruct(source *ast.File, name string) *ast.StructType {
+	for _, decl := range source.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.TYPE {
+			continue
+		}
+
+		for _, spec := range genDecl.Specs {
+			typeSpec := spec.(*ast.TypeSpec)
+			if structType, ok := typeSpec.Type.(*ast.StructType); ok && typeSpec.Name.Name == name {
+				return structType
+			}
+		}
+	}
+
+	return nil
+}
+
+// The name of an embedded field is the name of its type
+func embeddedName(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.StarExpr:
+		return embeddedName(t.X)
+	case *ast.SelectorExpr:
+		return t.Sel.Name
+	}
+
+	return ""
+}
+
+// Collect the fields with the rules of the reflection based encoder
+func parseStruct(name string, structType *ast.StructType) (*structInfo, error) {
+	info := &structInfo{name: name}
+	keys := make(map[string]bool)
+
+	for _, astField := range structType.Fields.List {
+		tag := ""
+		if astField.Tag != nil {
+			value, _ := strconv.Unquote(astField.Tag.Value)
+			tag = reflect.StructTag(value).Get("bson")
+		}
+
+		if tag == "-" {
+			continue
+		}
+
+		names := make([]string, 0)
+		for _, ident := range astField.Names {
+			names = append(names, ident.Name)
+		}
+
+		if len(astField.Names) == 0 {
+			names = append(names, embeddedName(astField.Type))
+		}
+
+		parts := strings.Split(tag, ",")
+		for _, flag := range parts[1:] {
+			if flag == "inline" {
+				return nil, errors.New(fmt.Sprintf("inline field in struct %v is not supported", name))
+			}
+		}
+
+		for _, fieldName := range names {
+			// Unexported fields are skipped
+			if !ast.IsExported(fieldName) {
+				continue
+			}
+
+			f := &field{name: fieldName, key: fieldName, expr: astField.Type}
+			if parts[0] != "" {
+				f.key = parts[0]
+			}
+
+			for _, flag := range parts[1:] {
+				switch flag {
+				case "omitempty":
+					f.omitEmpty = true
+				case "minsize":
+					f.minSize = true
+				}
+			}
+
+			// The first field with a name wins
+			if keys[f.key] {
+				continue
+			}
+
+			keys[f.key] = true
+			info.fields = append(info.fields, f)
+		}
+	}
+
+	return info, nil
+}
+
+// Classify a field type, types without a kind of their own are "value"
+// and handled by the reflection based encoder and decoder
+func (p *generator) kind(expr ast.Expr) string {
+	switch t := expr.(type) {
+	case *ast.Ident:
+		switch t.Name {
+		case "string", "bool", "float32", "float64", "int", "int8", "int16", "int32", "int64":
+			return t.Name
+		case "ObjectId":
+			if p.prefix == "" {
+				return "objectid"
+			}
+		}
+
+		if p.structs[t.Name] {
+			return "struct"
+		}
+	case *ast.SelectorExpr:
+		if pkg, ok := t.X.(*ast.Ident); ok {
+			if p.imports[pkg.Name] == "time" && t.Sel.Name == "Time" {
+				return "time"
+			}
+
+			if p.imports[pkg.Name] == "mongo" && t.Sel.Name == "ObjectId" {
+				return "objectid"
+			}
+		}
+	case *ast.StarExpr:
+		return "pointer"
+	case *ast.ArrayType:
+		if t.Len != nil {
+			break
+		}
+
+		if elem, ok := t.Elt.(*ast.Ident); ok && (elem.Name == "byte" || elem.Name == "uint8") {
+			return "bytes"
+		}
+
+		switch p.kind(t.Elt) {
+		case "string", "bool", "float64", "int", "int32", "int64", "struct":
+			return "slice"
+		}
+	}
+
+	return "value"
+}
+
+// The source of a type expression, recording the imports it uses
+func (p *generator) typeString(expr ast.Expr) string {
+	ast.Inspect(expr, func(node ast.Node) bool {
+		if selector, ok := node.(*ast.SelectorExpr); ok {
+			if pkg, ok := selector.X.(*ast.Ident); ok && p.imports[pkg.Name] != "" {
+				p.used[p.imports[pkg.Name]] = pkg.Name
+			}
+		}
+
+		return true
+	})
+
+	return types.ExprString(expr)
+}
+
+func (p *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&p.buffer, format, args...)
+}
+
+func (p *generator) generateAppend(info *structInfo) {
+	// Generate the fields first to know if err is used
+	header := p.buffer
+	p.buffer = bytes.Buffer{}
+	p.checksError = false
+	p.checksRegistry = false
+
+	for _, f := range info.fields {
+		kind := p.kind(f.expr)
+		value := "p." + f.name
+		key := strconv.Quote(f.key)
+
+		if f.omitEmpty {
+			p.printf("if %v {\n", p.notEmpty(kind, value))
+		}
+
+		// A nil pointer is already omitted
+		if f.omitEmpty && kind == "pointer" && p.kind(f.expr.(*ast.StarExpr).X) == "struct" {
+			p.appendRegistered(f.expr, key, value, func() {
+				p.appendValue("struct", f.expr.(*ast.StarExpr).X, key, "(*"+value+")", false)
+			})
+		} else {
+			p.appendValue(kind, f.expr, key, value, f.minSize)
+		}
+
+		if f.omitEmpty {
+			p.printf("}\n")
+		}
+	}
+
+	fields := p.buffer
+	p.buffer = header
+	p.printf("\n// Append the BSON document of the struct to dst\n")
+	p.printf("func (p *%v) AppendBSON(dst []byte) ([]byte, error) {\n", info.name)
+	if p.checksError {
+		p.printf("var err error\n")
+	}
+
+	if p.checksRegistry {
+		p.printf("registered := %vHasRegisteredCodecs()\n", p.prefix)
+	}
+
+	p.printf("start := len(dst)\n")
+	p.printf("dst = %vAppendDocumentStart(dst)\n", p.prefix)
+	p.buffer.Write(fields.Bytes())
+	p.printf("return %vAppendDocumentEnd(dst, start), nil\n", p.prefix)
+	p.printf("}\n")
+
+	p.printf("\nfunc (p *%v) MarshalBSON() ([]byte, error) {\n", info.name)
+	p.printf("return p.AppendBSON(nil)\n")
+	p.printf("}\n")
+}
+
+// The condition for a value to be encoded with omitempty
+func (p *generator) notEmpty(kind string, value string) string {
+	switch kind {
+	case "string":
+		return value + ` != ""`
+	case "bool":
+		return value
+	case "float32", "float64", "int", "int8", "int16", "int32", "int64":
+		return value + " != 0"
+	case "bytes", "slice":
+		return "len(" + value + ") != 0"
+	case "pointer":
+		return value + " != nil"
+	case "time":
+		return "!" + value + ".IsZero()"
+	}
+
+	return "!" + p.prefix + "IsEmpty(" + value + ")"
+}
+
+func (p *generator) checkAppendError() {
+	p.checksError = true
+	p.printf("if err != nil {\n")
+	p.printf("return dst[:start], err\n")
+	p.printf("}\n")
+}
+
+// The address of a value, (*v) is addressed as v
+func address(value string) string {
+	if strings.HasPrefix(value, "(*") && strings.HasSuffix(value, ")") {
+		return value[2 : len(value)-1]
+	}
+
+	return "&" + value
+}
+
+// A value as an expression, (*v) is written *v
+func expression(value string) string {
+	if strings.HasPrefix(value, "(*") && strings.HasSuffix(value, ")") {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
+
+// The condition for a codec to be registered for the type, checked with
+// a typed nil pointer so the value does not escape
+func (p *generator) registeredCondition(expr ast.Expr) string {
+	p.checksRegistry = true
+	return fmt.Sprintf("registered && %vRegistered((*%v)(nil))", p.prefix, p.typeString(expr))
+}
+
+// Append the element with the reflection based encoder when a codec is
+// registered for the type of the value, with appendKind otherwise
+func (p *generator) appendRegistered(expr ast.Expr, key string, value string, appendKind func()) {
+	p.printf("if %v {\n", p.registeredCondition(expr))
+	p.printf("dst, err = %vAppendValueElement(dst, %v, %v)\n", p.prefix, key, expression(value))
+	p.checkAppendError()
+	p.printf("} else {\n")
+	appendKind()
+	p.printf("}\n")
+}
+
+// Append the element key with value of the given kind
+func (p *generator) appendValue(kind string, expr ast.Expr, key string, value string, minSize bool) {
+	switch kind {
+	case "value":
+		// Pointers and types the generator does not know are encoded by
+		// reflection, which applies minsize once they are resolved
+		if minSize {
+			p.printf("dst, err = %vAppendMinSizeValueElement(dst, %v, %v)\n", p.prefix, key, value)
+		} else {
+			p.printf("dst, err = %vAppendValueElement(dst, %v, %v)\n", p.prefix, key, value)
+		}
+
+		p.checkAppendError()
+	case "pointer":
+		// Nil pointers are encoded as null
+		elem := expr.(*ast.StarExpr).X
+		if p.kind(elem) != "struct" {
+			p.appendValue("value", expr, key, value, minSize)
+			return
+		}
+
+		p.appendRegistered(expr, key, value, func() {
+			p.printf("if %v == nil {\n", value)
+			p.printf("dst = %vAppendNullElement(dst, %v)\n", p.prefix, key)
+			p.printf("} else {\n")
+			p.appendValue("struct", elem, key, "(*"+value+")", minSize)
+			p.printf("}\n")
+		})
+	default:
+		p.appendRegistered(expr, key, value, func() {
+			p.appendKind(kind, expr, key, value, minSize)
+		})
+	}
+}
+
+// Append the element key with value of a kind the generator encodes
+func (p *generator) appendKind(kind string, expr ast.Expr, key string, value string, minSize bool) {
+	switch kind {
+	case "string":
+		p.printf("dst = %vAppendStringElement(dst, %v, %v)\n", p.prefix, key, value)
+	case "bool":
+		p.printf("dst = %vAppendBooleanElement(dst, %v, %v)\n", p.prefix, key, value)
+	case "float64":
+		p.printf("dst = %vAppendDoubleElement(dst, %v, %v)\n", p.prefix, key, value)
+	case "float32":
+		p.printf("dst = %vAppendDoubleElement(dst, %v, float64(%v))\n", p.prefix, key, value)
+	case "int32":
+		p.printf("dst = %vAppendInt32Element(dst, %v, %v)\n", p.prefix, key, value)
+	case "int8", "int16":
+		p.printf("dst = %vAppendInt32Element(dst, %v, int32(%v))\n", p.prefix, key, value)
+	case "int":
+		p.printf("dst = %vAppendIntElement(dst, %v, int64(%v))\n", p.prefix, key, value)
+	case "int64":
+		if minSize {
+			p.printf("dst = %vAppendIntElement(dst, %v, %v)\n", p.prefix, key, value)
+		} else {
+			p.printf("dst = %vAppendInt64Element(dst, %v, %v)\n", p.prefix, key, value)
+		}
+	case "time":
+		p.printf("dst = %vAppendDateTimeElement(dst, %v, %v)\n", p.prefix, key, value)
+	case "objectid":
+		p.printf("dst, err = %vAppendObjectIdElement(dst, %v, %v)\n", p.prefix, key, value)
+		p.checkAppendError()
+	case "bytes":
+		p.printf("dst = %vAppendBinaryElement(dst, %v, 0x00, %v)\n", p.prefix, key, value)
+	case "struct":
+		p.printf("dst, err = %vAppendDocumentElement(dst, %v, %v)\n", p.prefix, key, address(value))
+		p.checkAppendError()
+	case "slice":
+		elem := expr.(*ast.ArrayType).Elt
+		p.printf("{\n")
+		p.printf("var arrayStart int\n")
+		p.printf("dst, arrayStart = %vAppendArrayElementStart(dst, %v)\n", p.prefix, key)
+		p.printf("for i := range %v {\n", value)
+		p.appendValue(p.kind(elem), elem, p.prefix+"ArrayIndexKey(i)", value+"[i]", false)
+		p.printf("}\n")
+		p.printf("dst = %vAppendDocumentEnd(dst, arrayStart)\n", p.prefix)
+		p.printf("}\n")
+	}
+}
+
+// The Decode function of the mongo package for a kind, empty if the kind
+// is decoded another way
+func decodeFunction(kind string) string {
+	switch kind {
+	case "string":
+		return "DecodeString"
+	case "bool":
+		return "DecodeBool"
+	case "float64":
+		return "DecodeFloat64"
+	case "int":
+		return "DecodeInt"
+	case "int32":
+		return "DecodeInt32"
+	case "int64":
+		return "DecodeInt64"
+	case "time":
+		return "DecodeTime"
+	case "objectid":
+		return "DecodeObjectId"
+	case "bytes":
+		return "DecodeBytes"
+	}
+
+	return ""
+}
+
+func (p *generator) generateUnmarshal(info *structInfo) {
+	// Generate the fields first to know if the registry is checked
+	header := p.buffer
+	p.buffer = bytes.Buffer{}
+	p.checksRegistry = false
+
+	// Fields are also decoded by their Go name unless a BSON name uses it
+	keys := make(map[string]bool)
+	for _, f := range info.fields {
+		keys[f.key] = true
+	}
+
+	for _, f := range info.fields {
+		names := strconv.Quote(f.key)
+		if !keys[f.name] {
+			keys[f.name] = true
+			names = names + ", " + strconv.Quote(f.name)
+		}
+
+		p.printf("case %v:\n", names)
+		p.decodeValue(p.kind(f.expr), f.expr, "p."+f.name)
+	}
+
+	fields := p.buffer
+	p.buffer = header
+	p.printf("\n// Decode a BSON document into the struct\n")
+	p.printf("func (p *%v) UnmarshalBSON(data []byte) error {\n", info.name)
+	if p.checksRegistry {
+		p.printf("registered := %vHasRegisteredCodecs()\n", p.prefix)
+	}
+
+	p.printf("iterator := %vRawDocument(data).Iterator()\n", p.prefix)
+	p.printf("for iterator.Next() {\n")
+	p.printf("element := iterator.Element()\n")
+	p.printf("value := element.Value()\n\n")
+	p.printf("var err error\n")
+	p.printf("switch string(element.KeyBytes()) {\n")
+	p.buffer.Write(fields.Bytes())
+	p.printf("}\n\n")
+	p.printf("if err != nil {\n")
+	p.printf("return %vFieldError(element.Key(), err)\n", p.prefix)
+	p.printf("}\n")
+	p.printf("}\n\n")
+	p.printf("return iterator.Err()\n")
+	p.printf("}\n")
+}
+
+// Decode value into target setting err, with the reflection based decoder
+// when a codec is registered for the type of the target
+func (p *generator) decodeValue(kind string, expr ast.Expr, target string) {
+	// Kinds without a decoder of their own always use the decoder
+	if decodeFunction(kind) == "" && kind != "struct" && kind != "pointer" && kind != "slice" {
+		p.printf("err = value.Unmarshal(%v)\n", address(target))
+		return
+	}
+
+	p.printf("if %v {\n", p.registeredCondition(expr))
+	if target == "item" {
+		// Taking the address of the slice item would always allocate it
+		p.printf("decoded := new(%v)\n", p.typeString(expr))
+		p.printf("err = value.Unmarshal(decoded)\n")
+		p.printf("item = *decoded\n")
+	} else {
+		p.printf("err = value.Unmarshal(%v)\n", address(target))
+	}
+
+	p.printf("} else {\n")
+	p.decodeKind(kind, expr, target)
+	p.printf("}\n")
+}
+
+// Decode value into target of a kind the generator decodes
+func (p *generator) decodeKind(kind string, expr ast.Expr, target string) {
+	if function := decodeFunction(kind); function != "" {
+		p.printf("%v, err = %v%v(value)\n", target, p.prefix, function)
+		return
+	}
+
+	switch kind {
+	case "struct":
+		// Newly allocated values are already zero
+		if expression(target) == target {
+			p.printf("%v = %v{}\n", target, p.typeString(expr))
+		}
+
+		p.printf("err = %vDecodeDocument(value, %v)\n", p.prefix, address(target))
+	case "pointer":
+		elem := expr.(*ast.StarExpr).X
+		p.printf("%v = nil\n", target)
+		p.printf("if !value.IsNull() {\n")
+		p.printf("%v = new(%v)\n", target, p.typeString(elem))
+		p.decodeValue(p.kind(elem), elem, "(*"+target+")")
+		p.printf("}\n")
+	case "slice":
+		elem := expr.(*ast.ArrayType).Elt
+		p.printf("%v = nil\n", target)
+		p.printf("if !value.IsNull() {\n")
+		p.printf("%v = make(%v, 0)\n", target, p.typeString(expr))
+		p.printf("err = %vDecodeArray(value, func(value %vRaw) error {\n", p.prefix, p.prefix)
+		p.printf("var item %v\n", p.typeString(elem))
+		p.printf("var err error\n")
+		p.decodeValue(p.kind(elem), elem, "item")
+		p.printf("if err != nil {\n")
+		p.printf("return err\n")
+		p.printf("}\n\n")
+		p.printf("%v = append(%v, item)\n", target, target)
+		p.printf("return nil\n")
+		p.printf("})\n")
+		p.printf("}\n")
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testSource = `package store
+
+import (
+	"mongo"
+	t "time"
+)
+
+type Order struct {
+	Id      mongo.ObjectId ` + "`bson:\"_id\"`" + `
+	Created t.Time
+	Lines   []Line   ` + "`bson:\"lines,omitempty\"`" + `
+	Note    *string
+	Expires *t.Time
+	Total   *int64 ` + "`bson:\"total,minsize\"`" + `
+	skipped int
+}
+
+type Line struct {
+	Sku   string ` + "`bson:\"sku\"`" + `
+	Count int    ` + "`bson:\"count\"`" + `
+}
+`
+
+func writeTestSource(t *testing.T, source string) string {
+	dir, err := ioutil.TempDir("", "bsongen")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "order.go")
+	if err := ioutil.WriteFile(file, []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func TestGenerate(t *testing.T) {
+	file := writeTestSource(t, testSource)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	code, err := generate(file, []string{"Order", "Line"})
+	if err != nil {
+		t.Fatalf("failed to generate %v", err)
+	}
+
+	str := string(code)
+	for _, expected := range []string{
+		"package store",
+		"\"mongo\"\n\tt \"time\"",
+		"p.Expires = new(t.Time)",
+		"func (p *Order) AppendBSON(dst []byte) ([]byte, error) {",
+		"func (p *Order) MarshalBSON() ([]byte, error) {",
+		"func (p *Line) UnmarshalBSON(data []byte) error {",
+		"mongo.AppendObjectIdElement(dst, \"_id\", p.Id)",
+		"mongo.AppendDateTimeElement(dst, \"Created\", p.Created)",
+		"if len(p.Lines) != 0 {",
+		"mongo.AppendDocumentElement(dst, mongo.ArrayIndexKey(i), &p.Lines[i])",
+		"mongo.AppendValueElement(dst, \"Note\", p.Note)",
+		"mongo.AppendMinSizeValueElement(dst, \"total\", p.Total)",
+		"case \"lines\", \"Lines\":",
+		"p.Lines = make([]Line, 0)",
+		"mongo.AppendIntElement(dst, \"count\", int64(p.Count))",
+		"registered := mongo.HasRegisteredCodecs()",
+		"if registered && mongo.Registered((*t.Time)(nil)) {",
+		"err = value.Unmarshal(&p.Created)",
+		"if registered && mongo.Registered((*Line)(nil)) {",
+	} {
+		if !strings.Contains(str, expected) {
+			t.Errorf("expected the generated code to contain %q\n%s", expected, str)
+		}
+	}
+
+	if strings.Contains(str, "skipped") {
+		t.Errorf("unexported fields should be skipped\n%s", str)
+	}
+}
+
+func TestGenerateErrors(t *testing.T) {
+	file := writeTestSource(t, testSource)
+	defer os.RemoveAll(filepath.Dir(file))
+
+	if _, err := generate(file, []string{"Missing"}); err == nil {
+		t.Errorf("expected an error for a missing struct")
+	}
+
+	inline := writeTestSource(t, "package store\n\ntype A struct {\n\tB `bson:\",inline\"`\n}\n\ntype B struct {\n\tC string\n}\n")
+	defer os.RemoveAll(filepath.Dir(inline))
+
+	if _, err := generate(inline, []string{"A"}); err == nil {
+		t.Errorf("expected an error for an inline field")
+	}
+}
+
+func TestOutputFileName(t *testing.T) {
+	if name := outputFileName("types.go"); name != "types_bsongen.go" {
+		t.Errorf("unexpected output file %v", name)
+	}
+
+	if name := outputFileName("types_test.go"); name != "types_bsongen_test.go" {
+		t.Errorf("unexpected output file %v", name)
+	}
+}
@@ -1,9 +1,11 @@
 package mongo
 
 import (
-	// "fmt"
+	"errors"
+	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 )
 
 type bsonType byte
@@ -22,10 +24,12 @@ const (
 	bsonRegExp           bsonType = 0x0b
 	bsonDBPointer        bsonType = 0x0c
 	bsonJavaScript       bsonType = 0x0d
+	bsonSymbol           bsonType = 0x0e
 	bsonJavaScriptWScope bsonType = 0x0f
 	bsonInt32            bsonType = 0x10
 	bsonTimestamp        bsonType = 0x11
 	bsonInt64            bsonType = 0x12
+	bsonDecimal128       bsonType = 0x13
 	bsonMinKey           bsonType = 0xff
 	bsonMaxKey           bsonType = 0x7f
 )
@@ -61,6 +65,13 @@ type Timestamp struct {
 	Value int64
 }
 
+type Symbol struct {
+	Value string
+}
+
+type Undefined struct {
+}
+
 type Min struct {
 }
 
@@ -68,10 +79,18 @@ type Max struct {
 }
 
 type DBPointer struct {
+	Namespace string
+	Id        ObjectId
 }
 
+// Cache of the struct type information, safe for concurrent use
 type TypeInfos struct {
-	Types map[string]*TypeInfo
+	lock  sync.RWMutex
+	types map[reflect.Type]*TypeInfo
+}
+
+func NewTypeInfos() *TypeInfos {
+	return &TypeInfos{types: make(map[reflect.Type]*TypeInfo)}
 }
 
 type TypeInfo struct {
@@ -79,11 +98,18 @@ type TypeInfo struct {
 	FieldsByIndex []*FieldInfo
 	NumberOfField int
 	HasGetBSON    bool
+	InlineMap     []int
 }
 
 type FieldInfo struct {
 	Name         string
 	MetaDataName string
+	Index        []int
+	OmitEmpty    bool
+	MinSize      bool
+	// Chosen from the field type when the type information is parsed
+	encode fieldEncoder
+	decode fieldDecoder
 }
 
 func writeU32(buffer []byte, index int, value uint32) {
@@ -126,91 +152,204 @@ type Getter interface {
 	GetBSON() (interface{}, error)
 }
 
+// An undecoded BSON value, Kind is the BSON type and Data the bytes of
+// the value without the type and field name
+type Raw struct {
+	Kind byte
+	Data []byte
+}
+
+// Types implementing Setter decode themselves from the raw BSON value,
+// returning ErrSetZero sets the field to its zero value
+type Setter interface {
+	SetBSON(raw Raw) error
+}
+
+// Types implementing Unmarshaler decode themselves from the bytes of the
+// raw BSON value, the bytes must be copied if they are kept
+type Unmarshaler interface {
+	UnmarshalBSON(data []byte) error
+}
+
+// Types implementing Marshaler encode themselves as a BSON document
+type Marshaler interface {
+	MarshalBSON() ([]byte, error)
+}
+
+// Types implementing Appender append their BSON document to dst, used
+// instead of Marshaler when both are implemented as it avoids a copy
+type Appender interface {
+	AppendBSON(dst []byte) ([]byte, error)
+}
+
+// Returned by SetBSON to have the field set to its zero value
+var ErrSetZero = errors.New("set to zero")
+
+// How embedded documents are decoded into interface{} values
+type DocumentType int
+
+const (
+	// Decode embedded documents as *Document
+	DocumentTypeDocument DocumentType = iota
+	// Decode embedded documents as map[string]interface{} like mgo's bson.M
+	DocumentTypeMap
+)
+
 type BSON struct {
-	typeInfos *TypeInfos
+	typeInfos    *TypeInfos
+	documentType DocumentType
+	registry     *Registry
 }
 
 func NewBSON() *BSON {
-	return &BSON{&TypeInfos{make(map[string]*TypeInfo)}}
+	return &BSON{NewTypeInfos(), DocumentTypeDocument, DefaultRegistry}
 }
 
-func parseTypeInformation(typeInfos *TypeInfos, originalValue reflect.Value, value reflect.Value) *TypeInfo {
-	// We have a pointer get the underlying value
-	if value.Type().Kind() == reflect.Ptr {
-		value = value.Elem()
-	}
-
-	// Check if we have a cached type
-	cachedType := typeInfos.Types[value.Type().Name()]
-	if cachedType != nil {
-		return cachedType
-	}
+// Shared by the functions that do not take a parser
+var defaultBSON = NewBSON()
 
-	// Get the number of fields
-	numberOfFields := value.NumField()
+// Set the type embedded documents are decoded as when the target is an
+// interface{}, *Document targets always use *Document
+func (p *BSON) SetDocumentType(documentType DocumentType) {
+	p.documentType = documentType
+}
 
-	// Create typeInfo box
-	typeInfo := TypeInfo{}
-	// Pre-allocate a map with the entries we need
-	typeInfo.Fields = make(map[string]*FieldInfo, numberOfFields*2)
-	typeInfo.FieldsByIndex = make([]*FieldInfo, numberOfFields)
-	typeInfo.NumberOfField = numberOfFields
+// Set the registry of the custom encoders and decoders, nil disables them
+func (p *BSON) SetRegistry(registry *Registry) {
+	p.registry = registry
+}
 
+// Collect the metadata of the fields of a struct type, the fields of
+// inlined structs are added as if they belonged to the outer struct.
+// Two fields with the same BSON name are rejected like mgo does, naming
+// the outer struct
+func addFieldInformation(typeInfo *TypeInfo, outerType reflect.Type, structType reflect.Type, parentIndex []int) error {
 	// Iterate over all the fields and collect the metadata
-	for index := 0; index < numberOfFields; index++ {
+	for index := 0; index < structType.NumField(); index++ {
 		// Get the field information
-		fieldType := value.Type().Field(index)
-		// Get the field name
-		key := fieldType.Name
+		fieldType := structType.Field(index)
 		// Get the tag for the field
 		tag := fieldType.Tag.Get("bson")
 
+		// Skip unexported fields and fields marked to be ignored
+		if (fieldType.PkgPath != "" && !fieldType.Anonymous) || tag == "-" {
+			continue
+		}
+
+		// Path to the field from the outer struct
+		fieldIndex := make([]int, len(parentIndex)+1)
+		copy(fieldIndex, parentIndex)
+		fieldIndex[len(parentIndex)] = index
+
+		// Create a new fieldInfo instance
+		fieldInfo := FieldInfo{Name: fieldType.Name, MetaDataName: fieldType.Name, Index: fieldIndex}
+		fieldInfo.encode = newFieldEncoder(fieldType.Type)
+		fieldInfo.decode = newFieldDecoder(fieldType.Type)
+		inline := false
+
 		// Split the tag into parts
 		parts := strings.Split(tag, ",")
 
 		// Override the key if the metadata has one
 		if len(parts) > 0 && parts[0] != "" {
-			key = parts[0]
+			fieldInfo.MetaDataName = parts[0]
 		}
 
-		// Create a new fieldInfo instance
-		fieldInfo := FieldInfo{fieldType.Name, key}
-		// Add to the map
-		typeInfo.Fields[fieldType.Name] = &fieldInfo
-		typeInfo.Fields[key] = &fieldInfo
-		typeInfo.FieldsByIndex[index] = &fieldInfo
-	}
+		for _, flag := range parts[1:] {
+			switch flag {
+			case "omitempty":
+				fieldInfo.OmitEmpty = true
+			case "minsize":
+				fieldInfo.MinSize = true
+			case "inline":
+				inline = true
+			}
+		}
 
-	if originalValue.Type().Kind() == reflect.Ptr {
-		// Iterate over all the
-		numberOfMethods := originalValue.NumMethod()
-
-		// Iterate over all the fields and collect the metadata
-		for index := 0; index < numberOfMethods; index++ {
-			// Method type
-			methodType := originalValue.Type().Method(index)
-			if methodType.Name == "GetBSON" {
-				typeInfo.HasGetBSON = true
-				break
+		if inline {
+			switch fieldType.Type.Kind() {
+			case reflect.Struct:
+				err := addFieldInformation(typeInfo, outerType, fieldType.Type, fieldIndex)
+				if err != nil {
+					return err
+				}
+
+				continue
+			case reflect.Map:
+				// Only one map can collect the extra fields
+				if typeInfo.InlineMap == nil && fieldType.Type.Key().Kind() == reflect.String {
+					typeInfo.InlineMap = fieldIndex
+					continue
+				}
 			}
 		}
+
+		// Unexported embedded structs are only used inlined
+		if fieldType.PkgPath != "" {
+			continue
+		}
+
+		// A BSON name can only be used once
+		if typeInfo.Fields[fieldInfo.MetaDataName] != nil {
+			return errors.New(fmt.Sprintf("Duplicated key '%s' in struct %v", fieldInfo.MetaDataName, outerType))
+		}
+
+		// Add to the map
+		typeInfo.Fields[fieldInfo.MetaDataName] = &fieldInfo
+		typeInfo.FieldsByIndex = append(typeInfo.FieldsByIndex, &fieldInfo)
 	}
 
-	// We need to save the type information of the GetBSON method aswell
-	if typeInfo.HasGetBSON {
-		if vi, ok := originalValue.Interface().(Getter); ok {
-			getv, err := vi.GetBSON()
-			if err != nil {
-				panic(err)
-			}
+	return nil
+}
 
-			// Add the type information to our cache
-			parseTypeInformation(typeInfos, reflect.ValueOf(getv), reflect.ValueOf(getv))
+var getterType = reflect.TypeOf((*Getter)(nil)).Elem()
+
+// Return the type information of a struct or pointer to struct type,
+// parsing it the first time the type is seen
+func parseTypeInformation(typeInfos *TypeInfos, structType reflect.Type) (*TypeInfo, error) {
+	// We have a pointer get the underlying type
+	if structType.Kind() == reflect.Ptr {
+		structType = structType.Elem()
+	}
+
+	// Check if we have a cached type
+	typeInfos.lock.RLock()
+	cachedType := typeInfos.types[structType]
+	typeInfos.lock.RUnlock()
+	if cachedType != nil {
+		return cachedType, nil
+	}
+
+	// Create typeInfo box
+	typeInfo := TypeInfo{}
+	typeInfo.Fields = make(map[string]*FieldInfo, structType.NumField()*2)
+	typeInfo.FieldsByIndex = make([]*FieldInfo, 0, structType.NumField())
+	err := addFieldInformation(&typeInfo, structType, structType, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	typeInfo.NumberOfField = len(typeInfo.FieldsByIndex)
+
+	// Allow decoding using the struct field names as well, the BSON names
+	// take precedence
+	for _, fieldInfo := range typeInfo.FieldsByIndex {
+		if typeInfo.Fields[fieldInfo.Name] == nil {
+			typeInfo.Fields[fieldInfo.Name] = fieldInfo
 		}
 	}
 
-	// Save type
-	typeInfos.Types[value.Type().Name()] = &typeInfo
+	// The struct or its pointer replaces itself using GetBSON
+	typeInfo.HasGetBSON = structType.Implements(getterType) || reflect.PtrTo(structType).Implements(getterType)
+
+	// Save type, keeping the first one if it was parsed concurrently
+	typeInfos.lock.Lock()
+	defer typeInfos.lock.Unlock()
+	if cachedType := typeInfos.types[structType]; cachedType != nil {
+		return cachedType, nil
+	}
+
+	typeInfos.types[structType] = &typeInfo
 	// Return the type information
-	return &typeInfo
+	return &typeInfo, nil
 }
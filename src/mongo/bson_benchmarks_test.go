@@ -164,3 +164,192 @@ func BenchmarkGetBSONSerializationMGO(b *testing.B) {
 		bson.Marshal(doc)
 	}
 }
+
+func BenchmarkNestedDocumentSerializationStructExact(b *testing.B) {
+	type T1 struct {
+		Int int32 `bson:"int,omitempty"`
+	}
+
+	type T2 struct {
+		String string `bson:"string,omitempty"`
+		Doc    *T1    `bson:"doc,omitempty"`
+	}
+
+	obj := &T2{"hello world", &T1{10}}
+	parser := NewBSON()
+
+	for n := 0; n < b.N; n++ {
+		parser.MarshallExact(obj)
+	}
+}
+
+func BenchmarkNestedDocumentSerializationStructExactOverFlow64bytes(b *testing.B) {
+	type T1 struct {
+		Int int32 `bson:"int,omitempty"`
+	}
+
+	type T2 struct {
+		String string `bson:"string,omitempty"`
+		Doc    *T1    `bson:"doc,omitempty"`
+	}
+
+	obj := &T2{"hello world hello world hello world hello world hello world hello world", &T1{10}}
+	parser := NewBSON()
+
+	for n := 0; n < b.N; n++ {
+		parser.MarshallExact(obj)
+	}
+}
+
+func BenchmarkNestedDocumentSerializationStructInto(b *testing.B) {
+	type T1 struct {
+		Int int32 `bson:"int,omitempty"`
+	}
+
+	type T2 struct {
+		String string `bson:"string,omitempty"`
+		Doc    *T1    `bson:"doc,omitempty"`
+	}
+
+	obj := &T2{"hello world hello world hello world hello world hello world hello world", &T1{10}}
+	parser := NewBSON()
+	buffer := make([]byte, 1024)
+
+	for n := 0; n < b.N; n++ {
+		parser.MarshallInto(obj, buffer)
+	}
+}
+
+// A document of a few kilobytes where the growth strategy reallocates
+// several times
+type largeBenchmarkItem struct {
+	Name  string   `bson:"name"`
+	Count int64    `bson:"count"`
+	Price float64  `bson:"price"`
+	Tags  []string `bson:"tags"`
+}
+
+type largeBenchmarkDocument struct {
+	Id    string               `bson:"_id"`
+	Items []largeBenchmarkItem `bson:"items"`
+}
+
+func newLargeBenchmarkDocument() *largeBenchmarkDocument {
+	doc := &largeBenchmarkDocument{Id: "large"}
+	for i := 0; i < 50; i++ {
+		doc.Items = append(doc.Items, largeBenchmarkItem{"item", int64(i), 1.5, []string{"a", "b", "c"}})
+	}
+
+	return doc
+}
+
+func BenchmarkLargeDocumentSerialization(b *testing.B) {
+	doc := newLargeBenchmarkDocument()
+	parser := NewBSON()
+
+	for n := 0; n < b.N; n++ {
+		parser.Marshall(doc, nil, 0)
+	}
+}
+
+func BenchmarkLargeDocumentSerializationExact(b *testing.B) {
+	doc := newLargeBenchmarkDocument()
+	parser := NewBSON()
+
+	for n := 0; n < b.N; n++ {
+		parser.MarshallExact(doc)
+	}
+}
+
+func BenchmarkLargeDocumentSerializationMGO(b *testing.B) {
+	doc := newLargeBenchmarkDocument()
+
+	for n := 0; n < b.N; n++ {
+		bson.Marshal(doc)
+	}
+}
+
+func BenchmarkMarshalAppendPool(b *testing.B) {
+	type T1 struct {
+		Int int32 `bson:"int,omitempty"`
+	}
+
+	type T2 struct {
+		String string `bson:"string,omitempty"`
+		Doc    *T1    `bson:"doc,omitempty"`
+	}
+
+	obj := &T2{"hello world hello world hello world hello world hello world hello world", &T1{10}}
+	parser := NewBSON()
+	pool := NewBufferPool(512, 64*1024)
+
+	for n := 0; n < b.N; n++ {
+		buffer := pool.Get()
+		buffer.Bytes, _ = parser.MarshalAppend(buffer.Bytes, obj)
+		pool.Put(buffer)
+	}
+}
+
+// The same documents with the codecs generated by bsongen
+func BenchmarkNestedDocumentSerializationGenerated(b *testing.B) {
+	obj := &generatedBenchmarkT2{"hello world", &generatedBenchmarkT1{10}}
+	parser := NewBSON()
+
+	for n := 0; n < b.N; n++ {
+		parser.Marshall(obj, nil, 0)
+	}
+}
+
+func BenchmarkNestedDocumentSerializationGeneratedAppend(b *testing.B) {
+	obj := &generatedBenchmarkT2{"hello world", &generatedBenchmarkT1{10}}
+	buffer := make([]byte, 0, 64)
+
+	for n := 0; n < b.N; n++ {
+		buffer, _ = obj.AppendBSON(buffer[:0])
+	}
+}
+
+func BenchmarkNestedDocumentDeserializationGenerated(b *testing.B) {
+	parser := NewBSON()
+	data := []byte{48, 0, 0, 0, 2, 115, 116, 114, 105, 110, 103, 0, 12, 0, 0, 0, 104, 101, 108, 108, 111, 32, 119, 111, 114, 108, 100, 0, 3, 100, 111, 99, 0, 14, 0, 0, 0, 16, 105, 110, 116, 0, 10, 0, 0, 0, 0, 0}
+	obj := &generatedBenchmarkT2{}
+
+	for n := 0; n < b.N; n++ {
+		parser.Unmarshal(data, obj)
+	}
+}
+
+func BenchmarkLargeDocumentSerializationGenerated(b *testing.B) {
+	doc := newGeneratedBenchmarkDocument()
+	parser := NewBSON()
+
+	for n := 0; n < b.N; n++ {
+		parser.Marshall(doc, nil, 0)
+	}
+}
+
+func BenchmarkLargeDocumentDeserialization(b *testing.B) {
+	data, _ := bson.Marshal(newLargeBenchmarkDocument())
+	parser := NewBSON()
+
+	for n := 0; n < b.N; n++ {
+		parser.Unmarshal(data, &largeBenchmarkDocument{})
+	}
+}
+
+func BenchmarkLargeDocumentDeserializationGenerated(b *testing.B) {
+	data, _ := bson.Marshal(newLargeBenchmarkDocument())
+	parser := NewBSON()
+
+	for n := 0; n < b.N; n++ {
+		parser.Unmarshal(data, &generatedBenchmarkDocument{})
+	}
+}
+
+func BenchmarkLargeDocumentDeserializationMGO(b *testing.B) {
+	data, _ := bson.Marshal(newLargeBenchmarkDocument())
+
+	for n := 0; n < b.N; n++ {
+		bson.Unmarshal(data, &largeBenchmarkDocument{})
+	}
+}
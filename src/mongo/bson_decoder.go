@@ -4,9 +4,38 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
+	"time"
 )
 
+// Decode a BSON document into obj, which can be a *Document, a pointer to
+// a struct, a map with string keys, a pointer to a map, an *interface{} or
+// a pointer to a slice receiving the values of the document.
+//
+// Values decoded into interface{} targets use the following types
+//
+//	double             float64
+//	string             string
+//	document           *Document or map[string]interface{}, see SetDocumentType
+//	array              []interface{}
+//	binary             *Binary
+//	undefined          *Undefined
+//	objectid           *ObjectId
+//	boolean            bool
+//	datetime           time.Time
+//	null               nil
+//	regexp             *RegExp
+//	dbpointer          *DBPointer
+//	javascript         *Javascript
+//	symbol             *Symbol
+//	javascript w scope *JavascriptWScope
+//	int32              int32
+//	timestamp          *Timestamp
+//	int64              int64
+//	decimal128         *Decimal128
+//	min key            *Min
+//	max key            *Max
 func (p *BSON) Unmarshal(bson []byte, obj interface{}) error {
 	// Do some basic authentication on the size
 	if len(bson) < 5 {
@@ -16,6 +45,15 @@ func (p *BSON) Unmarshal(bson []byte, obj interface{}) error {
 	// Get value type
 	value := reflect.ValueOf(obj)
 
+	// Decode into what the pointer points to, maps can be filled in place
+	if value.Kind() == reflect.Map {
+		if value.IsNil() {
+			return errors.New("must be a pointer to a map or a non nil map")
+		}
+	} else if value.Kind() != reflect.Ptr || value.IsNil() {
+		return errors.New(fmt.Sprintf("must be a non nil pointer to a struct, map, slice or Document, got %T", obj))
+	}
+
 	// Decode the length of the buffer
 	documentSize := readUInt32(bson, 0)
 
@@ -24,6 +62,31 @@ func (p *BSON) Unmarshal(bson []byte, obj interface{}) error {
 		return errors.New(fmt.Sprintf("Passed in byte slice [%v] is different in size than encoded bson document length [%v]", len(bson), documentSize))
 	}
 
+	// Check every length prefix before decoding
+	err := Validate(bson)
+	if err != nil {
+		return err
+	}
+
+	// Raw values keep a copy of the document
+	if raw, ok := obj.(*Raw); ok {
+		raw.Kind = byte(bsonDocument)
+		raw.Data = make([]byte, len(bson))
+		copy(raw.Data, bson)
+		return nil
+	}
+
+	// Let the value decode itself
+	if value.Kind() == reflect.Ptr && !value.IsNil() && implementsRawDecoding(value.Type()) {
+		err := callSetter(value, Raw{byte(bsonDocument), bson})
+		if err == ErrSetZero {
+			value.Elem().Set(reflect.Zero(value.Elem().Type()))
+			return nil
+		}
+
+		return err
+	}
+
 	// Do we want a pure doc representation instead of serialized struct
 	isDocument := false
 
@@ -35,14 +98,26 @@ func (p *BSON) Unmarshal(bson []byte, obj interface{}) error {
 
 	// If we have a pointer get to the value object
 	if isDocument == false && value.Kind() == reflect.Ptr {
-		// v := reflect.ValueOf(out)
-		switch value.Kind() {
-		case reflect.Ptr:
-			value = value.Elem()
-		case reflect.Struct:
-			return errors.New("must be a pointer to a struct or Document")
-		default:
-			return errors.New("must be a pointer to a struct or Document")
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Interface:
+		// Decode with the default type mapping
+		document, _, err := p.readValue(bson, 0, byte(bsonDocument), p.documentType)
+		if err != nil {
+			return err
+		}
+
+		value.Set(reflect.ValueOf(document))
+		return nil
+	case reflect.Slice, reflect.Array:
+		// Decode the values of the document in order
+		_, err := p.decodeElement(bson, 0, byte(bsonArray), "", value)
+		return err
+	case reflect.Map:
+		if value.IsNil() {
+			value.Set(reflect.MakeMap(value.Type()))
 		}
 	}
 
@@ -59,6 +134,30 @@ func (p *BSON) deserializeObject(bson []byte, index int, value reflect.Value, is
 		return nil
 	}
 
+	// Maps are filled element by element
+	if !isDocument && value.Kind() == reflect.Map {
+		return p.deserializeMap(bson, index, value)
+	}
+
+	// Struct fields are looked up in the type information of the struct
+	var typeInfo *TypeInfo
+	var structValue reflect.Value
+	var codecs *registryCodecs
+	if !isDocument {
+		structValue = reflect.Indirect(value)
+		if structValue.Kind() != reflect.Struct {
+			return errors.New(fmt.Sprintf("cannot decode a document into type %v", value.Type()))
+		}
+
+		var err error
+		typeInfo, err = parseTypeInformation(p.typeInfos, structValue.Type())
+		if err != nil {
+			return err
+		}
+
+		codecs = p.registry.load()
+	}
+
 	// initialIndex
 	endIndex := index + int(documentSize)
 
@@ -73,69 +172,326 @@ func (p *BSON) deserializeObject(bson []byte, index int, value reflect.Value, is
 		// Skip bson type
 		index = index + 1
 
-		// Read the cstring
-		strindex := bytes.IndexByte(bson[index:], 0x00)
-
-		// No 0 byte found error out
-		if strindex == -1 {
-			return errors.New("could not decode field name, possibly corrupt bson")
+		// Read the field name
+		var fieldName string
+		var err error
+		fieldName, index, err = readCString(bson, index)
+		if err != nil {
+			return err
 		}
 
-		// cast byte array to string
-		fieldName := string(bson[index : index+strindex])
-		// Adjust index with the string length
-		index = index + strindex + 1
+		// Use the decoder chosen for the field type if it has one
+		if typeInfo != nil {
+			fieldInfo := typeInfo.Fields[fieldName]
+
+			// Registered decoders come first
+			if fieldInfo != nil && codecs != nil {
+				handled, nextIndex, err := decodeRegistered(codecs, bson, index, bsonType, fieldName, structValue.FieldByIndex(fieldInfo.Index))
+				if err != nil {
+					return err
+				}
+
+				if handled {
+					index = nextIndex
+					continue
+				}
+			}
+
+			if fieldInfo != nil && fieldInfo.decode != nil {
+				handled, nextIndex, err := fieldInfo.decode(bson, index, bsonType, fieldName, structValue.FieldByIndex(fieldInfo.Index))
+				if err != nil {
+					return err
+				}
+
+				if handled {
+					index = nextIndex
+					continue
+				}
+			}
+
+			// Documents and arrays are decoded straight into the field
+			if fieldInfo != nil && (bsonType == byte(bsonDocument) || bsonType == byte(bsonArray)) {
+				index, err = p.decodeElement(bson, index, bsonType, fieldName, structValue.FieldByIndex(fieldInfo.Index))
+				if err != nil {
+					return err
+				}
+
+				continue
+			}
+		}
 
 		// Switch on type to decode
 		switch bsonType {
-		case byte(bsonString):
-			// Read the string size
-			stringSize := int(readUInt32(bson, index))
-			// Skip string size
-			index = index + 4
-			// Add to the field value
-			err := p.addValueToFieldStruct(fieldName, value, string(bson[index:index+stringSize-1]), isDocument)
-			if err != nil {
-				return err
-			}
-			// Skip last null byte and size of string
-			index = index + stringSize
 		case byte(bsonDocument):
 			// Read the document size
-			stringSize := int(readUInt32(bson, index))
+			documentSize := int(readUInt32(bson, index))
 
 			// Add to the field value
-			v, err := p.addDocumentToFieldStruct(fieldName, value, isDocument)
+			v, isDocumentField, err := p.addDocumentToFieldStruct(fieldName, value, isDocument)
 			if err != nil {
 				return err
 			}
 
-			// Deserialize documents
-			err = p.deserializeObject(bson[index:index+stringSize], 0, v, isDocument)
+			// Deserialize documents, skipping the ones that do not map to a field
+			if v.IsValid() {
+				err = p.deserializeObject(bson[index:index+documentSize], 0, v, isDocumentField)
+				if err != nil {
+					return prefixDecodeError(fieldName, err)
+				}
+			}
+
+			// Skip the document
+			index = index + documentSize
+		default:
+			// Read the value
+			elementValue, nextIndex, err := p.readValue(bson, index, bsonType, p.valueDocumentType(isDocument))
 			if err != nil {
-				return err
+				return errors.New(fmt.Sprintf("field %v: %v", fieldName, err))
 			}
 
-			// Skip last null byte and size of string
-			index = index + stringSize
-		case byte(bsonInt32):
 			// Add to the field value
-			err := p.addValueToFieldStruct(fieldName, value, int32(readUInt32(bson, index)), isDocument)
+			err = p.addValueToFieldStruct(fieldName, value, elementValue, isDocument)
 			if err != nil {
 				return err
 			}
 
-			// Skip the int32 field
-			index = index + 4
+			// Skip the value
+			index = nextIndex
 		}
 	}
 
-	// Adjust for last byte
-	index = index + 1
 	// Return no error
 	return nil
 }
 
+// Read a null terminated string returning the index after the null byte
+func readCString(bson []byte, index int) (string, int, error) {
+	// Read the cstring
+	strindex := bytes.IndexByte(bson[index:], 0x00)
+
+	// No 0 byte found error out
+	if strindex == -1 {
+		return "", index, errors.New("could not decode cstring, possibly corrupt bson")
+	}
+
+	// cast byte array to string
+	return string(bson[index : index+strindex]), index + strindex + 1, nil
+}
+
+// Read a length prefixed string returning the index after the string
+func readString(bson []byte, index int) (string, int) {
+	// Read the string size
+	stringSize := int(readUInt32(bson, index))
+	// Skip string size
+	index = index + 4
+	// Skip last null byte and size of string
+	return string(bson[index : index+stringSize-1]), index + stringSize
+}
+
+func readObjectId(bson []byte, index int) ObjectId {
+	id := make([]byte, 12)
+	copy(id, bson[index:index+12])
+	return ObjectId{id}
+}
+
+// Convert milliseconds since the unix epoch to a time
+func millisecondsToTime(value int64) time.Time {
+	return time.Unix(value/1000, value%1000*1e6).UTC()
+}
+
+// Return the index after the BSON value of the given type without decoding it
+func valueEnd(bson []byte, index int, bsonType byte) (int, error) {
+	size := 0
+
+	switch bsonType {
+	case byte(bsonUndefined), byte(bsonNull), byte(bsonMinKey), byte(bsonMaxKey):
+		size = 0
+	case byte(bsonBoolean):
+		size = 1
+	case byte(bsonInt32):
+		size = 4
+	case byte(bsonDouble), byte(bsonDateTime), byte(bsonTimestamp), byte(bsonInt64):
+		size = 8
+	case byte(bsonObjectId):
+		size = 12
+	case byte(bsonDecimal128):
+		size = 16
+	case byte(bsonString), byte(bsonJavaScript), byte(bsonSymbol), byte(bsonDBPointer):
+		if index+4 > len(bson) {
+			return index, errors.New("string length out of bounds, possibly corrupt bson")
+		}
+
+		// Length prefixed string, the pointer is followed by an ObjectId
+		size = 4 + int(int32(readUInt32(bson, index)))
+		if size < 5 {
+			return index, errors.New("string length out of bounds, possibly corrupt bson")
+		}
+
+		if bsonType == byte(bsonDBPointer) {
+			size = size + 12
+		}
+	case byte(bsonDocument), byte(bsonArray), byte(bsonJavaScriptWScope):
+		if index+4 > len(bson) {
+			return index, errors.New("document length out of bounds, possibly corrupt bson")
+		}
+
+		// The size includes itself
+		size = int(int32(readUInt32(bson, index)))
+		if size < 5 {
+			return index, errors.New("document length out of bounds, possibly corrupt bson")
+		}
+	case byte(bsonBinary):
+		if index+4 > len(bson) {
+			return index, errors.New("binary length out of bounds, possibly corrupt bson")
+		}
+
+		// Size, subtype and data
+		size = 5 + int(int32(readUInt32(bson, index)))
+	case byte(bsonRegExp):
+		// Pattern and options
+		_, end, err := readCString(bson, index)
+		if err != nil {
+			return index, err
+		}
+
+		_, end, err = readCString(bson, end)
+		return end, err
+	default:
+		return index, errors.New(fmt.Sprintf("unknown BSON type 0x%02x", bsonType))
+	}
+
+	if size < 0 || index+size > len(bson) {
+		return index, errors.New("value out of bounds, possibly corrupt bson")
+	}
+
+	return index + size, nil
+}
+
+// Read a single BSON value of the given type returning the index after the
+// value. Values are returned as they are stored in a Document, the BSON
+// types declared in bson.go as pointers, datetime as time.Time, embedded
+// documents as *Document or map[string]interface{} depending on the
+// document type and arrays as []interface{}
+func (p *BSON) readValue(bson []byte, index int, bsonType byte, documentType DocumentType) (interface{}, int, error) {
+	switch bsonType {
+	case byte(bsonDouble):
+		return math.Float64frombits(readUInt64(bson, index)), index + 8, nil
+	case byte(bsonString):
+		value, index := readString(bson, index)
+		return value, index, nil
+	case byte(bsonDocument):
+		// Read the document size
+		documentSize := int(readUInt32(bson, index))
+
+		// Decode into a new map
+		if documentType == DocumentTypeMap {
+			document := make(map[string]interface{})
+			err := p.deserializeObject(bson[index:index+documentSize], 0, reflect.ValueOf(document), false)
+			return document, index + documentSize, err
+		}
+
+		// Decode into a new document
+		document := NewDocument()
+		err := p.deserializeObject(bson[index:index+documentSize], 0, reflect.ValueOf(document), true)
+		return document, index + documentSize, err
+	case byte(bsonArray):
+		// Arrays are documents keyed by the element index, the keys are ignored
+		documentSize := int(readUInt32(bson, index))
+		endIndex := index + documentSize
+		array := make([]interface{}, 0)
+
+		for elementIndex := index + 4; elementIndex < endIndex-1; {
+			elementType := bson[elementIndex]
+
+			_, valueIndex, err := readCString(bson, elementIndex+1)
+			if err != nil {
+				return nil, index, err
+			}
+
+			value, nextIndex, err := p.readValue(bson, valueIndex, elementType, documentType)
+			if err != nil {
+				return nil, index, err
+			}
+
+			array = append(array, value)
+			elementIndex = nextIndex
+		}
+
+		return array, endIndex, nil
+	case byte(bsonBinary):
+		// Read the binary size and subtype
+		binarySize := int(readUInt32(bson, index))
+		subType := bson[index+4]
+		index = index + 5
+
+		// The old binary subtype wraps the data in a second length
+		data := bson[index : index+binarySize]
+		if subType == 0x02 && binarySize >= 4 {
+			data = data[4:]
+		}
+
+		// Do not hold on to the original buffer
+		binary := &Binary{subType, make([]byte, len(data))}
+		copy(binary.Data, data)
+		return binary, index + binarySize, nil
+	case byte(bsonUndefined):
+		return &Undefined{}, index, nil
+	case byte(bsonObjectId):
+		id := readObjectId(bson, index)
+		return &id, index + 12, nil
+	case byte(bsonBoolean):
+		return bson[index] == 1, index + 1, nil
+	case byte(bsonDateTime):
+		return millisecondsToTime(int64(readUInt64(bson, index))), index + 8, nil
+	case byte(bsonNull):
+		return nil, index, nil
+	case byte(bsonRegExp):
+		pattern, index, err := readCString(bson, index)
+		if err != nil {
+			return nil, index, err
+		}
+
+		options, index, err := readCString(bson, index)
+		if err != nil {
+			return nil, index, err
+		}
+
+		return &RegExp{pattern, options}, index, nil
+	case byte(bsonDBPointer):
+		namespace, index := readString(bson, index)
+		return &DBPointer{namespace, readObjectId(bson, index)}, index + 12, nil
+	case byte(bsonJavaScript):
+		code, index := readString(bson, index)
+		return &Javascript{code}, index, nil
+	case byte(bsonSymbol):
+		symbol, index := readString(bson, index)
+		return &Symbol{symbol}, index, nil
+	case byte(bsonJavaScriptWScope):
+		// Skip the total size
+		code, index := readString(bson, index+4)
+		scope, index, err := p.readValue(bson, index, byte(bsonDocument), DocumentTypeDocument)
+		if err != nil {
+			return nil, index, err
+		}
+
+		return &JavascriptWScope{code, scope.(*Document)}, index, nil
+	case byte(bsonInt32):
+		return int32(readUInt32(bson, index)), index + 4, nil
+	case byte(bsonTimestamp):
+		return &Timestamp{int64(readUInt64(bson, index))}, index + 8, nil
+	case byte(bsonInt64):
+		return int64(readUInt64(bson, index)), index + 8, nil
+	case byte(bsonDecimal128):
+		return &Decimal128{readUInt64(bson, index+8), readUInt64(bson, index)}, index + 16, nil
+	case byte(bsonMinKey):
+		return &Min{}, index, nil
+	case byte(bsonMaxKey):
+		return &Max{}, index, nil
+	}
+
+	return nil, index, errors.New(fmt.Sprintf("unknown BSON type 0x%02x", bsonType))
+}
+
 func (p *BSON) addValueToFieldStruct(fieldName string, obj reflect.Value, value interface{}, isDocument bool) error {
 	if isDocument {
 		switch t := obj.Interface().(type) {
@@ -144,34 +500,134 @@ func (p *BSON) addValueToFieldStruct(fieldName string, obj reflect.Value, value
 		}
 	} else {
 		// Get the type info
-		typeInfo := parseTypeInformation(p.typeInfos, obj, obj)
-		// Does not map to any field, ignore it
-		if typeInfo.Fields[fieldName] == nil {
-			return nil
+		typeInfo, err := parseTypeInformation(p.typeInfos, obj.Type())
+		if err != nil {
+			return err
 		}
 
-		structFieldName := typeInfo.Fields[fieldName].Name
-
 		if obj.Kind() == reflect.Ptr {
 			obj = obj.Elem()
 		}
 
-		// Set the field value on the struct (just set it hard)
-		field := obj.FieldByName(structFieldName)
+		// Does not map to any field, keep it in the inlined map or ignore it
+		if typeInfo.Fields[fieldName] == nil {
+			if typeInfo.InlineMap != nil {
+				return addValueToInlineMap(fieldName, obj.FieldByIndex(typeInfo.InlineMap), value)
+			}
 
-		// We did not find the field on the struct
-		if field.Kind() == reflect.Invalid {
-			return errors.New(fmt.Sprintf("field %v not found on struct %v", fieldName, obj))
+			return nil
 		}
 
+		// Set the field value on the struct (just set it hard)
+		field := obj.FieldByIndex(typeInfo.Fields[fieldName].Index)
+
 		// Set the field
-		field.Set(reflect.ValueOf(value))
+		return assignValue(fieldName, field, value)
 	}
 
 	return nil
 }
 
-func (p *BSON) addDocumentToFieldStruct(fieldName string, obj reflect.Value, isDocument bool) (reflect.Value, error) {
+// Set a decoded value on a struct field or slice element
+func assignValue(fieldName string, field reflect.Value, value interface{}) error {
+	// Null resets the field
+	if value == nil {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	v := reflect.ValueOf(value)
+
+	// The value can be set as is
+	if v.Type().AssignableTo(field.Type()) {
+		field.Set(v)
+		return nil
+	}
+
+	// The BSON types are decoded as pointers, dereference for value fields
+	if v.Kind() == reflect.Ptr && v.Elem().Type().AssignableTo(field.Type()) {
+		field.Set(v.Elem())
+		return nil
+	}
+
+	// Allocate pointer fields
+	if field.Kind() == reflect.Ptr {
+		element := reflect.New(field.Type().Elem())
+		err := assignValue(fieldName, element.Elem(), value)
+		if err != nil {
+			return err
+		}
+
+		field.Set(element)
+		return nil
+	}
+
+	// Datetimes into Date fields, kept as milliseconds since the epoch
+	if t, ok := value.(time.Time); ok && field.Type() == dateType {
+		field.Set(reflect.ValueOf(Date{timeToMilliseconds(t)}))
+		return nil
+	}
+
+	// Numbers into any integer or float width
+	if handled, err := setNumber(fieldName, field, value); handled {
+		return err
+	}
+
+	// Binary data into byte slices
+	if binary, ok := value.(*Binary); ok && field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Uint8 {
+		field.SetBytes(binary.Data)
+		return nil
+	}
+
+	// Binary data into byte arrays of the same length
+	if binary, ok := value.(*Binary); ok && field.Kind() == reflect.Array && field.Type().Elem().Kind() == reflect.Uint8 {
+		if len(binary.Data) != field.Len() {
+			return newDecodeError(fieldName, "cannot decode %v bytes of binary into type %v", len(binary.Data), field.Type())
+		}
+
+		for i, b := range binary.Data {
+			field.Index(i).SetUint(uint64(b))
+		}
+
+		return nil
+	}
+
+	// Arrays into slices
+	if array, ok := value.([]interface{}); ok && field.Kind() == reflect.Slice {
+		slice := reflect.MakeSlice(field.Type(), len(array), len(array))
+		for i, element := range array {
+			err := assignValue(joinPath(fieldName, itoa(i)), slice.Index(i), element)
+			if err != nil {
+				return err
+			}
+		}
+
+		field.Set(slice)
+		return nil
+	}
+
+	return newDecodeError(fieldName, "cannot decode BSON %T into type %v", value, field.Type())
+}
+
+// Add a field that is not mapped on the struct to its inlined map
+func addValueToInlineMap(fieldName string, inlineMap reflect.Value, value interface{}) error {
+	if inlineMap.IsNil() {
+		inlineMap.Set(reflect.MakeMap(inlineMap.Type()))
+	}
+
+	element := reflect.New(inlineMap.Type().Elem()).Elem()
+	err := assignValue(fieldName, element, value)
+	if err != nil {
+		return err
+	}
+
+	inlineMap.SetMapIndex(reflect.ValueOf(fieldName), element)
+	return nil
+}
+
+// Create the value an embedded document is decoded into, returning if
+// it is a *Document
+func (p *BSON) addDocumentToFieldStruct(fieldName string, obj reflect.Value, isDocument bool) (reflect.Value, bool, error) {
 	if isDocument {
 		switch t := obj.Interface().(type) {
 		case *Document:
@@ -180,37 +636,475 @@ func (p *BSON) addDocumentToFieldStruct(fieldName string, obj reflect.Value, isD
 			// Add the field
 			t.Add(fieldName, doc)
 			// Return the new value
-			return reflect.ValueOf(doc), nil
+			return reflect.ValueOf(doc), true, nil
 		}
 	} else {
 		// Get the type info
-		typeInfo := parseTypeInformation(p.typeInfos, obj, obj)
-		structFieldName := typeInfo.Fields[fieldName].Name
+		typeInfo, err := parseTypeInformation(p.typeInfos, obj.Type())
+		if err != nil {
+			return reflect.Value{}, false, err
+		}
 
-		// Set the field value on the struct (just set it hard)
-		field := obj.FieldByName(structFieldName)
-		fieldType, _ := obj.Type().FieldByName(structFieldName)
+		if obj.Kind() == reflect.Ptr {
+			obj = obj.Elem()
+		}
 
-		// We did not find the field on the struct
-		if field.Kind() == reflect.Invalid {
-			return reflect.ValueOf(nil), errors.New(fmt.Sprintf("field %v not found on struct %v", fieldName, obj))
+		// Mapped fields are decoded by decodeElement, the others go into
+		// the inlined map or are skipped
+		if typeInfo.InlineMap != nil {
+			doc, isDocumentField := p.newInterfaceDocument()
+			err := addValueToInlineMap(fieldName, obj.FieldByIndex(typeInfo.InlineMap), doc)
+			return reflect.ValueOf(doc), isDocumentField, err
 		}
 
-		switch field.Kind() {
-		case reflect.Ptr:
-			// Get raw type
-			underlyingType := fieldType.Type.Elem()
+		// Skip the document
+		return reflect.Value{}, false, nil
+	}
+
+	return reflect.ValueOf(nil), false, errors.New(fmt.Sprintf("could not decode document into field %v", fieldName))
+}
 
-			// Create an instance
-			value := reflect.New(underlyingType)
+var rawType = reflect.TypeOf(Raw{})
+var setterType = reflect.TypeOf((*Setter)(nil)).Elem()
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
 
-			// Set the field on the struct
-			field.Set(value)
+// Does the type decode itself, pointer types must implement Setter or
+// Unmarshaler, other types through their pointer
+func implementsRawDecoding(t reflect.Type) bool {
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
 
-			// Return the new value
-			return value, nil
+	return t.Implements(setterType) || t.Implements(unmarshalerType)
+}
+
+// Call SetBSON or UnmarshalBSON on a pointer value
+func callSetter(value reflect.Value, raw Raw) error {
+	switch t := value.Interface().(type) {
+	case Setter:
+		return t.SetBSON(raw)
+	case Unmarshaler:
+		return t.UnmarshalBSON(raw.Data)
+	}
+
+	return errors.New(fmt.Sprintf("type %v does not implement Setter or Unmarshaler", value.Type()))
+}
+
+// Decodes a BSON value into a struct field, chosen once per field type,
+// returns false if the value has to be decoded with the default mapping
+type fieldDecoder func(bson []byte, index int, bsonType byte, fieldName string, field reflect.Value) (bool, int, error)
+
+var stringType = reflect.TypeOf("")
+var boolType = reflect.TypeOf(false)
+var float64Type = reflect.TypeOf(float64(0))
+var intType = reflect.TypeOf(int(0))
+var int32Type = reflect.TypeOf(int32(0))
+var int64Type = reflect.TypeOf(int64(0))
+var dateType = reflect.TypeOf(Date{})
+
+// Choose the decoder for a struct field of the given type, returns nil if
+// the field is always decoded with the default mapping
+func newFieldDecoder(fieldType reflect.Type) fieldDecoder {
+	// Let fields of type Raw or implementing Setter or Unmarshaler decode themselves
+	if fieldType == rawType || implementsRawDecoding(fieldType) {
+		return decodeRawField
+	}
+
+	switch fieldType {
+	case stringType:
+		return decodeStringField
+	case boolType:
+		return decodeBoolField
+	case float64Type:
+		return decodeFloat64Field
+	case intType, int32Type:
+		return decodeInt32Field
+	case int64Type:
+		return decodeInt64Field
+	}
+
+	return nil
+}
+
+func decodeRawField(bson []byte, index int, bsonType byte, fieldName string, field reflect.Value) (bool, int, error) {
+	// Find the end of the value
+	nextIndex, err := valueEnd(bson, index, bsonType)
+	if err != nil {
+		return false, index, errors.New(fmt.Sprintf("field %v: %v", fieldName, err))
+	}
+
+	err = setRawValue(field, Raw{bsonType, bson[index:nextIndex]})
+	if err != nil {
+		return false, index, errors.New(fmt.Sprintf("field %v: %v", fieldName, err))
+	}
+
+	return true, nextIndex, nil
+}
+
+func decodeStringField(bson []byte, index int, bsonType byte, fieldName string, field reflect.Value) (bool, int, error) {
+	if bsonType != byte(bsonString) {
+		return false, index, nil
+	}
+
+	value, nextIndex := readString(bson, index)
+	field.SetString(value)
+	return true, nextIndex, nil
+}
+
+func decodeBoolField(bson []byte, index int, bsonType byte, fieldName string, field reflect.Value) (bool, int, error) {
+	if bsonType != byte(bsonBoolean) {
+		return false, index, nil
+	}
+
+	field.SetBool(bson[index] == 1)
+	return true, index + 1, nil
+}
+
+func decodeFloat64Field(bson []byte, index int, bsonType byte, fieldName string, field reflect.Value) (bool, int, error) {
+	if bsonType != byte(bsonDouble) {
+		return false, index, nil
+	}
+
+	field.SetFloat(math.Float64frombits(readUInt64(bson, index)))
+	return true, index + 8, nil
+}
+
+func decodeInt32Field(bson []byte, index int, bsonType byte, fieldName string, field reflect.Value) (bool, int, error) {
+	if bsonType != byte(bsonInt32) {
+		return false, index, nil
+	}
+
+	field.SetInt(int64(int32(readUInt32(bson, index))))
+	return true, index + 4, nil
+}
+
+// Int64 fields also take the int32 values written with minsize
+func decodeInt64Field(bson []byte, index int, bsonType byte, fieldName string, field reflect.Value) (bool, int, error) {
+	switch bsonType {
+	case byte(bsonInt32):
+		field.SetInt(int64(int32(readUInt32(bson, index))))
+		return true, index + 4, nil
+	case byte(bsonInt64):
+		field.SetInt(int64(readUInt64(bson, index)))
+		return true, index + 8, nil
+	}
+
+	return false, index, nil
+}
+
+// Set a value of type Raw or implementing Setter or Unmarshaler
+func setRawValue(field reflect.Value, raw Raw) error {
+	// Raw values keep a copy of the data
+	if field.Type() == rawType {
+		data := make([]byte, len(raw.Data))
+		copy(data, raw.Data)
+		field.Set(reflect.ValueOf(Raw{raw.Kind, data}))
+		return nil
+	}
+
+	// Pointer fields are set to a new value
+	var target reflect.Value
+	if field.Kind() == reflect.Ptr {
+		target = reflect.New(field.Type().Elem())
+	} else {
+		target = field.Addr()
+	}
+
+	// UnmarshalBSON only takes documents, null resets the field like it
+	// does for the default type mapping
+	if _, isSetter := target.Interface().(Setter); !isSetter && raw.Kind == byte(bsonNull) {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+
+	err := callSetter(target, raw)
+	if err == ErrSetZero {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if field.Kind() == reflect.Ptr {
+		field.Set(target)
+	}
+
+	return nil
+}
+
+// Create the value embedded documents are decoded into for interface{}
+// targets, returning if it is a *Document
+func (p *BSON) newInterfaceDocument() (interface{}, bool) {
+	if p.documentType == DocumentTypeMap {
+		return make(map[string]interface{}), false
+	}
+
+	return NewDocument(), true
+}
+
+// Document type of the values decoded for a target, the values of a
+// *Document are always decoded as *Document
+func (p *BSON) valueDocumentType(isDocument bool) DocumentType {
+	if isDocument {
+		return DocumentTypeDocument
+	}
+
+	return p.documentType
+}
+
+// Decode the elements of a document into a map with string keys
+func (p *BSON) deserializeMap(bson []byte, index int, value reflect.Value) error {
+	if value.Type().Key().Kind() != reflect.String {
+		return errors.New(fmt.Sprintf("cannot decode a document into map type %v, keys must be strings", value.Type()))
+	}
+
+	endIndex := index + int(readUInt32(bson, index))
+
+	// Skip the size document
+	index = index + 4
+
+	for index < endIndex-1 {
+		// Get the bson type
+		bsonType := bson[index]
+
+		// Read the field name
+		var fieldName string
+		var err error
+		fieldName, index, err = readCString(bson, index+1)
+		if err != nil {
+			return err
+		}
+
+		// Decode into a new element
+		element := reflect.New(value.Type().Elem()).Elem()
+		index, err = p.decodeElement(bson, index, bsonType, fieldName, element)
+		if err != nil {
+			return err
 		}
+
+		value.SetMapIndex(reflect.ValueOf(fieldName).Convert(value.Type().Key()), element)
+	}
+
+	return nil
+}
+
+// Decode a value with the decoder registered for the type of target,
+// returns false if there is none
+func decodeRegistered(codecs *registryCodecs, bson []byte, index int, bsonType byte, fieldName string, target reflect.Value) (bool, int, error) {
+	decode := codecs.decoder(bsonType, target.Type())
+
+	// Pointers use the decoder of the type they point to like the encoder
+	// does, null sets them to nil
+	element := target
+	if decode == nil && target.Kind() == reflect.Ptr {
+		decode = codecs.decoder(bsonType, target.Type().Elem())
+		if decode != nil && bsonType == byte(bsonNull) {
+			target.Set(reflect.Zero(target.Type()))
+			return true, index, nil
+		}
+
+		element = reflect.New(target.Type().Elem()).Elem()
+	}
+
+	if decode == nil {
+		return false, index, nil
+	}
+
+	nextIndex, err := valueEnd(bson, index, bsonType)
+	if err != nil {
+		return false, index, errors.New(fmt.Sprintf("field %v: %v", fieldName, err))
+	}
+
+	err = decode(Raw{bsonType, bson[index:nextIndex]}, element)
+	if err != nil {
+		return false, index, errors.New(fmt.Sprintf("field %v: %v", fieldName, err))
+	}
+
+	// Set the pointer to the decoded value
+	if target.Kind() == reflect.Ptr && element.Type() != target.Type() {
+		target.Set(element.Addr())
+	}
+
+	return true, nextIndex, nil
+}
+
+// Decode a single value into target returning the index after the value
+func (p *BSON) decodeElement(bson []byte, index int, bsonType byte, fieldName string, target reflect.Value) (int, error) {
+	// Registered decoders come first
+	if handled, nextIndex, err := decodeRegistered(p.registry.load(), bson, index, bsonType, fieldName, target); handled || err != nil {
+		return nextIndex, err
+	}
+
+	// Let the target decode itself
+	if target.Type() == rawType || implementsRawDecoding(target.Type()) {
+		nextIndex, err := valueEnd(bson, index, bsonType)
+		if err != nil {
+			return index, errors.New(fmt.Sprintf("field %v: %v", fieldName, err))
+		}
+
+		err = setRawValue(target, Raw{bsonType, bson[index:nextIndex]})
+		if err != nil {
+			return index, errors.New(fmt.Sprintf("field %v: %v", fieldName, err))
+		}
+
+		return nextIndex, nil
+	}
+
+	// Embedded documents into *Document, maps and structs
+	if bsonType == byte(bsonDocument) {
+		documentSize := int(readUInt32(bson, index))
+		document := bson[index : index+documentSize]
+
+		if target.Type() == reflect.TypeOf(&Document{}) {
+			doc := NewDocument()
+			target.Set(reflect.ValueOf(doc))
+			return index + documentSize, p.deserializeObject(document, 0, reflect.ValueOf(doc), true)
+		}
+
+		// Pointers are set to a new value
+		element := target
+		if target.Kind() == reflect.Ptr {
+			element = reflect.New(target.Type().Elem()).Elem()
+		}
+
+		if element.Kind() == reflect.Map || element.Kind() == reflect.Struct {
+			if element.Kind() == reflect.Map {
+				element.Set(reflect.MakeMap(element.Type()))
+			}
+
+			err := p.deserializeObject(document, 0, element, false)
+			if err != nil {
+				return index, prefixDecodeError(fieldName, err)
+			}
+
+			if target.Kind() == reflect.Ptr {
+				target.Set(element.Addr())
+			}
+
+			return index + documentSize, nil
+		}
+	}
+
+	// Arrays into slices, arrays and pointers to them
+	if bsonType == byte(bsonArray) {
+		element := target
+		if target.Kind() == reflect.Ptr {
+			element = reflect.New(target.Type().Elem()).Elem()
+		}
+
+		if (element.Kind() == reflect.Slice && element.Type().Elem().Kind() != reflect.Uint8) || element.Kind() == reflect.Array {
+			nextIndex, err := p.decodeArray(bson, index, fieldName, element)
+			if err != nil {
+				return index, err
+			}
+
+			if target.Kind() == reflect.Ptr {
+				target.Set(element.Addr())
+			}
+
+			return nextIndex, nil
+		}
+	}
+
+	// Any other value is read with the default type mapping
+	value, nextIndex, err := p.readValue(bson, index, bsonType, p.documentType)
+	if err != nil {
+		return index, errors.New(fmt.Sprintf("field %v: %v", fieldName, err))
+	}
+
+	return nextIndex, assignValue(fieldName, target, value)
+}
+
+// Decode the elements of a BSON array into a slice or a Go array, each
+// element is decoded into the element type. Go arrays must be large
+// enough for all the elements, the elements after the last one are zeroed.
+func (p *BSON) decodeArray(bson []byte, index int, fieldName string, target reflect.Value) (int, error) {
+	endIndex := index + int(readUInt32(bson, index))
+	elementType := target.Type().Elem()
+
+	// Slices are decoded into a new slice
+	slice := target
+	if target.Kind() == reflect.Slice {
+		slice = reflect.MakeSlice(target.Type(), 0, 0)
+	}
+
+	length := 0
+	for elementIndex := index + 4; elementIndex < endIndex-1; length++ {
+		bsonType := bson[elementIndex]
+
+		// The keys are ignored, elements are named by their position
+		_, valueIndex, err := readCString(bson, elementIndex+1)
+		if err != nil {
+			return index, err
+		}
+
+		var element reflect.Value
+		if target.Kind() == reflect.Slice {
+			element = reflect.New(elementType).Elem()
+		} else if length < target.Len() {
+			element = target.Index(length)
+		} else {
+			return index, newDecodeError(fieldName, "array has more than the %v elements of %v", target.Len(), target.Type())
+		}
+
+		elementIndex, err = p.decodeElement(bson, valueIndex, bsonType, joinPath(fieldName, itoa(length)), element)
+		if err != nil {
+			return index, err
+		}
+
+		if target.Kind() == reflect.Slice {
+			slice = reflect.Append(slice, element)
+		}
+	}
+
+	if target.Kind() == reflect.Slice {
+		target.Set(slice)
+		return endIndex, nil
+	}
+
+	// Zero the elements that were not in the BSON array
+	for i := length; i < target.Len(); i++ {
+		target.Index(i).Set(reflect.Zero(elementType))
+	}
+
+	return endIndex, nil
+}
+
+// Decode the raw value into out, documents can be decoded into a struct
+// or *Document, other values into a pointer to a matching type
+func (p Raw) Unmarshal(out interface{}) error {
+	parser := defaultBSON
+	value := reflect.ValueOf(out)
+
+	// Registered decoders come first
+	if value.Kind() == reflect.Ptr && !value.IsNil() {
+		if decode := parser.registry.load().decoder(p.Kind, value.Elem().Type()); decode != nil {
+			if _, err := validateValue(p.Data, 0, len(p.Data), p.Kind, ""); err != nil {
+				return err
+			}
+
+			return decode(p, value.Elem())
+		}
+	}
+
+	if p.Kind == byte(bsonDocument) {
+		return parser.Unmarshal(p.Data, out)
+	}
+
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return errors.New("must be a non nil pointer")
+	}
+
+	// Check the value before decoding it
+	end, err := validateValue(p.Data, 0, len(p.Data), p.Kind, "")
+	if err != nil {
+		return err
+	}
+
+	if end != len(p.Data) {
+		return newValidationError(end, "", "unexpected %v bytes after the value", len(p.Data)-end)
 	}
 
-	return reflect.ValueOf(nil), errors.New("could no correctly add document to struct")
+	_, err = parser.decodeElement(p.Data, 0, p.Kind, "", value.Elem())
+	return err
 }
@@ -0,0 +1,716 @@
+package mongo
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testObjectId = []byte{0x54, 0xd3, 0x8e, 0x1f, 0x5d, 0x3a, 0x1c, 0x2b, 0x11, 0x22, 0x33, 0x44}
+
+// Document holding every BSON type as returned by the decoder
+func allTypesDocument() *Document {
+	scope := NewDocument()
+	scope.Add("a", int32(1))
+	subdocument := NewDocument()
+	subdocument.Add("int", int32(10))
+	arrayDocument := NewDocument()
+	arrayDocument.Add("b", "c")
+
+	document := NewDocument()
+	document.Add("double", 1.5)
+	document.Add("string", "hello world")
+	document.Add("doc", subdocument)
+	document.Add("array", []interface{}{"a", int32(1), arrayDocument, []interface{}{true}})
+	document.Add("binary", &Binary{0x04, []byte{1, 2, 3}})
+	document.Add("undefined", &Undefined{})
+	document.Add("objectid", &ObjectId{testObjectId})
+	document.Add("bool", true)
+	document.Add("time", time.Date(2015, 2, 5, 10, 30, 0, 123000000, time.UTC))
+	document.Add("null", nil)
+	document.Add("regexp", &RegExp{"^a", "i"})
+	document.Add("dbpointer", &DBPointer{"test.users", ObjectId{testObjectId}})
+	document.Add("javascript", &Javascript{"function() {}"})
+	document.Add("symbol", &Symbol{"symbol"})
+	document.Add("javascriptwscope", &JavascriptWScope{"function() {}", scope})
+	document.Add("int32", int32(-32))
+	document.Add("timestamp", &Timestamp{1<<32 | 5})
+	document.Add("int64", int64(1<<40))
+	document.Add("min", &Min{})
+	document.Add("max", &Max{})
+	return document
+}
+
+func TestDocumentDeserializationAllTypes(t *testing.T) {
+	document := allTypesDocument()
+
+	parser := NewBSON()
+	b, err := parser.Marshall(document, nil, 0)
+	if err != nil {
+		t.Fatalf("Failed to marshall document %v", err)
+	}
+
+	DeserializeTest(t, b, NewDocument(), document)
+}
+
+func TestDocumentGetters(t *testing.T) {
+	parser := NewBSON()
+	b, _ := parser.Marshall(allTypesDocument(), nil, 0)
+	document := NewDocument()
+	err := parser.Unmarshal(b, document)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal %v", err)
+	}
+
+	if value, err := document.Float64("double"); err != nil || value != 1.5 {
+		t.Errorf("unexpected double %v %v", value, err)
+	}
+
+	if value, err := document.Bool("bool"); err != nil || !value {
+		t.Errorf("unexpected bool %v %v", value, err)
+	}
+
+	if value, err := document.ObjectId("objectid"); err != nil || !reflect.DeepEqual(value.Id, testObjectId) {
+		t.Errorf("unexpected object id %v %v", value, err)
+	}
+
+	if value, err := document.Binary("binary"); err != nil || value.SubType != 0x04 {
+		t.Errorf("unexpected binary %v %v", value, err)
+	}
+
+	if value, err := document.Time("time"); err != nil || value.UnixNano() != time.Date(2015, 2, 5, 10, 30, 0, 123000000, time.UTC).UnixNano() {
+		t.Errorf("unexpected time %v %v", value, err)
+	}
+
+	if value, err := document.Timestamp("timestamp"); err != nil || value.Value != 1<<32|5 {
+		t.Errorf("unexpected timestamp %v %v", value, err)
+	}
+
+	if value, err := document.Array("array"); err != nil || len(value) != 4 {
+		t.Errorf("unexpected array %v %v", value, err)
+	}
+
+	if value, err := document.RegExp("regexp"); err != nil || value.Pattern != "^a" || value.Options != "i" {
+		t.Errorf("unexpected regexp %v %v", value, err)
+	}
+
+	if value, err := document.JavascriptWScope("javascriptwscope"); err != nil || value.Scope.FieldCount() != 1 {
+		t.Errorf("unexpected javascript with scope %v %v", value, err)
+	}
+
+	if value, err := document.Int64("int64"); err != nil || value != 1<<40 {
+		t.Errorf("unexpected int64 %v %v", value, err)
+	}
+
+	if _, err := document.Nil("null"); err != nil {
+		t.Errorf("unexpected null %v", err)
+	}
+
+	if _, err := document.Min("min"); err != nil {
+		t.Errorf("unexpected min %v", err)
+	}
+
+	if _, err := document.Max("max"); err != nil {
+		t.Errorf("unexpected max %v", err)
+	}
+}
+
+func TestStructDeserializationAllTypes(t *testing.T) {
+	type Inner struct {
+		Name string `bson:"name"`
+	}
+
+	type AllTypes struct {
+		Double    float64          `bson:"double"`
+		String    string           `bson:"string"`
+		Doc       *Inner           `bson:"doc"`
+		Interface interface{}      `bson:"interface"`
+		Strings   []string         `bson:"strings"`
+		Array     []interface{}    `bson:"array"`
+		Binary    Binary           `bson:"binary"`
+		Bytes     []byte           `bson:"bytes"`
+		ObjectId  ObjectId         `bson:"objectid"`
+		Pointer   *ObjectId        `bson:"pointer"`
+		Bool      bool             `bson:"bool"`
+		Time      time.Time        `bson:"time"`
+		Null      *Inner           `bson:"null"`
+		RegExp    RegExp           `bson:"regexp"`
+		Code      Javascript       `bson:"code"`
+		Scope     JavascriptWScope `bson:"scope"`
+		Int32     int32            `bson:"int32"`
+		Timestamp Timestamp        `bson:"timestamp"`
+		Int64     int64            `bson:"int64"`
+		Min       Min              `bson:"min"`
+		Max       Max              `bson:"max"`
+	}
+
+	date := time.Date(2015, 2, 5, 10, 30, 0, 123000000, time.UTC)
+	b, err := bson.Marshal(bson.D{
+		{Name: "double", Value: 1.5},
+		{Name: "string", Value: "hello world"},
+		{Name: "doc", Value: bson.M{"name": "inner"}},
+		{Name: "interface", Value: bson.M{"a": 1}},
+		{Name: "strings", Value: []string{"a", "b"}},
+		{Name: "array", Value: []interface{}{"a", 1}},
+		{Name: "binary", Value: bson.Binary{Kind: 0x80, Data: []byte{1}}},
+		{Name: "bytes", Value: []byte{1, 2}},
+		{Name: "objectid", Value: bson.ObjectId(testObjectId)},
+		{Name: "pointer", Value: bson.ObjectId(testObjectId)},
+		{Name: "bool", Value: true},
+		{Name: "time", Value: date},
+		{Name: "null", Value: nil},
+		{Name: "regexp", Value: bson.RegEx{Pattern: "^a", Options: "i"}},
+		{Name: "code", Value: bson.JavaScript{Code: "function() {}"}},
+		{Name: "scope", Value: bson.JavaScript{Code: "function() {}", Scope: bson.M{"a": 1}}},
+		{Name: "int32", Value: int32(32)},
+		{Name: "timestamp", Value: bson.MongoTimestamp(5)},
+		{Name: "int64", Value: int64(64)},
+		{Name: "min", Value: bson.MinKey},
+		{Name: "max", Value: bson.MaxKey},
+		{Name: "unknown", Value: bson.M{"ignored": true}},
+		{Name: "unknownValue", Value: 1.5},
+	})
+
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	interfaceDocument := NewDocument()
+	interfaceDocument.Add("a", int32(1))
+	scope := NewDocument()
+	scope.Add("a", int32(1))
+
+	DeserializeTest(t, b, &AllTypes{}, &AllTypes{
+		Double:    1.5,
+		String:    "hello world",
+		Doc:       &Inner{"inner"},
+		Interface: interfaceDocument,
+		Strings:   []string{"a", "b"},
+		Array:     []interface{}{"a", int32(1)},
+		Binary:    Binary{0x80, []byte{1}},
+		Bytes:     []byte{1, 2},
+		ObjectId:  ObjectId{testObjectId},
+		Pointer:   &ObjectId{testObjectId},
+		Bool:      true,
+		Time:      date,
+		RegExp:    RegExp{"^a", "i"},
+		Code:      Javascript{"function() {}"},
+		Scope:     JavascriptWScope{"function() {}", scope},
+		Int32:     32,
+		Timestamp: Timestamp{5},
+		Int64:     64,
+	})
+}
+
+func TestDateRoundTrip(t *testing.T) {
+	type Dates struct {
+		D       Date  `bson:"d"`
+		Before  Date  `bson:"before"`
+		Pointer *Date `bson:"pointer"`
+	}
+
+	value := &Dates{Date{1423132200123}, Date{-1}, &Date{0}}
+
+	parser := NewBSON()
+	b, err := parser.Marshall(value, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to marshal %v", err)
+	}
+
+	DeserializeTest(t, b, &Dates{}, value)
+}
+
+func TestByteArrayRoundTrip(t *testing.T) {
+	type Arrays struct {
+		Four    [4]byte  `bson:"four"`
+		Empty   [0]byte  `bson:"empty"`
+		Pointer *[2]byte `bson:"pointer"`
+	}
+
+	value := &Arrays{[4]byte{1, 2, 3, 4}, [0]byte{}, &[2]byte{5, 6}}
+
+	parser := NewBSON()
+	b, err := parser.Marshall(value, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to marshal %v", err)
+	}
+
+	DeserializeTest(t, b, &Arrays{}, value)
+
+	// The binary must have the length of the array
+	type Short struct {
+		Four [3]byte `bson:"four"`
+	}
+
+	err = parser.Unmarshal(b, &Short{})
+	if err == nil || err.Error() != "field four: cannot decode 4 bytes of binary into type [3]uint8" {
+		t.Fatalf("expected a length mismatch error, got %v", err)
+	}
+}
+
+func TestDeserializationUnknownType(t *testing.T) {
+	// Document with a single element of type 0x20
+	b := []byte{12, 0, 0, 0, 0x20, 97, 0, 1, 0, 0, 0, 0}
+
+	parser := NewBSON()
+	err := parser.Unmarshal(b, NewDocument())
+	if err == nil {
+		t.Fatalf("expected an error decoding an unknown BSON type")
+	}
+}
+
+func TestUnmarshalInvalidTarget(t *testing.T) {
+	type T struct {
+		Int int32 `bson:"int"`
+	}
+
+	var nilStruct *T
+	var nilDocument *Document
+	var nilRaw *Raw
+	var nilMap map[string]interface{}
+
+	b, _ := bson.Marshal(bson.M{"int": 1})
+
+	parser := NewBSON()
+	for _, test := range []struct {
+		name   string
+		target interface{}
+	}{
+		{"struct", T{}},
+		{"nil pointer", nilStruct},
+		{"nil document", nilDocument},
+		{"nil raw", nilRaw},
+		{"nil map", nilMap},
+		{"nil", nil},
+		{"string", "not a pointer"},
+	} {
+		if err := parser.Unmarshal(b, test.target); err == nil {
+			t.Errorf("%v: expected an error decoding into %T", test.name, test.target)
+		}
+	}
+}
+
+func TestDeserializationTypeMismatch(t *testing.T) {
+	type T struct {
+		Int int32 `bson:"int"`
+	}
+
+	b, _ := bson.Marshal(bson.M{"int": "not an int"})
+
+	parser := NewBSON()
+	err := parser.Unmarshal(b, &T{})
+	if err == nil {
+		t.Fatalf("expected an error decoding a string into an int32 field")
+	}
+}
+
+// Decodes a string value upper cased
+type upperString string
+
+func (p *upperString) SetBSON(raw Raw) error {
+	var value string
+	err := raw.Unmarshal(&value)
+	if err != nil {
+		return err
+	}
+
+	*p = upperString(strings.ToUpper(value))
+	return nil
+}
+
+// Keeps the length of the raw value
+type rawLength int
+
+func (p *rawLength) UnmarshalBSON(data []byte) error {
+	*p = rawLength(len(data))
+	return nil
+}
+
+// Asks to be reset
+type zeroSetter struct {
+	Value string
+}
+
+func (p *zeroSetter) SetBSON(raw Raw) error {
+	return ErrSetZero
+}
+
+// Decodes the whole document itself
+type documentSetter struct {
+	Kind   byte
+	Fields int
+}
+
+func (p *documentSetter) SetBSON(raw Raw) error {
+	document := NewDocument()
+	err := raw.Unmarshal(document)
+	if err != nil {
+		return err
+	}
+
+	p.Kind = raw.Kind
+	p.Fields = document.FieldCount()
+	return nil
+}
+
+func TestSetterAndUnmarshaler(t *testing.T) {
+	type T struct {
+		Upper   upperString  `bson:"upper"`
+		Pointer *upperString `bson:"pointer"`
+		Length  rawLength    `bson:"length"`
+		Zero    zeroSetter   `bson:"zero"`
+		Raw     Raw          `bson:"raw"`
+		Doc     Raw          `bson:"doc"`
+	}
+
+	b, err := bson.Marshal(bson.D{
+		{Name: "upper", Value: "hello"},
+		{Name: "pointer", Value: "world"},
+		{Name: "length", Value: bson.M{"a": 1}},
+		{Name: "zero", Value: "ignored"},
+		{Name: "raw", Value: int32(5)},
+		{Name: "doc", Value: bson.M{"b": true}},
+	})
+
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	pointer := upperString("WORLD")
+	docBytes, _ := bson.Marshal(bson.M{"b": true})
+
+	DeserializeTest(t, b, &T{Zero: zeroSetter{"set"}}, &T{
+		Upper:   "HELLO",
+		Pointer: &pointer,
+		Length:  12,
+		Raw:     Raw{0x10, []byte{5, 0, 0, 0}},
+		Doc:     Raw{0x03, docBytes},
+	})
+
+	// Raw values are encoded as is
+	SerializeTest(t, &struct {
+		Raw Raw `bson:"raw"`
+		Doc Raw `bson:"doc"`
+	}{Raw{0x10, []byte{5, 0, 0, 0}}, Raw{0x03, docBytes}}, mustMarshal(t, bson.D{
+		{Name: "raw", Value: int32(5)},
+		{Name: "doc", Value: bson.M{"b": true}},
+	}))
+}
+
+func TestTopLevelSetter(t *testing.T) {
+	b, _ := bson.Marshal(bson.M{"a": 1, "b": 2})
+	parser := NewBSON()
+
+	setter := &documentSetter{}
+	err := parser.Unmarshal(b, setter)
+	if err != nil || setter.Kind != 0x03 || setter.Fields != 2 {
+		t.Errorf("unexpected setter state %v %v", setter, err)
+	}
+
+	raw := &Raw{}
+	err = parser.Unmarshal(b, raw)
+	if err != nil || raw.Kind != 0x03 || !reflect.DeepEqual(raw.Data, b) {
+		t.Errorf("unexpected raw value %v %v", raw, err)
+	}
+}
+
+func mustMarshal(t *testing.T, doc interface{}) []byte {
+	b, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	return b
+}
+
+func mapTestBSON(t *testing.T) []byte {
+	return mustMarshal(t, bson.D{
+		{Name: "string", Value: "hello"},
+		{Name: "int", Value: int32(1)},
+		{Name: "doc", Value: bson.D{{Name: "name", Value: "inner"}}},
+		{Name: "array", Value: []interface{}{int64(2), bson.D{{Name: "name", Value: "element"}}}},
+	})
+}
+
+func TestMapDeserialization(t *testing.T) {
+	b := mapTestBSON(t)
+	parser := NewBSON()
+
+	doc := NewDocument()
+	doc.Add("name", "inner")
+	element := NewDocument()
+	element.Add("name", "element")
+
+	// Nested documents default to *Document
+	DeserializeTest(t, b, &map[string]interface{}{}, &map[string]interface{}{
+		"string": "hello",
+		"int":    int32(1),
+		"doc":    doc,
+		"array":  []interface{}{int64(2), element},
+	})
+
+	// Non pointer maps are filled in place
+	m := map[string]interface{}{"existing": true}
+	err := parser.Unmarshal(b, m)
+	if err != nil || len(m) != 5 || m["string"] != "hello" {
+		t.Errorf("unexpected map %v %v", m, err)
+	}
+
+	// A nil map needs a pointer
+	var nilMap map[string]interface{}
+	if err := parser.Unmarshal(b, nilMap); err == nil {
+		t.Errorf("expected an error decoding into a nil map")
+	}
+
+	if err := parser.Unmarshal(b, &nilMap); err != nil || len(nilMap) != 4 {
+		t.Errorf("unexpected map %v %v", nilMap, err)
+	}
+
+	// Nested documents as maps
+	parser.SetDocumentType(DocumentTypeMap)
+	result := map[string]interface{}{}
+	err = parser.Unmarshal(b, &result)
+	if err != nil || !reflect.DeepEqual(result, map[string]interface{}{
+		"string": "hello",
+		"int":    int32(1),
+		"doc":    map[string]interface{}{"name": "inner"},
+		"array":  []interface{}{int64(2), map[string]interface{}{"name": "element"}},
+	}) {
+		t.Errorf("unexpected map %v %v", result, err)
+	}
+
+	// Keys must be strings
+	if err := parser.Unmarshal(b, &map[int]interface{}{}); err == nil {
+		t.Errorf("expected an error decoding into a map with int keys")
+	}
+}
+
+func TestTypedMapDeserialization(t *testing.T) {
+	type Inner struct {
+		Name string `bson:"name"`
+	}
+
+	type T struct {
+		Counts    map[string]int64       `bson:"counts"`
+		Inners    map[string]Inner       `bson:"inners"`
+		Pointers  map[string]*Inner      `bson:"pointers"`
+		Interface interface{}            `bson:"interface"`
+		Values    map[string]interface{} `bson:"values"`
+	}
+
+	b := mustMarshal(t, bson.D{
+		{Name: "counts", Value: bson.D{{Name: "a", Value: int64(1)}, {Name: "b", Value: int64(2)}}},
+		{Name: "inners", Value: bson.M{"a": bson.M{"name": "a"}}},
+		{Name: "pointers", Value: bson.M{"b": bson.M{"name": "b"}, "c": nil}},
+		{Name: "interface", Value: bson.M{"a": bson.M{"b": "c"}}},
+		{Name: "values", Value: bson.M{"a": bson.M{"b": "c"}}},
+	})
+
+	// Typed maps do not depend on the document type
+	interfaceDocument := NewDocument()
+	interfaceDocument.Add("a", NewDocument())
+	interfaceDocument.document["a"].(*Document).Add("b", "c")
+
+	DeserializeTest(t, b, &T{}, &T{
+		Counts:    map[string]int64{"a": 1, "b": 2},
+		Inners:    map[string]Inner{"a": {"a"}},
+		Pointers:  map[string]*Inner{"b": {"b"}, "c": nil},
+		Interface: interfaceDocument,
+		Values:    map[string]interface{}{"a": interfaceDocument.document["a"]},
+	})
+
+	parser := NewBSON()
+	parser.SetDocumentType(DocumentTypeMap)
+	result := &T{}
+	err := parser.Unmarshal(b, result)
+	if err != nil || !reflect.DeepEqual(result, &T{
+		Counts:    map[string]int64{"a": 1, "b": 2},
+		Inners:    map[string]Inner{"a": {"a"}},
+		Pointers:  map[string]*Inner{"b": {"b"}, "c": nil},
+		Interface: map[string]interface{}{"a": map[string]interface{}{"b": "c"}},
+		Values:    map[string]interface{}{"a": map[string]interface{}{"b": "c"}},
+	}) {
+		t.Errorf("unexpected struct %+v %v", result, err)
+	}
+
+	// Values of the wrong type return an error
+	if err := parser.Unmarshal(mapTestBSON(t), &map[string]int32{}); err == nil {
+		t.Errorf("expected an error decoding a string into an int32 map value")
+	}
+}
+
+func TestInterfaceAndSliceDeserialization(t *testing.T) {
+	b := mustMarshal(t, bson.D{{Name: "a", Value: "b"}, {Name: "c", Value: int32(1)}})
+	parser := NewBSON()
+
+	var value interface{}
+	err := parser.Unmarshal(b, &value)
+	if document, ok := value.(*Document); err != nil || !ok || document.FieldCount() != 2 {
+		t.Errorf("unexpected interface value %v %v", value, err)
+	}
+
+	parser.SetDocumentType(DocumentTypeMap)
+	err = parser.Unmarshal(b, &value)
+	if err != nil || !reflect.DeepEqual(value, map[string]interface{}{"a": "b", "c": int32(1)}) {
+		t.Errorf("unexpected interface value %v %v", value, err)
+	}
+
+	// Slices receive the values in order
+	var values []interface{}
+	err = parser.Unmarshal(b, &values)
+	if err != nil || !reflect.DeepEqual(values, []interface{}{"b", int32(1)}) {
+		t.Errorf("unexpected slice %v %v", values, err)
+	}
+}
+
+func TestSimpleArrayDeserialization(t *testing.T) {
+	var buffer = []byte{35, 0, 0, 0, 4, 97, 114, 114, 97, 121, 0, 23, 0, 0, 0, 2, 48, 0, 2, 0, 0, 0, 97, 0, 2, 49, 0, 2, 0, 0, 0, 98, 0, 0, 0}
+
+	// Documents keep the array as []interface{}
+	document := NewDocument()
+	document.Add("array", []interface{}{"a", "b"})
+	DeserializeTest(t, buffer, NewDocument(), document)
+
+	type Slice struct {
+		Array []string `bson:"array"`
+	}
+
+	type Array struct {
+		Array [3]string `bson:"array"`
+	}
+
+	type Pointer struct {
+		Array *[]string `bson:"array"`
+	}
+
+	type Interface struct {
+		Array []interface{} `bson:"array"`
+	}
+
+	DeserializeTest(t, buffer, &Slice{}, &Slice{[]string{"a", "b"}})
+	DeserializeTest(t, buffer, &Array{[3]string{"x", "y", "z"}}, &Array{[3]string{"a", "b", ""}})
+	DeserializeTest(t, buffer, &Pointer{}, &Pointer{&[]string{"a", "b"}})
+	DeserializeTest(t, buffer, &Interface{}, &Interface{[]interface{}{"a", "b"}})
+
+	// Typed slices added to a document are returned as arrays
+	document = NewDocument()
+	document.Add("array", []string{"a", "b"})
+	if array, err := document.Array("array"); err != nil || !reflect.DeepEqual(array, []interface{}{"a", "b"}) {
+		t.Errorf("unexpected array %v %v", array, err)
+	}
+
+	document.Add("binary", []byte{1})
+	if _, err := document.Array("binary"); err == nil {
+		t.Errorf("expected an error reading a byte slice as an array")
+	}
+}
+
+func TestArrayOfStructsDeserialization(t *testing.T) {
+	type Item struct {
+		Name  string `bson:"name"`
+		Count int    `bson:"count"`
+	}
+
+	type Order struct {
+		Item     Item       `bson:"item"`
+		Pointer  *Item      `bson:"pointer"`
+		Items    []Item     `bson:"items"`
+		Pointers []*Item    `bson:"pointers"`
+		Fixed    [2]Item    `bson:"fixed"`
+		Matrix   [][]int32  `bson:"matrix"`
+		Nested   [][]Item   `bson:"nested"`
+		Empty    []Item     `bson:"empty"`
+		Null     []Item     `bson:"null"`
+		Values   []*float64 `bson:"values"`
+	}
+
+	one := 1.5
+	expected := &Order{
+		Item:     Item{"a", 1},
+		Pointer:  &Item{"b", 2},
+		Items:    []Item{{"c", 3}, {"d", 4}},
+		Pointers: []*Item{{"e", 5}, nil},
+		Fixed:    [2]Item{{"f", 6}, {}},
+		Matrix:   [][]int32{{1, 2}, {}, {3}},
+		Nested:   [][]Item{{{"g", 7}}},
+		Empty:    []Item{},
+		Values:   []*float64{&one, nil},
+	}
+
+	// The same document as encoded by mgo, with a single element for the
+	// fixed size array
+	b := mustMarshal(t, bson.D{
+		{Name: "item", Value: bson.M{"name": "a", "count": 1}},
+		{Name: "pointer", Value: bson.M{"name": "b", "count": 2}},
+		{Name: "items", Value: []bson.M{{"name": "c", "count": 3}, {"name": "d", "count": int64(4)}}},
+		{Name: "pointers", Value: []interface{}{bson.M{"name": "e", "count": 5}, nil}},
+		{Name: "fixed", Value: []bson.M{{"name": "f", "count": 6}}},
+		{Name: "matrix", Value: [][]int32{{1, 2}, {}, {3}}},
+		{Name: "nested", Value: [][]bson.M{{{"name": "g", "count": 7}}}},
+		{Name: "empty", Value: []bson.M{}},
+		{Name: "null", Value: nil},
+		{Name: "values", Value: []interface{}{1.5, nil}},
+	})
+
+	DeserializeTest(t, b, &Order{Null: []Item{{"x", 1}}, Fixed: [2]Item{{"y", 1}, {"z", 2}}}, expected)
+
+	// Round trip through the encoder
+	encoded, err := NewBSON().Marshall(expected, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to marshal %v", err)
+	}
+
+	// Nil slices are encoded as empty arrays
+	roundTrip := *expected
+	roundTrip.Null = []Item{}
+	DeserializeTest(t, encoded, &Order{}, &roundTrip)
+
+	// Top level arrays and raw values
+	var items []Item
+	array := mustMarshal(t, bson.D{{Name: "0", Value: bson.M{"name": "c", "count": 3}}})
+	if err := NewBSON().Unmarshal(array, &items); err != nil || !reflect.DeepEqual(items, []Item{{"c", 3}}) {
+		t.Errorf("unexpected items %v %v", items, err)
+	}
+
+	value, err := RawDocument(b).Lookup("items")
+	if err != nil {
+		t.Fatalf("failed to lookup items %v", err)
+	}
+
+	items = nil
+	if err := value.Unmarshal(&items); err != nil || !reflect.DeepEqual(items, expected.Items) {
+		t.Errorf("unexpected raw items %v %v", items, err)
+	}
+}
+
+func TestArrayDeserializationErrors(t *testing.T) {
+	type Item struct {
+		Count int8 `bson:"count"`
+	}
+
+	type T struct {
+		Fixed [1]Item `bson:"fixed"`
+		Items []Item  `bson:"items"`
+		Ints  []int   `bson:"ints"`
+	}
+
+	tests := []struct {
+		value   bson.D
+		message string
+	}{
+		{bson.D{{Name: "fixed", Value: []bson.M{{}, {}}}}, "field fixed: array has more than the 1 elements of [1]mongo.Item"},
+		{bson.D{{Name: "items", Value: []bson.M{{}, {"count": 300}}}}, "field items.1.count: value 300 overflows int8"},
+		{bson.D{{Name: "items", Value: []interface{}{"a"}}}, "field items.0: cannot decode BSON string into type mongo.Item"},
+		{bson.D{{Name: "ints", Value: []interface{}{1, 1.5}}}, "field ints.1: double 1.5 would be truncated to an integer"},
+		{bson.D{{Name: "items", Value: "a"}}, "field items: cannot decode BSON string into type []mongo.Item"},
+	}
+
+	for _, test := range tests {
+		err := NewBSON().Unmarshal(mustMarshal(t, test.value), &T{})
+		if err == nil || err.Error() != test.message {
+			t.Errorf("expected error %q got %v", test.message, err)
+		}
+	}
+}
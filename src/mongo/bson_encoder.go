@@ -3,17 +3,30 @@ package mongo
 import (
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
-	// "strings"
+	"sort"
 	"strconv"
+	"time"
 )
 
 type encoder struct {
 	out       []byte
 	index     int
 	typeInfos *TypeInfos
+	// Only advance the index to compute the size of the document
+	sizeOnly bool
+	// The registered encoders, nil if there are none
+	codecs *registryCodecs
 }
 
+func (p *BSON) newEncoder(out []byte, index int, sizeOnly bool) *encoder {
+	return &encoder{out, index, p.typeInfos, sizeOnly, p.registry.load()}
+}
+
+// Returned when the document does not fit the buffer passed to MarshallInto
+var ErrBufferTooSmall = errors.New("buffer too small for the document")
+
 // Number of pregenerated cache items
 const itoaCacheSize = 256
 
@@ -30,16 +43,11 @@ func init() {
 
 // Convert string
 func itoa(i int) string {
-	if itoaCache == nil {
-		itoaCache = make([]string, itoaCacheSize)
-	}
-
 	if i < itoaCacheSize {
 		return itoaCache[i]
 	}
 
-	itoaCache[i] = strconv.Itoa(i)
-	return itoaCache[i]
+	return strconv.Itoa(i)
 }
 
 //
@@ -62,7 +70,7 @@ func (p *BSON) Marshall(doc interface{}, buffer []byte, offset int) ([]byte, err
 		index = 0
 	}
 
-	encoder := &encoder{out, index, p.typeInfos}
+	encoder := p.newEncoder(out, index, false)
 	err := encoder.addDoc(reflect.ValueOf(doc))
 	if err != nil {
 		return nil, err
@@ -72,101 +80,503 @@ func (p *BSON) Marshall(doc interface{}, buffer []byte, offset int) ([]byte, err
 	return encoder.out[offset:encoder.index], nil
 }
 
-func (p *encoder) packString(originalIndex int, value reflect.Value) int {
-	// Get the string
-	str := value.String()
-	// Set the type
-	p.out[originalIndex] = 0x02
-	// Get the string bytes
-	stringBytes := []byte(str)
-	// Write the string length
-	writeU32(p.out, p.index+1, uint32(len(stringBytes)+1))
-	// Write bytes with bounds checking
-	p.writeBytes(stringBytes[:], p.index+5)
-	// Set end 0 byte
-	p.out[p.index+5+len(stringBytes)] = 0x00
-	// Return new index position
-	return p.index + 4 + len(stringBytes) + 1 + 1
-}
-
-func (p *encoder) writeBytes(bytes []byte, index int) {
-	// We need to allocate more memory
-	if len(p.out)-index < len(bytes) {
-		// Allocate a new buffer
-		memory := make([]byte, len(bytes)+initialAllocationSize+len(p.out))
-		// Copy existing buffer into it
-		copy(memory[0:], p.out[0:index])
-		// Point to new buffer
-		p.out = memory
+// Append the encoded document to dst and return the extended slice, like
+// append dst is only reallocated when its capacity is too small. On error
+// dst is returned unchanged.
+func (p *BSON) MarshalAppend(dst []byte, doc interface{}) ([]byte, error) {
+	encoder := p.newEncoder(dst[:cap(dst)], len(dst), false)
+	err := encoder.addDoc(reflect.ValueOf(doc))
+	if err != nil {
+		return dst, err
 	}
 
+	return encoder.out[:encoder.index], nil
+}
+
+// Compute the size of the encoded document without encoding it, GetBSON
+// methods are called as they are when encoding
+func (p *BSON) Size(doc interface{}) (int, error) {
+	encoder := p.newEncoder(nil, 0, true)
+	err := encoder.addDoc(reflect.ValueOf(doc))
+	if err != nil {
+		return 0, err
+	}
+
+	return encoder.index, nil
+}
+
+// Encode a value into a buffer of exactly its size, computing the size
+// first instead of growing the buffer while encoding
+func (p *BSON) MarshallExact(doc interface{}) ([]byte, error) {
+	size, err := p.Size(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	buffer := make([]byte, size)
+	err = p.marshallSized(doc, buffer, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer, nil
+}
+
+// Encode a value at the start of buffer without allocating, returns the
+// size of the document or ErrBufferTooSmall if it does not fit
+func (p *BSON) MarshallInto(doc interface{}, buffer []byte) (int, error) {
+	size, err := p.Size(doc)
+	if err != nil {
+		return 0, err
+	}
+
+	if size > len(buffer) {
+		return 0, ErrBufferTooSmall
+	}
+
+	return size, p.marshallSized(doc, buffer, size)
+}
+
+// Encode a value of a known size into the start of buffer
+func (p *BSON) marshallSized(doc interface{}, buffer []byte, size int) error {
+	encoder := p.newEncoder(buffer[:size], 0, false)
+	err := encoder.addDoc(reflect.ValueOf(doc))
+	if err != nil {
+		return err
+	}
+
+	// GetBSON returned a different document the second time, the encoder
+	// may have moved to a new buffer
+	if encoder.index != size || &encoder.out[0] != &buffer[0] {
+		return errors.New(fmt.Sprintf("document size changed from %v to %v while encoding", size, encoder.index))
+	}
+
+	return nil
+}
+
+// Ensure there are at least size bytes available after the current index
+func (p *encoder) ensure(size int) {
+	if len(p.out)-p.index >= size || p.sizeOnly {
+		return
+	}
+
+	// Allocate a new buffer
+	memory := make([]byte, 2*len(p.out)+size+initialAllocationSize)
+	// Copy existing buffer into it
+	copy(memory[0:], p.out[0:p.index])
+	// Point to new buffer
+	p.out = memory
+}
+
+func (p *encoder) writeBytes(bytes []byte) {
+	if p.sizeOnly {
+		p.index = p.index + len(bytes)
+		return
+	}
+
+	// We need to allocate more memory
+	p.ensure(len(bytes))
 	// Write the bytes into the buffer
-	copy(p.out[index:], bytes[:])
+	copy(p.out[p.index:], bytes[:])
+	p.index = p.index + len(bytes)
 }
 
-func (p *encoder) packElement(key string, value reflect.Value) error {
-	strbytes := []byte(key)
-	// Save a pointer to the first byte index
-	originalIndex := p.index
-	// Skip type
+func (p *encoder) writeByte(value byte) {
+	if p.sizeOnly {
+		p.index = p.index + 1
+		return
+	}
+
+	p.ensure(1)
+	p.out[p.index] = value
 	p.index = p.index + 1
+}
+
+func (p *encoder) writeInt32(value int32) {
+	if p.sizeOnly {
+		p.index = p.index + 4
+		return
+	}
+
+	p.ensure(4)
+	writeU32(p.out, p.index, uint32(value))
+	p.index = p.index + 4
+}
+
+func (p *encoder) writeInt64(value int64) {
+	if p.sizeOnly {
+		p.index = p.index + 8
+		return
+	}
 
-	// Write bytes with bounds checking
-	p.writeBytes(strbytes[0:], p.index)
-	// Null terminate the string
-	p.out[p.index+len(strbytes)] = 0
+	p.ensure(8)
+	writeU64(p.out, p.index, uint64(value))
+	p.index = p.index + 8
+}
 
-	// Update the index with the field length
-	p.index = p.index + len(strbytes)
+func (p *encoder) writeFloat64(value float64) {
+	p.writeInt64(int64(math.Float64bits(value)))
+}
 
-	// We have a pointer get the underlying value
-	if value.Kind() == reflect.Ptr {
-		value = value.Elem()
+// Write a null terminated string
+func (p *encoder) writeCString(value string) {
+	if p.sizeOnly {
+		p.index = p.index + len(value) + 1
+		return
+	}
+
+	p.ensure(len(value) + 1)
+	copy(p.out[p.index:], value)
+	p.out[p.index+len(value)] = 0x00
+	p.index = p.index + len(value) + 1
+}
+
+// Write a length prefixed null terminated string
+func (p *encoder) writeString(value string) {
+	p.writeInt32(int32(len(value) + 1))
+	p.writeCString(value)
+}
+
+// Write the size of the document or value starting at originalIndex, the
+// size is the number of bytes written since
+func (p *encoder) writeSize(originalIndex int) {
+	if !p.sizeOnly {
+		writeU32(p.out, originalIndex, uint32(p.index-originalIndex))
+	}
+}
+
+// Write the type and name of an element
+func (p *encoder) writeElementName(kind bsonType, key string) {
+	p.writeByte(byte(kind))
+	p.writeCString(key)
+}
+
+// Write the 12 bytes of the id, shorter or longer ids would corrupt the
+// document
+func (p *encoder) writeObjectId(key string, id ObjectId) error {
+	if !id.IsValid() {
+		return errors.New(fmt.Sprintf("field %v objectid must be 12 bytes got %v", key, len(id.Id)))
+	}
+
+	p.writeBytes(id.Id)
+	return nil
+}
+
+// Milliseconds since the unix epoch, the resolution of a BSON datetime
+func timeToMilliseconds(t time.Time) int64 {
+	return t.Unix()*1000 + int64(t.Nanosecond()/1e6)
+}
+
+func (p *encoder) packElement(key string, value reflect.Value) error {
+	return p.packValue(key, value, false)
+}
+
+// Encode the element, minSize stores integers that fit as int32 like the
+// minsize tag. It applies to the value left once registered encoders,
+// GetBSON, pointers and interfaces have been resolved
+func (p *encoder) packValue(key string, value reflect.Value, minSize bool) error {
+	// Nil interfaces are encoded as null
+	if !value.IsValid() {
+		p.writeElementName(bsonNull, key)
+		return nil
+	}
+
+	// Nil pointers are encoded as null
+	if (value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface) && value.IsNil() {
+		p.writeElementName(bsonNull, key)
+		return nil
+	}
+
+	// Registered encoders replace the default type mapping
+	if encode := p.codecs.encoder(value.Type()); encode != nil && value.Kind() != reflect.Interface {
+		raw, err := encode(value)
+		if err != nil {
+			return errors.New(fmt.Sprintf("field %v: %v", key, err))
+		}
+
+		// A corrupt value would corrupt the document
+		end, err := validateValue(raw.Data, 0, len(raw.Data), raw.Kind, key)
+		if err != nil {
+			return err
+		}
+
+		if end != len(raw.Data) {
+			return newValidationError(end, key, "unexpected %v bytes after the value", len(raw.Data)-end)
+		}
+
+		p.writeElementName(bsonType(raw.Kind), key)
+		p.writeBytes(raw.Data)
+		return nil
+	}
+
+	// Let the value replace itself using GetBSON, interfaces are checked
+	// through their value
+	if value.Kind() != reflect.Interface && value.Type().Implements(getterType) && value.CanInterface() {
+		if getter, ok := value.Interface().(Getter); ok {
+			getv, err := getter.GetBSON()
+			if err != nil {
+				return err
+			}
+
+			return p.packValue(key, reflect.ValueOf(getv), minSize)
+		}
 	}
-	fmt.Println("==================== GOT KIND %#v", value.Kind())
 
 	// Reflect on the type
 	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return p.packValue(key, value.Elem(), minSize)
 	case reflect.String:
-		p.index = p.packString(originalIndex, value)
-	case reflect.Int32:
-		p.out[originalIndex] = byte(bsonInt32)
-		writeU32(p.out, p.index+1, uint32(value.Int()))
-		p.index = p.index + 5
+		p.writeElementName(bsonString, key)
+		p.writeString(value.String())
+	case reflect.Float32, reflect.Float64:
+		p.writeElementName(bsonDouble, key)
+		p.writeFloat64(value.Float())
+	case reflect.Bool:
+		p.writeElementName(bsonBoolean, key)
+		if value.Bool() {
+			p.writeByte(1)
+		} else {
+			p.writeByte(0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i := value.Int()
+		// Only int64 is always encoded as a BSON int64, unless minsize
+		if (minSize || value.Kind() != reflect.Int64) && i >= math.MinInt32 && i <= math.MaxInt32 {
+			p.writeElementName(bsonInt32, key)
+			p.writeInt32(int32(i))
+		} else {
+			p.writeElementName(bsonInt64, key)
+			p.writeInt64(i)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := value.Uint()
+		// BSON has no unsigned integer types
+		if u > math.MaxInt64 {
+			return errors.New(fmt.Sprintf("field %v value %v overflows a BSON int64", key, u))
+		} else if u <= math.MaxInt32 && (minSize || value.Kind() <= reflect.Uint32) {
+			p.writeElementName(bsonInt32, key)
+			p.writeInt32(int32(u))
+		} else {
+			p.writeElementName(bsonInt64, key)
+			p.writeInt64(int64(u))
+		}
+	case reflect.Map:
+		p.writeElementName(bsonDocument, key)
+		return p.addDoc(value)
 	case reflect.Slice:
-		p.out[originalIndex] = byte(bsonArray)
-		// Skip initial byte
-		p.index = p.index + 1
-		// Add the array
+		// Byte slices are encoded as generic binary
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			p.writeElementName(bsonBinary, key)
+			p.packBinary(0x00, value.Bytes())
+			return nil
+		}
+
+		p.writeElementName(bsonArray, key)
+		return p.addArray(value)
+	case reflect.Array:
+		// Byte arrays are encoded as generic binary
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, value.Len())
+			reflect.Copy(reflect.ValueOf(data), value)
+			p.writeElementName(bsonBinary, key)
+			p.packBinary(0x00, data)
+			return nil
+		}
+
+		p.writeElementName(bsonArray, key)
 		return p.addArray(value)
 	case reflect.Struct:
-		switch value.Interface().(type) {
-		case Document:
-			// Set the type of be document
-			p.out[originalIndex] = byte(bsonDocument)
-			// Skip initial byte
-			p.index = p.index + 1
-			// Encode the document
-			return p.addDoc(value)
+		return p.packStruct(key, value)
+	default:
+		return errors.New(fmt.Sprintf("could not recognize the type %v", value.Kind()))
+	}
 
-		default:
-			// Set the type of be document
-			p.out[originalIndex] = byte(bsonDocument)
-			// Skip initial byte
-			p.index = p.index + 1
-			// Encode the document
-			return p.addDoc(value)
+	return nil
+}
+
+// Encodes a struct field, chosen once per field type so the common types
+// skip the checks of packElement
+type fieldEncoder func(p *encoder, key string, value reflect.Value) error
+
+// Choose the encoder for a struct field of the given type
+func newFieldEncoder(fieldType reflect.Type) fieldEncoder {
+	// Types replacing themselves need the full checks
+	if fieldType.Implements(getterType) {
+		return (*encoder).packElement
+	}
+
+	switch fieldType.Kind() {
+	case reflect.String:
+		return encodeStringField
+	case reflect.Bool:
+		return encodeBoolField
+	case reflect.Float32, reflect.Float64:
+		return encodeFloatField
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		return encodeInt32Field
+	}
+
+	return (*encoder).packElement
+}
+
+func encodeStringField(p *encoder, key string, value reflect.Value) error {
+	p.writeElementName(bsonString, key)
+	p.writeString(value.String())
+	return nil
+}
+
+func encodeBoolField(p *encoder, key string, value reflect.Value) error {
+	p.writeElementName(bsonBoolean, key)
+	if value.Bool() {
+		p.writeByte(1)
+	} else {
+		p.writeByte(0)
+	}
+
+	return nil
+}
+
+func encodeFloatField(p *encoder, key string, value reflect.Value) error {
+	p.writeElementName(bsonDouble, key)
+	p.writeFloat64(value.Float())
+	return nil
+}
+
+// Integers of 32 bits or less always fit a BSON int32
+func encodeInt32Field(p *encoder, key string, value reflect.Value) error {
+	p.writeElementName(bsonInt32, key)
+	p.writeInt32(int32(value.Int()))
+	return nil
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var documentStructType = reflect.TypeOf(Document{})
+
+// The struct types packStruct encodes as BSON values instead of documents
+var bsonStructTypes = map[reflect.Type]bool{
+	reflect.TypeOf(ObjectId{}):         true,
+	reflect.TypeOf(Binary{}):           true,
+	reflect.TypeOf(Javascript{}):       true,
+	reflect.TypeOf(JavascriptWScope{}): true,
+	reflect.TypeOf(Date{}):             true,
+	timeType:                           true,
+	reflect.TypeOf(RegExp{}):           true,
+	reflect.TypeOf(Timestamp{}):        true,
+	reflect.TypeOf(Decimal128{}):       true,
+	reflect.TypeOf(Symbol{}):           true,
+	reflect.TypeOf(Undefined{}):        true,
+	reflect.TypeOf(Min{}):              true,
+	reflect.TypeOf(Max{}):              true,
+	rawType:                            true,
+	reflect.TypeOf(DBPointer{}):        true,
+}
+
+// Encode the BSON types declared in bson.go, any other struct is
+// encoded as an embedded document
+func (p *encoder) packStruct(key string, value reflect.Value) error {
+	if !value.CanInterface() {
+		return errors.New(fmt.Sprintf("field %v of type %v is not exported", key, value.Type()))
+	}
+
+	// Other structs are encoded as documents without boxing the value
+	if !bsonStructTypes[value.Type()] {
+		p.writeElementName(bsonDocument, key)
+		return p.addDoc(value)
+	}
+
+	switch v := value.Interface().(type) {
+	case ObjectId:
+		p.writeElementName(bsonObjectId, key)
+		return p.writeObjectId(key, v)
+	case Binary:
+		p.writeElementName(bsonBinary, key)
+		p.packBinary(v.SubType, v.Data)
+	case Javascript:
+		p.writeElementName(bsonJavaScript, key)
+		p.writeString(v.Code)
+	case JavascriptWScope:
+		p.writeElementName(bsonJavaScriptWScope, key)
+		// Save the index for the total size of code and scope
+		originalIndex := p.index
+		p.writeInt32(0)
+		p.writeString(v.Code)
+
+		scope := v.Scope
+		if scope == nil {
+			scope = NewDocument()
+		}
+
+		err := p.addDoc(reflect.ValueOf(scope))
+		if err != nil {
+			return err
 		}
+
+		p.writeSize(originalIndex)
+	case Date:
+		p.writeElementName(bsonDateTime, key)
+		p.writeInt64(v.Value)
+	case time.Time:
+		p.writeElementName(bsonDateTime, key)
+		p.writeInt64(timeToMilliseconds(v))
+	case RegExp:
+		p.writeElementName(bsonRegExp, key)
+		p.writeCString(v.Pattern)
+		p.writeCString(v.Options)
+	case Timestamp:
+		p.writeElementName(bsonTimestamp, key)
+		p.writeInt64(v.Value)
+	case Decimal128:
+		p.writeElementName(bsonDecimal128, key)
+		p.writeInt64(int64(v.Low))
+		p.writeInt64(int64(v.High))
+	case Symbol:
+		p.writeElementName(bsonSymbol, key)
+		p.writeString(v.Value)
+	case Undefined:
+		p.writeElementName(bsonUndefined, key)
+	case Min:
+		p.writeElementName(bsonMinKey, key)
+	case Max:
+		p.writeElementName(bsonMaxKey, key)
+	case Raw:
+		// Copy the raw value as is
+		p.writeElementName(bsonType(v.Kind), key)
+		p.writeBytes(v.Data)
+	case DBPointer:
+		p.writeElementName(bsonDBPointer, key)
+		p.writeString(v.Namespace)
+		return p.writeObjectId(key, v.Id)
 	default:
-		return errors.New(fmt.Sprintf("could not recognize the type %v", value.Kind()))
+		// Set the type of be document
+		p.writeElementName(bsonDocument, key)
+		// Encode the document
+		return p.addDoc(value)
 	}
 
 	return nil
 }
 
+func (p *encoder) packBinary(subType byte, data []byte) {
+	// The old binary subtype wraps the data in a second length
+	if subType == 0x02 {
+		p.writeInt32(int32(len(data) + 4))
+		p.writeByte(subType)
+		p.writeInt32(int32(len(data)))
+	} else {
+		p.writeInt32(int32(len(data)))
+		p.writeByte(subType)
+	}
+
+	p.writeBytes(data)
+}
+
 func (p *encoder) addArray(value reflect.Value) error {
 	length := value.Len()
-	// element := value.Type().Elem()
+	// Save current index for the writing of the total size of the array
+	originalIndex := p.index
+	// Skip the 4 for size bytes
+	p.writeInt32(0)
 
 	for i := 0; i < length; i++ {
 		err := p.packElement(itoa(i), value.Index(i))
@@ -175,6 +585,136 @@ func (p *encoder) addArray(value reflect.Value) error {
 		}
 	}
 
+	// Write the last null byte
+	p.writeByte(0)
+	// Write the totalSize of the array
+	p.writeSize(originalIndex)
+	return nil
+}
+
+func sortedMapKeys(value reflect.Value) []reflect.Value {
+	keys := value.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
+
+// Is the value empty for the purpose of omitempty
+func isEmpty(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return value.Len() == 0
+	case reflect.Bool:
+		return !value.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return value.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return value.Float() == 0
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.Struct:
+		if value.Type() == timeType {
+			return value.Interface().(time.Time).IsZero()
+		}
+
+		return value.IsZero()
+	}
+
+	return false
+}
+
+// Encode the entries of a map with string keys, sorted by key so the
+// output is stable
+func (p *encoder) addMap(value reflect.Value) error {
+	if value.Type().Key().Kind() != reflect.String {
+		return errors.New(fmt.Sprintf("map key type %v not supported for serialization", value.Type().Key()))
+	}
+
+	for _, key := range sortedMapKeys(value) {
+		err := p.packElement(key.String(), value.MapIndex(key))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+var appenderType = reflect.TypeOf((*Appender)(nil)).Elem()
+
+// The Appender or Marshaler of the value or of its address if any
+func selfEncoder(value reflect.Value) interface{} {
+	if !value.IsValid() || !value.CanInterface() {
+		return nil
+	}
+
+	if value.Type().Implements(appenderType) || value.Type().Implements(marshalerType) {
+		return value.Interface()
+	}
+
+	if value.CanAddr() {
+		pointerType := reflect.PtrTo(value.Type())
+		if pointerType.Implements(appenderType) || pointerType.Implements(marshalerType) {
+			return value.Addr().Interface()
+		}
+	}
+
+	return nil
+}
+
+// Encode a value implementing Appender or Marshaler, returns false if the
+// value does not implement either
+func (p *encoder) addSelfEncoded(value reflect.Value) (bool, error) {
+	self := selfEncoder(value)
+	if self == nil {
+		return false, nil
+	}
+
+	originalIndex := p.index
+
+	switch t := self.(type) {
+	case Appender:
+		if p.sizeOnly {
+			b, err := t.AppendBSON(nil)
+			if err != nil {
+				return true, err
+			}
+
+			p.writeBytes(b)
+			return true, checkSelfEncoded(value, b)
+		}
+
+		// Append directly to the buffer
+		out, err := t.AppendBSON(p.out[:p.index])
+		if err != nil {
+			return true, err
+		}
+
+		p.out = out[:cap(out)]
+		p.index = len(out)
+	case Marshaler:
+		b, err := t.MarshalBSON()
+		if err != nil {
+			return true, err
+		}
+
+		p.writeBytes(b)
+		if p.sizeOnly {
+			return true, checkSelfEncoded(value, b)
+		}
+	}
+
+	return true, checkSelfEncoded(value, p.out[originalIndex:p.index])
+}
+
+// Check the size of a document returned by AppendBSON or MarshalBSON
+func checkSelfEncoded(value reflect.Value, b []byte) error {
+	if len(b) < 5 || int(readUInt32(b, 0)) != len(b) || b[len(b)-1] != 0 {
+		return errors.New(fmt.Sprintf("type %v encoded an invalid document of %v bytes", value.Type(), len(b)))
+	}
+
 	return nil
 }
 
@@ -184,25 +724,47 @@ func (p *encoder) addDoc(value reflect.Value) error {
 
 	// Get value object reference if it's a pointer
 	for {
-		if value.Kind() == reflect.Ptr {
+		if value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
 			value = value.Elem()
 			continue
 		}
 		break
 	}
 
+	// Let the value encode itself
+	if handled, err := p.addSelfEncoded(value); handled {
+		return err
+	}
+
+	// Save current index for the writing of the total size of the doc
+	originalIndex := p.index
+
 	// Switch on the value
 	switch value.Kind() {
+	case reflect.Map:
+		// Skip the 4 for size bytes
+		p.writeInt32(0)
+
+		err := p.addMap(value)
+		if err != nil {
+			return err
+		}
 	case reflect.Struct:
-		// Save current index for the writing of the total size of the doc
-		originalIndex := p.index
+		// Raw documents are copied as is
+		if value.Type() == rawType {
+			if raw := value.Interface().(Raw); raw.Kind == byte(bsonDocument) || raw.Kind == byte(bsonArray) {
+				p.writeBytes(raw.Data)
+				return nil
+			}
+		}
 
 		// Skip the 4 for size bytes
-		p.index = p.index + 4
+		p.writeInt32(0)
 
 		// Check if we have the Document type or a normal struct
-		switch doc := value.Interface().(type) {
-		case Document:
+		switch value.Type() {
+		case documentStructType:
+			doc := value.Interface().(Document)
 			// Iterate over all the key values
 			for _, key := range doc.fields {
 				// Get the value
@@ -215,17 +777,20 @@ func (p *encoder) addDoc(value reflect.Value) error {
 			}
 		default:
 			// Get type information for current value
-			typeInfo := parseTypeInformation(p.typeInfos, originalValue, value)
+			typeInfo, err := parseTypeInformation(p.typeInfos, value.Type())
+			if err != nil {
+				return err
+			}
 
 			// Do we have a GetBSON method, execute it
-			if typeInfo.HasGetBSON {
+			if typeInfo.HasGetBSON && originalValue.Type().Implements(getterType) {
 				value = originalValue
 
 				for {
 					if vi, ok := value.Interface().(Getter); ok {
 						getv, err := vi.GetBSON()
 						if err != nil {
-							panic(err)
+							return err
 						}
 						value = reflect.ValueOf(getv)
 						continue
@@ -242,30 +807,68 @@ func (p *encoder) addDoc(value reflect.Value) error {
 				// Add the GetBSON object returned
 				return p.addDoc(value)
 			} else {
+				// Add the entries of the inlined map first as mgo does
+				if typeInfo.InlineMap != nil {
+					inlineMap := value.FieldByIndex(typeInfo.InlineMap)
+					for _, key := range sortedMapKeys(inlineMap) {
+						// Fields also holds the Go field names, only the BSON names conflict
+						if field := typeInfo.Fields[key.String()]; field != nil && field.MetaDataName == key.String() {
+							return errors.New(fmt.Sprintf("inlined map key %v conflicts with a struct field", key.String()))
+						}
+
+						err := p.packElement(key.String(), inlineMap.MapIndex(key))
+						if err != nil {
+							return err
+						}
+					}
+				}
+
 				// Let's iterate over all the fields
 				for j := 0; j < typeInfo.NumberOfField; j++ {
-					// Get the field value
-					fieldValue := value.Field(j)
 					// Get field type
 					fieldType := typeInfo.FieldsByIndex[j]
+					// Get the field value
+					fieldValue := value.FieldByIndex(fieldType.Index)
 					// Get the field name
 					key := fieldType.MetaDataName
+
+					// Skip empty values
+					if fieldType.OmitEmpty && isEmpty(fieldValue) {
+						continue
+					}
+
+					// Store integers as int32 when they fit
+					if fieldType.MinSize {
+						err := p.packValue(key, fieldValue, true)
+						if err != nil {
+							return err
+						}
+
+						continue
+					}
+
+					// Registered encoders are looked up by packElement
+					encode := fieldType.encode
+					if p.codecs != nil {
+						encode = (*encoder).packElement
+					}
+
 					// Add the size of the actual element
-					err := p.packElement(key, fieldValue)
+					err := encode(p, key, fieldValue)
 					if err != nil {
 						return err
 					}
 				}
+
 			}
 		}
-
-		// Skip last null byte
-		p.index = p.index + 1
-		// Write the totalSize of the document
-		writeU32(p.out, originalIndex, uint32(p.index-originalIndex))
 	default:
 		return errors.New(fmt.Sprintf("BSON struct type %T not supported for serialization", value))
 	}
 
+	// Write the last null byte
+	p.writeByte(0)
+	// Write the totalSize of the document
+	p.writeSize(originalIndex)
 	return nil
 }
@@ -2,11 +2,13 @@ package mongo
 
 import (
 	"bytes"
-	// "fmt"
-	// "strings"
+	"fmt"
+	"gopkg.in/mgo.v2/bson"
+	"math"
 	"reflect"
+	"sync"
 	"testing"
-	// "time"
+	"time"
 )
 
 func SerializeTest(t *testing.T, doc interface{}, expectedBuffer []byte) {
@@ -55,6 +57,36 @@ func DeserializeTest(t *testing.T, b []byte, empty interface{}, expected interfa
 	}
 }
 
+func TestDeserializeDiff(t *testing.T) {
+	parser := NewBSON()
+	expected := NewDocument()
+	expected.Add("a", int32(1))
+	expected.Add("b", "c")
+
+	document := NewDocument()
+	document.Add("a", int32(1))
+	document.Add("b", "d")
+	b, err := parser.MarshallExact(document)
+	if err != nil {
+		t.Fatalf("failed to marshal document %v", err)
+	}
+
+	// Describe the first field the unmarshaled document differs in
+	unmarshaled := NewDocument()
+	err = parser.Unmarshal(b, unmarshaled)
+	if err != nil {
+		t.Fatalf("failed to unmarshal document %v", err)
+	}
+
+	if diff := parser.Diff(expected, unmarshaled); diff != `{"b":"c"} != {"b":"d"}` {
+		t.Errorf("unexpected diff %v", diff)
+	}
+
+	if diff := parser.Diff(document, unmarshaled); diff != "" {
+		t.Errorf("expected no diff got %v", diff)
+	}
+}
+
 func TestOverflowDefaultBufferSize(t *testing.T) {
 	type T1 struct {
 		Int int32 `bson:"int,omitempty"`
@@ -237,3 +269,447 @@ func TestSimpleArraySerialization(t *testing.T) {
 	// validateString(t, a[0], "a")
 	// validateString(t, a[1], "b")
 }
+
+/*
+ * Every supported type must serialize to the same bytes as mgo
+ */
+func TestTypeSerializationsMatchMGO(t *testing.T) {
+	id := []byte{0x54, 0xd3, 0x8e, 0x1f, 0x5d, 0x3a, 0x1c, 0x2b, 0x11, 0x22, 0x33, 0x44}
+	date := time.Date(2015, 2, 5, 10, 30, 0, 123000000, time.UTC)
+	var nilPointer *T1
+	var nilSlice []string
+	var nilMap map[string]int32
+
+	scope := NewDocument()
+	scope.Add("a", int32(1))
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected interface{}
+	}{
+		{"float64", float64(1.5), float64(1.5)},
+		{"float32", float32(0.25), float64(0.25)},
+		{"int8", int8(-8), int32(-8)},
+		{"int16", int16(16), int32(16)},
+		{"int", int(42), int32(42)},
+		{"large int", int(math.MaxInt32 + 1), int64(math.MaxInt32 + 1)},
+		{"int64", int64(10), int64(10)},
+		{"uint8", uint8(8), int32(8)},
+		{"uint16", uint16(16), int32(16)},
+		{"uint32", uint32(32), int32(32)},
+		{"large uint32", uint32(math.MaxUint32), int64(math.MaxUint32)},
+		{"uint64", uint64(64), int64(64)},
+		{"bool true", true, true},
+		{"bool false", false, false},
+		{"nil", nil, nil},
+		{"nil pointer", nilPointer, nil},
+		{"time", date, date},
+		{"date", Date{date.UnixNano() / 1e6}, date},
+		{"object id", ObjectId{id}, bson.ObjectId(id)},
+		{"object id pointer", &ObjectId{id}, bson.ObjectId(id)},
+		{"binary", Binary{0x04, []byte{1, 2, 3}}, bson.Binary{Kind: 0x04, Data: []byte{1, 2, 3}}},
+		{"binary old", Binary{0x02, []byte{1, 2, 3}}, bson.Binary{Kind: 0x02, Data: []byte{1, 2, 3}}},
+		{"byte slice", []byte{1, 2, 3}, []byte{1, 2, 3}},
+		{"byte array", [3]byte{1, 2, 3}, []byte{1, 2, 3}},
+		{"javascript", Javascript{"function() {}"}, bson.JavaScript{Code: "function() {}"}},
+		{"javascript with scope", JavascriptWScope{"function() {}", scope}, bson.JavaScript{Code: "function() {}", Scope: bson.M{"a": 1}}},
+		{"regexp", RegExp{"^a", "i"}, bson.RegEx{Pattern: "^a", Options: "i"}},
+		{"timestamp", Timestamp{1<<32 | 5}, bson.MongoTimestamp(1<<32 | 5)},
+		{"min", Min{}, bson.MinKey},
+		{"max", Max{}, bson.MaxKey},
+		{"db pointer", DBPointer{"test.users", ObjectId{id}}, bson.DBPointer{Namespace: "test.users", Id: bson.ObjectId(id)}},
+		{"map", map[string]interface{}{"a": int32(1), "b": "c"}, bson.D{{Name: "a", Value: 1}, {Name: "b", Value: "c"}}},
+		{"nil map", nilMap, bson.M{}},
+		{"struct", T2{"hello"}, bson.M{"string": "hello"}},
+		{"struct pointer", &T1{10}, bson.M{"int": 10}},
+		{"slice", []int32{1, 2, 3}, []int32{1, 2, 3}},
+		{"nil slice", nilSlice, []string{}},
+		{"array", [2]string{"a", "b"}, []string{"a", "b"}},
+		{"mixed slice", []interface{}{"a", int64(1), 1.5, nil, true}, []interface{}{"a", int64(1), 1.5, nil, true}},
+	}
+
+	for _, test := range tests {
+		// Wrap the value in a document
+		document := NewDocument()
+		document.Add("value", test.value)
+
+		expectedBuffer, err := bson.Marshal(bson.D{{Name: "value", Value: test.expected}})
+		if err != nil {
+			t.Fatalf("%s: mgo failed to marshal %v", test.name, err)
+		}
+
+		parser := NewBSON()
+		b, err := parser.Marshall(document, nil, 0)
+		if err != nil || !bytes.Equal(b, expectedBuffer) {
+			t.Errorf("%s: illegal BSON returned %v\nexp: %v\ngot: %v", test.name, err, expectedBuffer, b)
+		}
+	}
+}
+
+func TestStructSerializationMatchesMGO(t *testing.T) {
+	type Inner struct {
+		Name string `bson:"name"`
+	}
+
+	type Outer struct {
+		Double  float64        `bson:"double"`
+		Long    int64          `bson:"long"`
+		Bool    bool           `bson:"bool"`
+		Time    time.Time      `bson:"time"`
+		Tags    []string       `bson:"tags"`
+		Counts  map[string]int `bson:"counts"`
+		Inner   Inner          `bson:"inner"`
+		Pointer *Inner         `bson:"pointer"`
+		Nil     *Inner         `bson:"nil"`
+	}
+
+	doc := &Outer{
+		Double:  3.14,
+		Long:    1 << 40,
+		Bool:    true,
+		Time:    time.Date(2015, 2, 5, 10, 30, 0, 0, time.UTC),
+		Tags:    []string{"a", "b"},
+		Counts:  map[string]int{"a": 1},
+		Inner:   Inner{"inner"},
+		Pointer: &Inner{"pointer"},
+	}
+
+	expectedBuffer, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	SerializeTest(t, doc, expectedBuffer)
+}
+
+func TestSerializationErrors(t *testing.T) {
+	parser := NewBSON()
+
+	// BSON has no unsigned 64 bit integer
+	document := NewDocument()
+	document.Add("value", uint64(math.MaxUint64))
+	if _, err := parser.Marshall(document, nil, 0); err == nil {
+		t.Errorf("expected an error serializing a uint64 overflowing an int64")
+	}
+
+	// Map keys must be strings
+	document = NewDocument()
+	document.Add("value", map[int]string{1: "a"})
+	if _, err := parser.Marshall(document, nil, 0); err == nil {
+		t.Errorf("expected an error serializing a map with int keys")
+	}
+
+	// Functions and channels have no BSON representation
+	document = NewDocument()
+	document.Add("value", make(chan int))
+	if _, err := parser.Marshall(document, nil, 0); err == nil {
+		t.Errorf("expected an error serializing a channel")
+	}
+}
+
+func TestSerializationIntoDirtyBuffer(t *testing.T) {
+	var expectedBuffer = []byte{14, 0, 0, 0, 16, 105, 110, 116, 0, 10, 0, 0, 0, 0}
+
+	// The terminating null bytes must be written not assumed
+	buffer := bytes.Repeat([]byte{0xff}, 32)
+	parser := NewBSON()
+	b, err := parser.Marshall(&T1{10}, buffer, 4)
+	if err != nil || !bytes.Equal(b, expectedBuffer) {
+		t.Fatalf("Illegal BSON returned \nexp: %v\ngot: %v", expectedBuffer, b)
+	}
+}
+
+/*
+ * Struct tag options must match mgo
+ */
+type TagsInline struct {
+	City    string `bson:"city"`
+	Country string `bson:"country,omitempty"`
+}
+
+type tagsUnexported struct {
+	Hidden string `bson:"hidden"`
+}
+
+type Tags struct {
+	Name      string                 `bson:"name"`
+	Empty     string                 `bson:"empty,omitempty"`
+	EmptyList []string               `bson:"emptyList,omitempty"`
+	EmptyPtr  *T1                    `bson:"emptyPtr,omitempty"`
+	EmptyTime time.Time              `bson:"emptyTime,omitempty"`
+	Small     int64                  `bson:"small,minsize"`
+	Large     int64                  `bson:"large,minsize"`
+	Skipped   string                 `bson:"-"`
+	private   string                 `bson:"private"`
+	Address   TagsInline             `bson:",inline"`
+	Extra     map[string]interface{} `bson:",inline"`
+	tagsUnexported
+}
+
+func TestStructTagsSerialization(t *testing.T) {
+	doc := &Tags{
+		Name:    "name",
+		Small:   10,
+		Large:   1 << 40,
+		Skipped: "skipped",
+		private: "private",
+		Address: TagsInline{City: "Oslo"},
+		Extra:   map[string]interface{}{"extra": "value"},
+	}
+
+	expectedBuffer, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	SerializeTest(t, doc, expectedBuffer)
+
+	// Skipped and unexported fields are not decoded
+	DeserializeTest(t, expectedBuffer, &Tags{}, &Tags{
+		Name:    "name",
+		Small:   10,
+		Large:   1 << 40,
+		Address: TagsInline{City: "Oslo"},
+		Extra:   map[string]interface{}{"extra": "value"},
+	})
+}
+
+func TestInlineMapConflict(t *testing.T) {
+	doc := &Tags{Extra: map[string]interface{}{"name": "conflict"}}
+
+	parser := NewBSON()
+	if _, err := parser.Marshall(doc, nil, 0); err == nil {
+		t.Errorf("expected an error for an inlined map key matching a field")
+	}
+
+	// The Go name of a field renamed by its tag is a free key
+	doc = &Tags{Name: "name", Extra: map[string]interface{}{"Name": "extra"}}
+	expectedBuffer, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	SerializeTest(t, doc, expectedBuffer)
+}
+
+type DuplicatedKey struct {
+	A string `bson:"x"`
+	B string `bson:"x"`
+}
+
+type DuplicatedInlineKey struct {
+	X      string     `bson:"city"`
+	Inline TagsInline `bson:",inline"`
+}
+
+func TestDuplicatedKey(t *testing.T) {
+	parser := NewBSON()
+
+	for _, doc := range []interface{}{&DuplicatedKey{"a", "b"}, &DuplicatedInlineKey{X: "x"}} {
+		_, expectedErr := bson.Marshal(doc)
+		if expectedErr == nil {
+			t.Fatalf("expected mgo to reject %T", doc)
+		}
+
+		// Encoding fails like it does with mgo
+		_, err := parser.Marshall(doc, nil, 0)
+		if err == nil || err.Error() != expectedErr.Error() {
+			t.Errorf("expected error %q for %T got %v", expectedErr, doc, err)
+		}
+
+		// So does decoding
+		data, _ := bson.Marshal(bson.M{"x": "a"})
+		err = parser.Unmarshal(data, doc)
+		if err == nil || err.Error() != expectedErr.Error() {
+			t.Errorf("expected decode error %q for %T got %v", expectedErr, doc, err)
+		}
+	}
+}
+
+// Replaces itself with a string, minsize does not apply to it
+type Money int64
+
+func (p Money) GetBSON() (interface{}, error) {
+	return fmt.Sprintf("$%d", int64(p)), nil
+}
+
+// Replaces itself with an int64 minsize applies to
+type Cents int64
+
+func (p Cents) GetBSON() (interface{}, error) {
+	return int64(p) * 100, nil
+}
+
+type MinSizeValues struct {
+	Money     Money       `bson:"money,minsize"`
+	Cents     Cents       `bson:"cents,minsize"`
+	Pointer   *int64      `bson:"pointer,minsize"`
+	Nil       *int64      `bson:"nil,minsize"`
+	Interface interface{} `bson:"interface,minsize"`
+	Large     *uint64     `bson:"large,minsize"`
+}
+
+func TestMinSizeResolvesValue(t *testing.T) {
+	pointer := int64(3)
+	large := uint64(1 << 40)
+	doc := &MinSizeValues{Money: 7, Cents: 5, Pointer: &pointer, Interface: int64(4), Large: &large}
+
+	expectedBuffer, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	SerializeTest(t, doc, expectedBuffer)
+}
+
+func sameNameFirst() interface{} {
+	type Item struct {
+		A string `bson:"a"`
+	}
+
+	return &Item{"first"}
+}
+
+func sameNameSecond() interface{} {
+	type Item struct {
+		B int32 `bson:"b"`
+	}
+
+	return &Item{2}
+}
+
+func TestTypeInfoCacheKeys(t *testing.T) {
+	parser := NewBSON()
+
+	// Types with the same name and anonymous structs are cached separately
+	docs := []interface{}{
+		sameNameFirst(),
+		sameNameSecond(),
+		&struct {
+			C string `bson:"c"`
+		}{"anonymous"},
+		&struct {
+			D bool `bson:"d"`
+		}{true},
+	}
+
+	for _, doc := range docs {
+		expected, err := bson.Marshal(doc)
+		if err != nil {
+			t.Fatalf("mgo failed to marshal %v", err)
+		}
+
+		b, err := parser.Marshall(doc, nil, 0)
+		if err != nil || !bytes.Equal(b, expected) {
+			t.Errorf("expected %v for %T got %v %v", expected, doc, b, err)
+		}
+
+		result := reflect.New(reflect.TypeOf(doc).Elem()).Interface()
+		err = parser.Unmarshal(expected, result)
+		if err != nil || !reflect.DeepEqual(result, doc) {
+			t.Errorf("expected %+v got %+v %v", doc, result, err)
+		}
+	}
+}
+
+func TestTypeInfoCacheConcurrency(t *testing.T) {
+	type Inner struct {
+		Value float64 `bson:"value"`
+	}
+
+	type Outer struct {
+		Name  string   `bson:"name"`
+		Count int64    `bson:"count"`
+		Inner *Inner   `bson:"inner"`
+		Tags  []string `bson:"tags"`
+	}
+
+	doc := &Outer{"outer", 1 << 40, &Inner{1.5}, []string{"a", "b"}}
+	expected, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	// All the goroutines share the parser and its cache
+	parser := NewBSON()
+	var wait sync.WaitGroup
+	errs := make(chan error, 16)
+
+	for i := 0; i < 16; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+
+			for j := 0; j < 100; j++ {
+				b, err := parser.Marshall(doc, nil, 0)
+				if err != nil || !bytes.Equal(b, expected) {
+					errs <- fmt.Errorf("unexpected serialization %v %v", b, err)
+					return
+				}
+
+				result := &Outer{}
+				err = parser.Unmarshal(b, result)
+				if err != nil || !reflect.DeepEqual(result, doc) {
+					errs <- fmt.Errorf("unexpected deserialization %+v %v", result, err)
+					return
+				}
+			}
+		}()
+	}
+
+	wait.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestSizeAndExactSerialization(t *testing.T) {
+	type Item struct {
+		Name  string            `bson:"name"`
+		Tags  []string          `bson:"tags,omitempty"`
+		Extra map[string]string `bson:",inline"`
+	}
+
+	parser := NewBSON()
+	for _, doc := range []interface{}{
+		allTypesDocument(),
+		NewDocument(),
+		&Item{"item", []string{"a", "b"}, map[string]string{"extra": "value"}},
+		map[string]interface{}{"a": []interface{}{int32(1), NewDocument()}},
+		&GetBSONT1{10},
+	} {
+		expected, err := parser.Marshall(doc, nil, 0)
+		if err != nil {
+			t.Fatalf("failed to serialize %v", err)
+		}
+
+		if size, err := parser.Size(doc); err != nil || size != len(expected) {
+			t.Errorf("expected size %v for %T got %v %v", len(expected), doc, size, err)
+		}
+
+		// A single allocation of the exact size
+		b, err := parser.MarshallExact(doc)
+		if err != nil || !bytes.Equal(b, expected) || cap(b) != len(expected) {
+			t.Errorf("expected %v got %v with capacity %v %v", expected, b, cap(b), err)
+		}
+
+		// Into a larger buffer
+		buffer := make([]byte, len(expected)+10)
+		if size, err := parser.MarshallInto(doc, buffer); err != nil || !bytes.Equal(buffer[:size], expected) {
+			t.Errorf("expected %v got %v %v", expected, buffer[:size], err)
+		}
+
+		// Into a buffer that is too small
+		if _, err := parser.MarshallInto(doc, make([]byte, len(expected)-1)); err != ErrBufferTooSmall {
+			t.Errorf("expected %v got %v", ErrBufferTooSmall, err)
+		}
+	}
+
+	// Errors are found when computing the size
+	if _, err := parser.Size(&struct{ Id ObjectId }{}); err == nil {
+		t.Errorf("expected an error computing the size of an invalid objectid")
+	}
+}
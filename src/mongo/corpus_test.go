@@ -0,0 +1,171 @@
+package mongo
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// The cases of the BSON corpus the package does not support yet, keyed by
+// file and description. They are reported instead of failing the test.
+var unsupportedCorpusCases = map[string]string{
+	"regex.json flags not alphabetized degenerate": "regular expression options are encoded as given, not sorted",
+}
+
+// Collects the failures of the corpus cases by BSON type
+type corpusReport struct {
+	failures    map[string][]string
+	unsupported map[string][]string
+	cases       map[string]int
+}
+
+func (p *corpusReport) check(t *testing.T, file string, tests *bsonCorpus, description string, err error) {
+	key := file + " " + description
+	p.cases[tests.BsonType]++
+
+	if _, ok := unsupportedCorpusCases[key]; ok {
+		if err == nil {
+			t.Errorf("%s: passes, remove it from the unsupported cases", key)
+		} else {
+			p.unsupported[tests.BsonType] = append(p.unsupported[tests.BsonType], key+" ("+unsupportedCorpusCases[key]+")")
+		}
+
+		return
+	}
+
+	if err != nil {
+		t.Errorf("%s: %v", key, err)
+		p.failures[tests.BsonType] = append(p.failures[tests.BsonType], key)
+	}
+}
+
+// Decode the BSON into a Document and encode it back
+func corpusRoundTrip(parser *BSON, b []byte, expected []byte) error {
+	document := NewDocument()
+	err := parser.Unmarshal(b, document)
+	if err != nil {
+		return fmt.Errorf("failed to decode into a Document %v", err)
+	}
+
+	encoded, err := parser.Marshall(document, nil, 0)
+	if err != nil {
+		return fmt.Errorf("failed to encode the Document %v", err)
+	}
+
+	if !bytes.Equal(encoded, expected) {
+		return fmt.Errorf("encoded the Document as %X expected %X", encoded, expected)
+	}
+
+	// Through an interface{} target
+	var value interface{}
+	err = parser.Unmarshal(b, &value)
+	if err != nil {
+		return fmt.Errorf("failed to decode into an interface{} %v", err)
+	}
+
+	encoded, err = parser.Marshall(value, nil, 0)
+	if err != nil || !bytes.Equal(encoded, expected) {
+		return fmt.Errorf("encoded the interface{} as %X expected %X %v", encoded, expected, err)
+	}
+
+	return nil
+}
+
+// Decoding must fail everywhere the document is read
+func corpusDecodeError(parser *BSON, b []byte) error {
+	if err := Validate(b); err == nil {
+		return fmt.Errorf("expected a validation error")
+	}
+
+	if err := parser.Unmarshal(b, NewDocument()); err == nil {
+		return fmt.Errorf("expected an error decoding into a Document")
+	}
+
+	var value interface{}
+	if err := parser.Unmarshal(b, &value); err == nil {
+		return fmt.Errorf("expected an error decoding into an interface{}")
+	}
+
+	if _, err := RawDocument(b).Elements(); err == nil {
+		if err := RawDocument(b).Validate(); err == nil {
+			return fmt.Errorf("expected an error reading the RawDocument")
+		}
+	}
+
+	return nil
+}
+
+// Parsing must fail, decimal128 parse errors are number strings
+func corpusParseError(parser *BSON, tests *bsonCorpus, str string) error {
+	if tests.BsonType == "0x13" {
+		if _, err := ParseDecimal128(str); err == nil {
+			return fmt.Errorf("expected an error parsing %s", str)
+		}
+
+		return nil
+	}
+
+	if err := parser.UnmarshalExtJSON([]byte(str), NewDocument()); err == nil {
+		return fmt.Errorf("expected an error parsing %s", str)
+	}
+
+	return nil
+}
+
+// Runs the valid, decodeErrors and parseErrors cases of every file of the
+// MongoDB BSON corpus and reports the types with unsupported cases
+func TestBSONCorpus(t *testing.T) {
+	parser := NewBSON()
+	report := &corpusReport{make(map[string][]string), make(map[string][]string), make(map[string]int)}
+
+	corpus := loadBSONCorpus(t, "*.json")
+	files := make([]string, 0, len(corpus))
+	for file := range corpus {
+		files = append(files, file)
+	}
+
+	sort.Strings(files)
+
+	for _, file := range files {
+		tests := corpus[file]
+
+		for _, test := range tests.Valid {
+			canonicalBson := decodeHex(t, test.CanonicalBson)
+			report.check(t, file, tests, test.Description, corpusRoundTrip(parser, canonicalBson, canonicalBson))
+
+			// Degenerate BSON is encoded back as the canonical BSON
+			if test.DegenerateBson != "" {
+				err := corpusRoundTrip(parser, decodeHex(t, test.DegenerateBson), canonicalBson)
+				report.check(t, file, tests, test.Description+" degenerate", err)
+			}
+		}
+
+		for _, test := range tests.DecodeErrors {
+			report.check(t, file, tests, test.Description, corpusDecodeError(parser, decodeHex(t, test.Bson)))
+		}
+
+		for _, test := range tests.ParseErrors {
+			report.check(t, file, tests, test.Description, corpusParseError(parser, tests, test.String))
+		}
+	}
+
+	// Summary of the support by BSON type
+	types := make([]string, 0, len(report.cases))
+	for bsonType := range report.cases {
+		types = append(types, bsonType)
+	}
+
+	sort.Strings(types)
+
+	for _, bsonType := range types {
+		failed := len(report.failures[bsonType]) + len(report.unsupported[bsonType])
+		if failed == 0 {
+			continue
+		}
+
+		t.Logf("BSON type %s: %v of %v cases unsupported\n\t%s", bsonType, failed, report.cases[bsonType],
+			strings.Join(append(report.failures[bsonType], report.unsupported[bsonType]...), "\n\t"))
+	}
+}
@@ -0,0 +1,232 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Exponent limits and bias of the IEEE 754-2008 128 bit decimal
+const (
+	decimal128MaxDigits    = 34
+	decimal128MaxExponent  = 6111
+	decimal128MinExponent  = -6176
+	decimal128ExponentBias = 6176
+)
+
+// Largest significand that fits in 34 decimal digits
+var decimal128MaxSignificand = new(big.Int).Sub(new(big.Int).Exp(big.NewInt(10), big.NewInt(decimal128MaxDigits), nil), big.NewInt(1))
+
+// 128 bit decimal floating point value in the binary integer decimal
+// encoding, High holds the sign, combination field and top of the
+// significand, Low holds the bottom 64 bits of the significand
+type Decimal128 struct {
+	High uint64
+	Low  uint64
+}
+
+func NewDecimal128(high uint64, low uint64) Decimal128 {
+	return Decimal128{high, low}
+}
+
+// Is the value NaN
+func (p Decimal128) IsNaN() bool {
+	return (p.High>>58)&0x1f == 0x1f
+}
+
+// Is the value positive or negative infinity
+func (p Decimal128) IsInf() bool {
+	return (p.High>>58)&0x1f == 0x1e
+}
+
+// Split a finite value into its sign, unbiased exponent and significand
+func (p Decimal128) parts() (bool, int, *big.Int) {
+	negative := p.High>>63 == 1
+	var exponent int
+	var high uint64
+
+	if (p.High>>61)&0x3 == 0x3 {
+		// The significand has an implicit 100 prefix making it larger than
+		// the maximum significand, the value is treated as zero
+		exponent = int((p.High >> 47) & 0x3fff)
+		return negative, exponent - decimal128ExponentBias, new(big.Int)
+	}
+
+	exponent = int((p.High >> 49) & 0x3fff)
+	high = p.High & 0x1ffffffffffff
+
+	significand := new(big.Int).SetUint64(high)
+	significand.Lsh(significand, 64)
+	significand.Or(significand, new(big.Int).SetUint64(p.Low))
+
+	// Non canonical significands are treated as zero
+	if significand.Cmp(decimal128MaxSignificand) > 0 {
+		significand.SetInt64(0)
+	}
+
+	return negative, exponent - decimal128ExponentBias, significand
+}
+
+// Format the value as a string following the decimal128 specification
+func (p Decimal128) String() string {
+	if p.IsNaN() {
+		return "NaN"
+	}
+
+	negative := p.High>>63 == 1
+	sign := ""
+	if negative {
+		sign = "-"
+	}
+
+	if p.IsInf() {
+		return sign + "Infinity"
+	}
+
+	_, exponent, significand := p.parts()
+	digits := significand.String()
+	adjustedExponent := exponent + len(digits) - 1
+
+	// Scientific notation for large exponents and very small values
+	if exponent > 0 || adjustedExponent < -6 {
+		str := digits[:1]
+		if len(digits) > 1 {
+			str = str + "." + digits[1:]
+		}
+
+		if adjustedExponent >= 0 {
+			return sign + str + "E+" + strconv.Itoa(adjustedExponent)
+		}
+
+		return sign + str + "E" + strconv.Itoa(adjustedExponent)
+	}
+
+	if exponent == 0 {
+		return sign + digits
+	}
+
+	// Place the decimal point inside or in front of the digits
+	position := len(digits) + exponent
+	if position > 0 {
+		return sign + digits[:position] + "." + digits[position:]
+	}
+
+	return sign + "0." + strings.Repeat("0", -position) + digits
+}
+
+// Parse a decimal string into a Decimal128, returning an error if the
+// string is not a number or the value can not be represented exactly
+func ParseDecimal128(str string) (Decimal128, error) {
+	original := str
+	negative := false
+
+	// Read the sign
+	if len(str) > 0 && (str[0] == '+' || str[0] == '-') {
+		negative = str[0] == '-'
+		str = str[1:]
+	}
+
+	var high uint64
+	if negative {
+		high = 1 << 63
+	}
+
+	// Special values
+	switch strings.ToLower(str) {
+	case "nan":
+		return Decimal128{0x7c00000000000000, 0}, nil
+	case "inf", "infinity":
+		return Decimal128{high | 0x7800000000000000, 0}, nil
+	}
+
+	// Split of the exponent
+	exponent := 0
+	if index := strings.IndexAny(str, "eE"); index != -1 {
+		exponentString := str[index+1:]
+		if len(exponentString) > 0 && (exponentString[0] == '+' || exponentString[0] == '-') {
+			exponentString = exponentString[1:]
+		}
+
+		// The exponent must be a non empty list of digits
+		if len(exponentString) == 0 || strings.TrimLeft(exponentString, "0123456789") != "" {
+			return Decimal128{}, invalidDecimal128(original)
+		}
+
+		value, err := strconv.Atoi(str[index+1:])
+		if err != nil {
+			return Decimal128{}, errors.New(fmt.Sprintf("decimal128 value %s exponent out of range", original))
+		}
+
+		exponent = value
+		str = str[:index]
+	}
+
+	// Collect the digits of the significand
+	digits := make([]byte, 0, len(str))
+	seenPoint := false
+	for i := 0; i < len(str); i++ {
+		switch {
+		case str[i] >= '0' && str[i] <= '9':
+			digits = append(digits, str[i])
+			// Digits after the decimal point lower the exponent
+			if seenPoint {
+				exponent = exponent - 1
+			}
+		case str[i] == '.' && !seenPoint:
+			seenPoint = true
+		default:
+			return Decimal128{}, invalidDecimal128(original)
+		}
+	}
+
+	if len(digits) == 0 {
+		return Decimal128{}, invalidDecimal128(original)
+	}
+
+	// Strip leading zeros
+	for len(digits) > 1 && digits[0] == '0' {
+		digits = digits[1:]
+	}
+
+	zero := len(digits) == 1 && digits[0] == '0'
+
+	// Drop trailing zeros while we have too many digits or the exponent is too small
+	for len(digits) > 1 && digits[len(digits)-1] == '0' && (len(digits) > decimal128MaxDigits || exponent < decimal128MinExponent) {
+		digits = digits[:len(digits)-1]
+		exponent = exponent + 1
+	}
+
+	// Add trailing zeros while the exponent is too large
+	for !zero && exponent > decimal128MaxExponent && len(digits) < decimal128MaxDigits {
+		digits = append(digits, '0')
+		exponent = exponent - 1
+	}
+
+	// Zero clamps to the exponent range
+	if zero {
+		if exponent > decimal128MaxExponent {
+			exponent = decimal128MaxExponent
+		} else if exponent < decimal128MinExponent {
+			exponent = decimal128MinExponent
+		}
+	}
+
+	if len(digits) > decimal128MaxDigits || exponent > decimal128MaxExponent || exponent < decimal128MinExponent {
+		return Decimal128{}, errors.New(fmt.Sprintf("decimal128 value %s can not be represented exactly", original))
+	}
+
+	// Build the significand
+	significand, _ := new(big.Int).SetString(string(digits), 10)
+	mask := new(big.Int).SetUint64(^uint64(0))
+	low := new(big.Int).And(significand, mask).Uint64()
+	high = high | new(big.Int).Rsh(significand, 64).Uint64()
+	high = high | uint64(exponent+decimal128ExponentBias)<<49
+
+	return Decimal128{high, low}, nil
+}
+
+func invalidDecimal128(str string) error {
+	return errors.New(fmt.Sprintf("%s is not a valid decimal128 value", str))
+}
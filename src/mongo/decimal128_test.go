@@ -0,0 +1,173 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Test file from the MongoDB BSON corpus
+type bsonCorpus struct {
+	Description  string            `json:"description"`
+	BsonType     string            `json:"bson_type"`
+	TestKey      string            `json:"test_key"`
+	Valid        []bsonCorpusValid `json:"valid"`
+	DecodeErrors []bsonCorpusError `json:"decodeErrors"`
+	ParseErrors  []bsonCorpusError `json:"parseErrors"`
+}
+
+type bsonCorpusValid struct {
+	Description       string `json:"description"`
+	CanonicalBson     string `json:"canonical_bson"`
+	CanonicalExtJSON  string `json:"canonical_extjson"`
+	RelaxedExtJSON    string `json:"relaxed_extjson"`
+	DegenerateBson    string `json:"degenerate_bson"`
+	DegenerateExtJSON string `json:"degenerate_extjson"`
+	ConvertedBson     string `json:"converted_bson"`
+	ConvertedExtJSON  string `json:"converted_extjson"`
+	Lossy             bool   `json:"lossy"`
+}
+
+type bsonCorpusError struct {
+	Description string `json:"description"`
+	Bson        string `json:"bson"`
+	String      string `json:"string"`
+}
+
+// Load the corpus files matching pattern
+func loadBSONCorpus(t testing.TB, pattern string) map[string]*bsonCorpus {
+	files, err := filepath.Glob(filepath.Join("testdata", "bson-corpus", pattern))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no corpus files found for %s %v", pattern, err)
+	}
+
+	corpus := make(map[string]*bsonCorpus)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatalf("failed to read %s %v", file, err)
+		}
+
+		tests := &bsonCorpus{}
+		err = json.Unmarshal(data, tests)
+		if err != nil {
+			t.Fatalf("failed to parse %s %v", file, err)
+		}
+
+		corpus[filepath.Base(file)] = tests
+	}
+
+	return corpus
+}
+
+// The string of the {"d": {"$numberDecimal": "..."}} extended json
+func decimal128ExtJSONString(t *testing.T, extJSON string) string {
+	value := struct {
+		D struct {
+			NumberDecimal string `json:"$numberDecimal"`
+		} `json:"d"`
+	}{}
+
+	err := json.Unmarshal([]byte(extJSON), &value)
+	if err != nil {
+		t.Fatalf("failed to parse extended json %s %v", extJSON, err)
+	}
+
+	return value.D.NumberDecimal
+}
+
+func decodeHex(t testing.TB, str string) []byte {
+	data, err := hex.DecodeString(str)
+	if err != nil {
+		t.Fatalf("failed to decode hex %s %v", str, err)
+	}
+
+	return data
+}
+
+func TestDecimal128Corpus(t *testing.T) {
+	parser := NewBSON()
+
+	for file, tests := range loadBSONCorpus(t, "decimal128-*.json") {
+		for _, test := range tests.Valid {
+			canonicalBson := decodeHex(t, test.CanonicalBson)
+			canonicalString := decimal128ExtJSONString(t, test.CanonicalExtJSON)
+
+			// Decode the canonical BSON
+			document := NewDocument()
+			err := parser.Unmarshal(canonicalBson, document)
+			if err != nil {
+				t.Errorf("%s %s: failed to decode %v", file, test.Description, err)
+				continue
+			}
+
+			value, err := document.Decimal128("d")
+			if err != nil {
+				t.Errorf("%s %s: %v", file, test.Description, err)
+				continue
+			}
+
+			// Format as the canonical string
+			if value.String() != canonicalString {
+				t.Errorf("%s %s: expected %s got %s", file, test.Description, canonicalString, value.String())
+			}
+
+			// Encode back to the canonical BSON
+			b, err := parser.Marshall(document, nil, 0)
+			if err != nil || !bytes.Equal(b, canonicalBson) {
+				t.Errorf("%s %s: failed to encode to canonical bson %v", file, test.Description, err)
+			}
+
+			// Parsing the strings produces the canonical BSON
+			strings := []string{canonicalString}
+			if test.DegenerateExtJSON != "" {
+				strings = append(strings, decimal128ExtJSONString(t, test.DegenerateExtJSON))
+			}
+
+			for _, str := range strings {
+				if test.Lossy {
+					continue
+				}
+
+				parsed, err := ParseDecimal128(str)
+				if err != nil {
+					t.Errorf("%s %s: failed to parse %s %v", file, test.Description, str, err)
+					continue
+				}
+
+				if parsed != *value {
+					t.Errorf("%s %s: parsing %s returned %x:%x expected %x:%x", file, test.Description, str, parsed.High, parsed.Low, value.High, value.Low)
+				}
+			}
+		}
+
+		for _, test := range tests.ParseErrors {
+			if _, err := ParseDecimal128(test.String); err == nil {
+				t.Errorf("%s %s: expected an error parsing %s", file, test.Description, test.String)
+			}
+		}
+	}
+}
+
+func TestDecimal128Struct(t *testing.T) {
+	type T struct {
+		Price   Decimal128  `bson:"price"`
+		Pointer *Decimal128 `bson:"pointer"`
+	}
+
+	price, err := ParseDecimal128("19.99")
+	if err != nil {
+		t.Fatalf("failed to parse decimal128 %v", err)
+	}
+
+	parser := NewBSON()
+	b, err := parser.Marshall(&T{price, &price}, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to marshall %v", err)
+	}
+
+	DeserializeTest(t, b, &T{}, &T{price, &price})
+}
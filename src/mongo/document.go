@@ -3,8 +3,9 @@ package mongo
 import (
 	"errors"
 	"fmt"
-	"log"
 	"reflect"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -27,11 +28,238 @@ func (p *Document) FieldCount() int {
 	return len(p.fields)
 }
 
+// Number of fields in the document
+func (p *Document) Len() int {
+	return len(p.fields)
+}
+
+// Add a field, a document holds each name once so adding an existing name
+// replaces its value in place like Set. Decoding BSON with duplicate keys
+// keeps the position of the first and the value of the last.
 func (p *Document) Add(name string, value interface{}) {
-	p.fields = append(p.fields, name)
+	p.Set(name, value)
+}
+
+// Set the value of a field, an existing field keeps its position and new
+// fields are appended
+func (p *Document) Set(name string, value interface{}) {
+	if _, ok := p.document[name]; !ok {
+		p.fields = append(p.fields, name)
+	}
+
 	p.document[name] = value
 }
 
+// Remove a field, returns false if the document has no such field
+func (p *Document) Delete(name string) bool {
+	if _, ok := p.document[name]; !ok {
+		return false
+	}
+
+	delete(p.document, name)
+	for i, key := range p.fields {
+		if key == name {
+			p.fields = append(p.fields[:i], p.fields[i+1:]...)
+			break
+		}
+	}
+
+	return true
+}
+
+func (p *Document) Has(name string) bool {
+	_, ok := p.document[name]
+	return ok
+}
+
+// The value of a field, returns false if the document has no such field
+func (p *Document) Get(name string) (interface{}, bool) {
+	value, ok := p.document[name]
+	return value, ok
+}
+
+// The field names in order
+func (p *Document) Keys() []string {
+	keys := make([]string, len(p.fields))
+	copy(keys, p.fields)
+	return keys
+}
+
+// Return the value at a dotted path like "a.b.0.c" through embedded
+// documents, maps and arrays, arrays including typed slices like
+// []*Document are indexed by position
+func (p *Document) Lookup(path string) (interface{}, error) {
+	var value interface{} = p
+
+	for _, part := range strings.Split(path, ".") {
+		switch elem := value.(type) {
+		case *Document:
+			field, ok := elem.document[part]
+			if !ok {
+				return nil, ErrElementNotFound
+			}
+
+			value = field
+		case Document:
+			field, ok := elem.document[part]
+			if !ok {
+				return nil, ErrElementNotFound
+			}
+
+			value = field
+		case map[string]interface{}:
+			field, ok := elem[part]
+			if !ok {
+				return nil, ErrElementNotFound
+			}
+
+			value = field
+		case []interface{}:
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= len(elem) {
+				return nil, ErrElementNotFound
+			}
+
+			value = elem[index]
+		default:
+			// Typed slices and Go arrays like []*Document, byte slices and
+			// arrays are binary values
+			array := reflect.ValueOf(elem)
+			if (array.Kind() != reflect.Slice && array.Kind() != reflect.Array) || array.Type().Elem().Kind() == reflect.Uint8 {
+				// Only documents and arrays can be traversed
+				return nil, ErrElementNotFound
+			}
+
+			index, err := strconv.Atoi(part)
+			if err != nil || index < 0 || index >= array.Len() {
+				return nil, ErrElementNotFound
+			}
+
+			value = array.Index(index).Interface()
+		}
+	}
+
+	return value, nil
+}
+
+// Iterates over the fields of a Document in order, the document must not
+// be modified during the iteration
+type DocumentIterator struct {
+	document *Document
+	index    int
+}
+
+func (p *Document) Iterator() *DocumentIterator {
+	return &DocumentIterator{p, -1}
+}
+
+// Move to the next field, returns false at the end of the document
+func (p *DocumentIterator) Next() bool {
+	if p.index+1 >= len(p.document.fields) {
+		return false
+	}
+
+	p.index = p.index + 1
+	return true
+}
+
+// The current field
+func (p *DocumentIterator) Element() KeyValue {
+	key := p.document.fields[p.index]
+	return KeyValue{key, p.document.document[key]}
+}
+
+// Deep copy of the document, embedded documents, maps, arrays and the
+// BSON types holding byte slices are copied as well
+func (p *Document) Clone() *Document {
+	document := &Document{make([]string, len(p.fields)), make(map[string]interface{}, len(p.fields))}
+	copy(document.fields, p.fields)
+
+	for key, value := range p.document {
+		document.document[key] = cloneValue(value)
+	}
+
+	return document
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
+
+// Deep copy of a value held by a Document
+func cloneValue(value interface{}) interface{} {
+	switch elem := value.(type) {
+	case *Document:
+		return elem.Clone()
+	case Document:
+		return *elem.Clone()
+	case map[string]interface{}:
+		values := make(map[string]interface{}, len(elem))
+		for key, value := range elem {
+			values[key] = cloneValue(value)
+		}
+
+		return values
+	case []interface{}:
+		values := make([]interface{}, len(elem))
+		for i, value := range elem {
+			values[i] = cloneValue(value)
+		}
+
+		return values
+	case []byte:
+		return cloneBytes(elem)
+	case ObjectId:
+		return ObjectId{cloneBytes(elem.Id)}
+	case *ObjectId:
+		return &ObjectId{cloneBytes(elem.Id)}
+	case Binary:
+		return Binary{elem.SubType, cloneBytes(elem.Data)}
+	case *Binary:
+		return &Binary{elem.SubType, cloneBytes(elem.Data)}
+	case DBPointer:
+		return DBPointer{elem.Namespace, ObjectId{cloneBytes(elem.Id.Id)}}
+	case *DBPointer:
+		return &DBPointer{elem.Namespace, ObjectId{cloneBytes(elem.Id.Id)}}
+	case JavascriptWScope:
+		return JavascriptWScope{elem.Code, cloneScope(elem.Scope)}
+	case *JavascriptWScope:
+		return &JavascriptWScope{elem.Code, cloneScope(elem.Scope)}
+	case Raw:
+		return Raw{elem.Kind, cloneBytes(elem.Data)}
+	case *RegExp:
+		regExp := *elem
+		return &regExp
+	case *Javascript:
+		javascript := *elem
+		return &javascript
+	case *Symbol:
+		symbol := *elem
+		return &symbol
+	case *Timestamp:
+		timestamp := *elem
+		return &timestamp
+	case *Decimal128:
+		decimal := *elem
+		return &decimal
+	}
+
+	// Values without references
+	return value
+}
+
+func cloneScope(scope *Document) *Document {
+	if scope == nil {
+		return nil
+	}
+
+	return scope.Clone()
+}
+
 func (p *Document) Float64(name string) (float64, error) {
 	switch elem := p.document[name].(type) {
 	default:
@@ -140,6 +368,33 @@ func (p *Document) JavascriptWScope(name string) (*JavascriptWScope, error) {
 	}
 }
 
+func (p *Document) Symbol(name string) (*Symbol, error) {
+	switch elem := p.document[name].(type) {
+	default:
+		return nil, errors.New(fmt.Sprintf("field %v is not a symbol", name))
+	case *Symbol:
+		return elem, nil
+	}
+}
+
+func (p *Document) Undefined(name string) (*Undefined, error) {
+	switch elem := p.document[name].(type) {
+	default:
+		return nil, errors.New(fmt.Sprintf("field %v is not undefined", name))
+	case *Undefined:
+		return elem, nil
+	}
+}
+
+func (p *Document) DBPointer(name string) (*DBPointer, error) {
+	switch elem := p.document[name].(type) {
+	default:
+		return nil, errors.New(fmt.Sprintf("field %v is not a db pointer", name))
+	case *DBPointer:
+		return elem, nil
+	}
+}
+
 func (p *Document) Min(name string) (*Min, error) {
 	switch elem := p.document[name].(type) {
 	default:
@@ -158,13 +413,26 @@ func (p *Document) Max(name string) (*Max, error) {
 	}
 }
 
+// The elements of an array, arrays added as typed slices or Go arrays
+// like []string are returned with their elements boxed
 func (p *Document) Array(name string) ([]interface{}, error) {
 	switch elem := p.document[name].(type) {
-	default:
-		return nil, errors.New(fmt.Sprintf("field %v is not an array", name))
 	case []interface{}:
 		return elem, nil
+	default:
+		// Byte slices and arrays are binary values
+		value := reflect.ValueOf(elem)
+		if (value.Kind() == reflect.Slice || value.Kind() == reflect.Array) && value.Type().Elem().Kind() != reflect.Uint8 {
+			array := make([]interface{}, value.Len())
+			for i := range array {
+				array[i] = value.Index(i).Interface()
+			}
+
+			return array, nil
+		}
 	}
+
+	return nil, errors.New(fmt.Sprintf("field %v is not an array", name))
 }
 
 func (p *Document) Time(name string) (time.Time, error) {
@@ -207,19 +475,29 @@ func (p *Document) UInt64(name string) (uint64, error) {
 	}
 }
 
+func (p *Document) Decimal128(name string) (*Decimal128, error) {
+	switch elem := p.document[name].(type) {
+	default:
+		return nil, errors.New(fmt.Sprintf("field %v is not a decimal128", name))
+	case *Decimal128:
+		return elem, nil
+	}
+}
+
 func (p *Document) Equal(doc *Document) bool {
+	return p.firstDifference(doc) == -1
+}
+
+// Index of the first field that differs between the documents, -1 if
+// they are equal. Missing fields differ at the length of the shorter one.
+func (p *Document) firstDifference(doc *Document) int {
 	// Get the current fields in order
 	docFields1 := p.FieldsInOrder()
 	// Get the passed in fields
 	docFields2 := doc.FieldsInOrder()
 
-	// Validate that the
-	if len(docFields1) != len(docFields2) {
-		return false
-	}
-
 	// Compare all the fields
-	for i, _ := range docFields1 {
+	for i := 0; i < len(docFields1) && i < len(docFields2); i++ {
 		// Get key/value 1
 		name1 := docFields1[i].Name
 		value1 := docFields1[i].Value
@@ -229,7 +507,7 @@ func (p *Document) Equal(doc *Document) bool {
 
 		// Names does not match
 		if name1 != name2 {
-			return false
+			return i
 		}
 
 		// Check if it's a document type
@@ -239,28 +517,66 @@ func (p *Document) Equal(doc *Document) bool {
 			switch val2 := value2.(type) {
 			case Document:
 				if val1.Equal(&val2) == false {
-					return false
+					return i
 				}
 			default:
-				return false
+				return i
 			}
 		case *Document:
 			switch val2 := value2.(type) {
 			case *Document:
 				if val1.Equal(val2) == false {
-					return false
+					return i
 				}
 			}
 		}
 
 		// Perform a reflection equality
 		if reflect.DeepEqual(value1, value2) == false {
-			log.Printf("%+v != %+v", value1, value2)
-			return false
+			return i
 		}
 	}
 
-	return true
+	// Validate that the number of fields match
+	if len(docFields1) != len(docFields2) {
+		if len(docFields1) < len(docFields2) {
+			return len(docFields1)
+		}
+
+		return len(docFields2)
+	}
+
+	return -1
+}
+
+// Describe the first field that differs between the documents as relaxed
+// Extended JSON, like {"a": 1} != {"a": 2}. Returns an empty string if the
+// documents are equal.
+func (p *BSON) Diff(doc1 *Document, doc2 *Document) string {
+	i := doc1.firstDifference(doc2)
+	if i == -1 {
+		return ""
+	}
+
+	return fmt.Sprintf("%s != %s", p.fieldExtJSON(doc1, i), p.fieldExtJSON(doc2, i))
+}
+
+// Relaxed extended json of the field at index i of the document
+func (p *BSON) fieldExtJSON(doc *Document, i int) string {
+	if i >= len(doc.fields) {
+		return "<missing>"
+	}
+
+	name := doc.fields[i]
+	document := NewDocument()
+	document.Add(name, doc.document[name])
+
+	b, err := p.MarshalExtJSON(document, false)
+	if err != nil {
+		return fmt.Sprintf("%v: %+v", name, doc.document[name])
+	}
+
+	return string(b)
 }
 
 func (p *Document) FieldsInOrder() []KeyValue {
@@ -0,0 +1,239 @@
+package mongo
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDocumentSetAndDelete(t *testing.T) {
+	document := NewDocument()
+	document.Add("a", int32(1))
+	document.Add("b", "b")
+	document.Add("c", true)
+
+	// Existing fields keep their position
+	document.Set("a", int32(2))
+	document.Add("b", "c")
+	document.Set("d", 1.5)
+
+	expected := []KeyValue{{"a", int32(2)}, {"b", "c"}, {"c", true}, {"d", 1.5}}
+	if !reflect.DeepEqual(document.FieldsInOrder(), expected) {
+		t.Errorf("expected %v got %v", expected, document.FieldsInOrder())
+	}
+
+	if document.Len() != 4 || document.FieldCount() != 4 {
+		t.Errorf("expected 4 fields got %v", document.Len())
+	}
+
+	if !document.Delete("b") || document.Delete("b") || document.Has("b") {
+		t.Errorf("expected b to be deleted once")
+	}
+
+	if !reflect.DeepEqual(document.Keys(), []string{"a", "c", "d"}) {
+		t.Errorf("unexpected keys %v", document.Keys())
+	}
+
+	if value, ok := document.Get("d"); !ok || value != 1.5 {
+		t.Errorf("unexpected value %v %v", value, ok)
+	}
+
+	if _, ok := document.Get("b"); ok {
+		t.Errorf("expected b to be missing")
+	}
+
+	// Re-adding a deleted field appends it
+	document.Add("b", nil)
+	if !reflect.DeepEqual(document.Keys(), []string{"a", "c", "d", "b"}) || !document.Has("b") {
+		t.Errorf("unexpected keys %v", document.Keys())
+	}
+
+	// Modifying the keys does not modify the document
+	document.Keys()[0] = "z"
+	if document.Keys()[0] != "a" {
+		t.Errorf("expected the keys to be a copy")
+	}
+}
+
+func TestDocumentDuplicateKeys(t *testing.T) {
+	// {a: 1, b: 2, a: 3}
+	b := []byte{
+		26, 0, 0, 0,
+		0x10, 'a', 0, 1, 0, 0, 0,
+		0x10, 'b', 0, 2, 0, 0, 0,
+		0x10, 'a', 0, 3, 0, 0, 0,
+		0,
+	}
+
+	// The first position and the last value are kept
+	document := NewDocument()
+	err := NewBSON().Unmarshal(b, document)
+	if err != nil {
+		t.Fatalf("failed to decode %v", err)
+	}
+
+	expected := []KeyValue{{"a", int32(3)}, {"b", int32(2)}}
+	if !reflect.DeepEqual(document.FieldsInOrder(), expected) {
+		t.Errorf("expected %v got %v", expected, document.FieldsInOrder())
+	}
+}
+
+func TestDocumentLookup(t *testing.T) {
+	inner := NewDocument()
+	inner.Add("c", "found")
+
+	document := NewDocument()
+	document.Add("a", NewDocument())
+	document.Add("list", []interface{}{int32(1), inner, map[string]interface{}{"m": int64(2)}})
+	document.Add("value", "string")
+	a, _ := document.Document("a")
+	a.Add("b", []interface{}{inner})
+
+	tests := map[string]interface{}{
+		"value":      "string",
+		"a.b.0.c":    "found",
+		"list.0":     int32(1),
+		"list.1.c":   "found",
+		"list.2.m":   int64(2),
+		"a.b.0":      inner,
+		"list.2":     map[string]interface{}{"m": int64(2)},
+		"list":       []interface{}{int32(1), inner, map[string]interface{}{"m": int64(2)}},
+		"a.b.0.c.x.": nil,
+	}
+
+	for path, expected := range tests {
+		value, err := document.Lookup(path)
+		if expected == nil {
+			if err != ErrElementNotFound {
+				t.Errorf("expected %v looking up %v got %v", ErrElementNotFound, path, err)
+			}
+		} else if err != nil || !reflect.DeepEqual(value, expected) {
+			t.Errorf("expected %v looking up %v got %v %v", expected, path, value, err)
+		}
+	}
+
+	for _, path := range []string{"missing", "a.missing", "list.3", "list.-1", "list.a", "value.a", ""} {
+		if _, err := document.Lookup(path); err != ErrElementNotFound {
+			t.Errorf("expected %v looking up %v got %v", ErrElementNotFound, path, err)
+		}
+	}
+}
+
+func TestDocumentLookupTypedArrays(t *testing.T) {
+	inner := NewDocument()
+	inner.Set("b", "found")
+
+	document := NewDocument()
+	document.Set("a", []*Document{inner})
+	document.Set("strings", []string{"x", "y"})
+	document.Set("fixed", [2]int32{1, 2})
+	document.Set("binary", []byte{1, 2})
+
+	tests := map[string]interface{}{
+		"a.0.b":     "found",
+		"a.0":       inner,
+		"strings.1": "y",
+		"fixed.0":   int32(1),
+	}
+
+	for path, expected := range tests {
+		value, err := document.Lookup(path)
+		if err != nil || !reflect.DeepEqual(value, expected) {
+			t.Errorf("expected %v looking up %v got %v %v", expected, path, value, err)
+		}
+	}
+
+	// Binary values are not arrays
+	for _, path := range []string{"a.1", "a.x", "strings.-1", "fixed.2", "binary.0", "a.0.b.0"} {
+		if _, err := document.Lookup(path); err != ErrElementNotFound {
+			t.Errorf("expected %v looking up %v got %v", ErrElementNotFound, path, err)
+		}
+	}
+}
+
+func TestDocumentIterator(t *testing.T) {
+	document := NewDocument()
+	document.Add("a", int32(1))
+	document.Add("b", "b")
+	document.Add("c", nil)
+
+	elements := make([]KeyValue, 0)
+	iterator := document.Iterator()
+	for iterator.Next() {
+		elements = append(elements, iterator.Element())
+	}
+
+	if !reflect.DeepEqual(elements, document.FieldsInOrder()) {
+		t.Errorf("expected %v got %v", document.FieldsInOrder(), elements)
+	}
+
+	if NewDocument().Iterator().Next() {
+		t.Errorf("expected no elements in an empty document")
+	}
+}
+
+func TestDocumentClone(t *testing.T) {
+	document := allTypesDocument()
+	document.Add("map", map[string]interface{}{"a": []interface{}{NewDocument()}})
+
+	clone := document.Clone()
+	if !reflect.DeepEqual(clone, document) {
+		t.Fatalf("expected %v got %v", document, clone)
+	}
+
+	// Modifying the clone does not modify the original
+	subdocument, _ := clone.Document("doc")
+	subdocument.Set("int", int32(11))
+	binary, _ := clone.Binary("binary")
+	binary.Data[0] = binary.Data[0] + 1
+	id, _ := clone.ObjectId("objectid")
+	id.Id[0] = id.Id[0] + 1
+	array, _ := clone.Array("array")
+	array[0] = "changed"
+	clone.document["map"].(map[string]interface{})["a"].([]interface{})[0].(*Document).Add("a", 1)
+	clone.Delete("string")
+
+	if reflect.DeepEqual(clone, document) || !reflect.DeepEqual(allTypesDocument().document["doc"], document.document["doc"]) {
+		t.Errorf("expected the clone to be independent of the original")
+	}
+
+	original := allTypesDocument()
+	original.Add("map", map[string]interface{}{"a": []interface{}{NewDocument()}})
+	if !reflect.DeepEqual(original, document) {
+		t.Errorf("the original document was modified %v", document)
+	}
+}
+
+func TestDocumentEqualAndDiff(t *testing.T) {
+	newDocument := func(fields ...interface{}) *Document {
+		document := NewDocument()
+		for i := 0; i < len(fields); i += 2 {
+			document.Add(fields[i].(string), fields[i+1])
+		}
+
+		return document
+	}
+
+	parser := NewBSON()
+	for _, test := range []struct {
+		name     string
+		doc1     *Document
+		doc2     *Document
+		expected string
+	}{
+		{"equal", allTypesDocument(), allTypesDocument(), ""},
+		{"value", newDocument("a", int32(1), "b", "c"), newDocument("a", int32(1), "b", "d"), `{"b":"c"} != {"b":"d"}`},
+		{"name", newDocument("a", int32(1)), newDocument("b", int32(1)), `{"a":1} != {"b":1}`},
+		{"type", newDocument("a", int32(1)), newDocument("a", int64(1)), `{"a":1} != {"a":1}`},
+		{"nested", newDocument("a", newDocument("b", true)), newDocument("a", newDocument("b", false)), `{"a":{"b":true}} != {"a":{"b":false}}`},
+		{"missing", newDocument("a", int32(1), "b", int32(2)), newDocument("a", int32(1)), `{"b":2} != <missing>`},
+		{"extra", newDocument(), newDocument("a", int32(1)), `<missing> != {"a":1}`},
+	} {
+		if equal := test.doc1.Equal(test.doc2); equal != (test.expected == "") {
+			t.Errorf("%v: expected Equal to be %v", test.name, !equal)
+		}
+
+		if diff := parser.Diff(test.doc1, test.doc2); diff != test.expected {
+			t.Errorf("%v: expected the diff %v got %v", test.name, test.expected, diff)
+		}
+	}
+}
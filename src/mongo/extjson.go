@@ -0,0 +1,768 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Keys marking an extended json object as a BSON type
+var extJSONTypeKeys = map[string]bool{
+	"$oid":               true,
+	"$symbol":            true,
+	"$numberInt":         true,
+	"$numberLong":        true,
+	"$numberDouble":      true,
+	"$numberDecimal":     true,
+	"$binary":            true,
+	"$uuid":              true,
+	"$code":              true,
+	"$scope":             true,
+	"$timestamp":         true,
+	"$regularExpression": true,
+	"$dbPointer":         true,
+	"$date":              true,
+	"$minKey":            true,
+	"$maxKey":            true,
+	"$undefined":         true,
+}
+
+// Encode a value into MongoDB Extended JSON v2, canonical preserves every
+// BSON type while relaxed uses plain JSON numbers and ISO-8601 dates
+func (p *BSON) MarshalExtJSON(doc interface{}, canonical bool) ([]byte, error) {
+	// Raw documents are validated as they are read in place
+	document, ok := doc.(RawDocument)
+	if ok {
+		err := document.Validate()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		b, err := p.Marshall(doc, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		document = RawDocument(b)
+	}
+
+	writer := &extJSONWriter{new(bytes.Buffer), canonical}
+	err := writer.writeDocument(document)
+	if err != nil {
+		return nil, err
+	}
+
+	return writer.buffer.Bytes(), nil
+}
+
+// Decode canonical or relaxed MongoDB Extended JSON v2 into a struct or
+// *Document
+func (p *BSON) UnmarshalExtJSON(data []byte, obj interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	// Read the top level object
+	value, err := readExtJSONValue(decoder)
+	if err != nil {
+		return err
+	}
+
+	object, ok := value.(*extJSONObject)
+	if !ok {
+		return errors.New("extended json must be an object")
+	}
+
+	// Nothing can follow the object
+	if _, err := decoder.Token(); err != io.EOF {
+		return errors.New("unexpected data after the extended json object")
+	}
+
+	document, err := object.document()
+	if err != nil {
+		return err
+	}
+
+	// Go through BSON so structs are decoded the same way
+	b, err := p.Marshall(document, nil, 0)
+	if err != nil {
+		return err
+	}
+
+	return p.Unmarshal(b, obj)
+}
+
+type extJSONWriter struct {
+	buffer    *bytes.Buffer
+	canonical bool
+}
+
+func (p *extJSONWriter) writeDocument(document RawDocument) error {
+	p.buffer.WriteByte('{')
+
+	iterator := document.Iterator()
+	for i := 0; iterator.Next(); i++ {
+		if i > 0 {
+			p.buffer.WriteByte(',')
+		}
+
+		element := iterator.Element()
+		p.writeString(element.Key())
+		p.buffer.WriteByte(':')
+
+		err := p.writeValue(element.Value())
+		if err != nil {
+			return errors.New(fmt.Sprintf("field %v: %v", element.Key(), err))
+		}
+	}
+
+	p.buffer.WriteByte('}')
+	return iterator.Err()
+}
+
+func (p *extJSONWriter) writeArray(document RawDocument) error {
+	p.buffer.WriteByte('[')
+
+	iterator := document.Iterator()
+	for i := 0; iterator.Next(); i++ {
+		if i > 0 {
+			p.buffer.WriteByte(',')
+		}
+
+		// The keys of arrays are ignored
+		err := p.writeValue(iterator.Element().Value())
+		if err != nil {
+			return err
+		}
+	}
+
+	p.buffer.WriteByte(']')
+	return iterator.Err()
+}
+
+// Write a JSON string escaping quotes, backslashes and control characters
+func (p *extJSONWriter) writeString(value string) {
+	p.buffer.WriteByte('"')
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '"' || c == '\\':
+			p.buffer.WriteByte('\\')
+			p.buffer.WriteByte(c)
+		case c == '\b':
+			p.buffer.WriteString("\\b")
+		case c == '\f':
+			p.buffer.WriteString("\\f")
+		case c == '\n':
+			p.buffer.WriteString("\\n")
+		case c == '\r':
+			p.buffer.WriteString("\\r")
+		case c == '\t':
+			p.buffer.WriteString("\\t")
+		case c < 0x20:
+			p.buffer.WriteString(fmt.Sprintf("\\u%04x", c))
+		default:
+			p.buffer.WriteByte(c)
+		}
+	}
+
+	p.buffer.WriteByte('"')
+}
+
+// Write a single key object {"key": value} with a string value
+func (p *extJSONWriter) writeWrapped(key string, value string) {
+	p.buffer.WriteString(`{"` + key + `":`)
+	p.writeString(value)
+	p.buffer.WriteByte('}')
+}
+
+// Regular expression options are in alphabetical order
+func sortRegExpOptions(options string) string {
+	sorted := []byte(options)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return string(sorted)
+}
+
+// Format a double with at least one decimal place for integers
+func formatExtJSONDouble(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "Infinity"
+	case math.IsInf(value, -1):
+		return "-Infinity"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+
+	str := strconv.FormatFloat(value, 'G', -1, 64)
+	if !strings.ContainsAny(str, ".E") {
+		str = str + ".0"
+	}
+
+	return str
+}
+
+func (p *extJSONWriter) writeValue(value Raw) error {
+	switch value.Kind {
+	case byte(bsonDouble):
+		double, _ := value.Float64()
+		if p.canonical || math.IsInf(double, 0) || math.IsNaN(double) {
+			p.writeWrapped("$numberDouble", formatExtJSONDouble(double))
+		} else {
+			p.buffer.WriteString(formatExtJSONDouble(double))
+		}
+	case byte(bsonString):
+		str, _ := value.StringValue()
+		p.writeString(str)
+	case byte(bsonDocument):
+		return p.writeDocument(RawDocument(value.Data))
+	case byte(bsonArray):
+		return p.writeArray(RawDocument(value.Data))
+	case byte(bsonBinary):
+		subType, data, _ := value.Binary()
+		p.buffer.WriteString(`{"$binary":{"base64":`)
+		p.writeString(base64.StdEncoding.EncodeToString(data))
+		p.buffer.WriteString(fmt.Sprintf(`,"subType":"%02x"}}`, subType))
+	case byte(bsonUndefined):
+		p.buffer.WriteString(`{"$undefined":true}`)
+	case byte(bsonObjectId):
+		p.writeWrapped("$oid", hex.EncodeToString(value.Data[:12]))
+	case byte(bsonBoolean):
+		if value.Data[0] == 1 {
+			p.buffer.WriteString("true")
+		} else {
+			p.buffer.WriteString("false")
+		}
+	case byte(bsonDateTime):
+		milliseconds := int64(readUInt64(value.Data, 0))
+		date := millisecondsToTime(milliseconds)
+
+		// Relaxed dates between 1970 and 9999 are ISO-8601 strings
+		if !p.canonical && milliseconds >= 0 && date.Year() <= 9999 {
+			p.buffer.WriteString(`{"$date":`)
+			p.writeString(date.Format("2006-01-02T15:04:05.999Z07:00"))
+			p.buffer.WriteByte('}')
+		} else {
+			p.buffer.WriteString(`{"$date":`)
+			p.writeWrapped("$numberLong", strconv.FormatInt(milliseconds, 10))
+			p.buffer.WriteByte('}')
+		}
+	case byte(bsonNull):
+		p.buffer.WriteString("null")
+	case byte(bsonRegExp):
+		pattern, index, err := readCString(value.Data, 0)
+		if err != nil {
+			return err
+		}
+
+		options, _, err := readCString(value.Data, index)
+		if err != nil {
+			return err
+		}
+
+		p.buffer.WriteString(`{"$regularExpression":{"pattern":`)
+		p.writeString(pattern)
+		p.buffer.WriteString(`,"options":`)
+		p.writeString(sortRegExpOptions(options))
+		p.buffer.WriteString("}}")
+	case byte(bsonDBPointer):
+		namespace, index := readString(value.Data, 0)
+		p.buffer.WriteString(`{"$dbPointer":{"$ref":`)
+		p.writeString(namespace)
+		p.buffer.WriteString(`,"$id":`)
+		p.writeWrapped("$oid", hex.EncodeToString(value.Data[index:index+12]))
+		p.buffer.WriteString("}}")
+	case byte(bsonJavaScript):
+		code, _ := readString(value.Data, 0)
+		p.writeWrapped("$code", code)
+	case byte(bsonSymbol):
+		symbol, _ := readString(value.Data, 0)
+		p.writeWrapped("$symbol", symbol)
+	case byte(bsonJavaScriptWScope):
+		// Skip the total size
+		code, index := readString(value.Data, 4)
+		p.buffer.WriteString(`{"$code":`)
+		p.writeString(code)
+		p.buffer.WriteString(`,"$scope":`)
+		err := p.writeDocument(RawDocument(value.Data[index:]))
+		if err != nil {
+			return err
+		}
+
+		p.buffer.WriteByte('}')
+	case byte(bsonInt32):
+		i, _ := value.Int32()
+		if p.canonical {
+			p.writeWrapped("$numberInt", strconv.FormatInt(int64(i), 10))
+		} else {
+			p.buffer.WriteString(strconv.FormatInt(int64(i), 10))
+		}
+	case byte(bsonTimestamp):
+		timestamp := readUInt64(value.Data, 0)
+		p.buffer.WriteString(fmt.Sprintf(`{"$timestamp":{"t":%d,"i":%d}}`, uint32(timestamp>>32), uint32(timestamp)))
+	case byte(bsonInt64):
+		i, _ := value.Int64()
+		if p.canonical {
+			p.writeWrapped("$numberLong", strconv.FormatInt(i, 10))
+		} else {
+			p.buffer.WriteString(strconv.FormatInt(i, 10))
+		}
+	case byte(bsonDecimal128):
+		decimal, _ := value.Decimal128()
+		p.writeWrapped("$numberDecimal", decimal.String())
+	case byte(bsonMinKey):
+		p.buffer.WriteString(`{"$minKey":1}`)
+	case byte(bsonMaxKey):
+		p.buffer.WriteString(`{"$maxKey":1}`)
+	default:
+		return errors.New(fmt.Sprintf("unknown BSON type 0x%02x", value.Kind))
+	}
+
+	return nil
+}
+
+// A JSON object with its keys in order
+type extJSONObject struct {
+	keys   []string
+	values []interface{}
+}
+
+// The value of the key and if it exists
+func (p *extJSONObject) get(key string) (interface{}, bool) {
+	for i, k := range p.keys {
+		if k == key {
+			return p.values[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Check the object has exactly the keys
+func (p *extJSONObject) hasKeys(keys ...string) bool {
+	if len(p.keys) != len(keys) {
+		return false
+	}
+
+	for _, key := range keys {
+		if _, ok := p.get(key); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Read a JSON value keeping the order of object keys, numbers are
+// returned as json.Number, objects as *extJSONObject
+func readExtJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := &extJSONObject{}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := readExtJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+
+			object.keys = append(object.keys, key.(string))
+			object.values = append(object.values, value)
+		}
+
+		// Read the closing brace
+		_, err = decoder.Token()
+		return object, err
+	case json.Delim('['):
+		array := make([]interface{}, 0)
+		for decoder.More() {
+			value, err := readExtJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+
+			array = append(array, value)
+		}
+
+		// Read the closing bracket
+		_, err = decoder.Token()
+		return array, err
+	}
+
+	return token, nil
+}
+
+// Convert the object into a Document
+func (p *extJSONObject) document() (*Document, error) {
+	document := NewDocument()
+	for i, key := range p.keys {
+		if strings.IndexByte(key, 0) != -1 {
+			return nil, errors.New(fmt.Sprintf("key %q contains a null byte", key))
+		}
+
+		value, err := extJSONToValue(p.values[i])
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("field %v: %v", key, err))
+		}
+
+		document.Add(key, value)
+	}
+
+	return document, nil
+}
+
+// Convert a JSON value into the value stored in a Document
+func extJSONToValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return extJSONNumber(v)
+	case []interface{}:
+		array := make([]interface{}, len(v))
+		for i, element := range v {
+			converted, err := extJSONToValue(element)
+			if err != nil {
+				return nil, err
+			}
+
+			array[i] = converted
+		}
+
+		return array, nil
+	case *extJSONObject:
+		for _, key := range v.keys {
+			if extJSONTypeKeys[key] {
+				return v.bsonValue()
+			}
+		}
+
+		return v.document()
+	}
+
+	// Strings, booleans and null
+	return value, nil
+}
+
+// Relaxed numbers are int32 or int64 when they fit, doubles otherwise
+func extJSONNumber(number json.Number) (interface{}, error) {
+	str := number.String()
+	if !strings.ContainsAny(str, ".eE") {
+		if i, err := strconv.ParseInt(str, 10, 32); err == nil {
+			return int32(i), nil
+		}
+
+		if i, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return i, nil
+		}
+	}
+
+	return strconv.ParseFloat(str, 64)
+}
+
+// Extended json string value of the key
+func (p *extJSONObject) stringValue(key string) (string, error) {
+	value, _ := p.get(key)
+	str, ok := value.(string)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("%v must be a string", key))
+	}
+
+	return str, nil
+}
+
+// Extended json object value of the key
+func (p *extJSONObject) objectValue(key string) (*extJSONObject, error) {
+	value, _ := p.get(key)
+	object, ok := value.(*extJSONObject)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("%v must be an object", key))
+	}
+
+	return object, nil
+}
+
+// Extended json unsigned 32 bit integer value of the key
+func (p *extJSONObject) uint32Value(key string) (uint32, error) {
+	value, _ := p.get(key)
+	number, ok := value.(json.Number)
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("%v must be a number", key))
+	}
+
+	i, err := strconv.ParseUint(number.String(), 10, 32)
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("%v must be an unsigned 32 bit integer", key))
+	}
+
+	return uint32(i), nil
+}
+
+// Parse a $uuid string in the 8-4-4-4-12 hex format
+func parseExtJSONUUID(value string) ([]byte, error) {
+	parts := strings.Split(value, "-")
+	if len(parts) != 5 || len(parts[0]) != 8 || len(parts[1]) != 4 || len(parts[2]) != 4 || len(parts[3]) != 4 || len(parts[4]) != 12 {
+		return nil, errors.New(fmt.Sprintf("%v is not a valid uuid", value))
+	}
+
+	uuid, err := hex.DecodeString(strings.Join(parts, ""))
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%v is not a valid uuid", value))
+	}
+
+	return uuid, nil
+}
+
+// Convert an object holding a BSON type key into the BSON value
+func (p *extJSONObject) bsonValue() (interface{}, error) {
+	switch {
+	case p.hasKeys("$oid"):
+		str, err := p.stringValue("$oid")
+		if err != nil {
+			return nil, err
+		}
+
+		return ObjectIdFromHex(str)
+	case p.hasKeys("$symbol"):
+		str, err := p.stringValue("$symbol")
+		return Symbol{str}, err
+	case p.hasKeys("$numberInt"):
+		str, err := p.stringValue("$numberInt")
+		if err != nil {
+			return nil, err
+		}
+
+		i, err := strconv.ParseInt(str, 10, 32)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%v is not a valid int32", str))
+		}
+
+		return int32(i), nil
+	case p.hasKeys("$numberLong"):
+		str, err := p.stringValue("$numberLong")
+		if err != nil {
+			return nil, err
+		}
+
+		i, err := strconv.ParseInt(str, 10, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%v is not a valid int64", str))
+		}
+
+		return i, nil
+	case p.hasKeys("$numberDouble"):
+		str, err := p.stringValue("$numberDouble")
+		if err != nil {
+			return nil, err
+		}
+
+		switch str {
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		case "NaN":
+			return math.NaN(), nil
+		}
+
+		double, err := strconv.ParseFloat(str, 64)
+		if err != nil || strings.ContainsAny(str, "xXnN_") {
+			return nil, errors.New(fmt.Sprintf("%v is not a valid double", str))
+		}
+
+		return double, nil
+	case p.hasKeys("$numberDecimal"):
+		str, err := p.stringValue("$numberDecimal")
+		if err != nil {
+			return nil, err
+		}
+
+		return ParseDecimal128(str)
+	case p.hasKeys("$binary"):
+		binary, err := p.objectValue("$binary")
+		if err != nil {
+			return nil, err
+		}
+
+		if !binary.hasKeys("base64", "subType") {
+			return nil, errors.New("$binary must have the base64 and subType fields")
+		}
+
+		str, err := binary.stringValue("base64")
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%v is not valid base64", str))
+		}
+
+		subType, err := binary.stringValue("subType")
+		if err != nil {
+			return nil, err
+		}
+
+		kind, err := strconv.ParseUint(subType, 16, 8)
+		if err != nil || len(subType) > 2 {
+			return nil, errors.New(fmt.Sprintf("%v is not a valid binary subtype", subType))
+		}
+
+		return Binary{byte(kind), data}, nil
+	case p.hasKeys("$uuid"):
+		str, err := p.stringValue("$uuid")
+		if err != nil {
+			return nil, err
+		}
+
+		uuid, err := parseExtJSONUUID(str)
+		return Binary{0x04, uuid}, err
+	case p.hasKeys("$code"):
+		str, err := p.stringValue("$code")
+		return Javascript{str}, err
+	case p.hasKeys("$code", "$scope"):
+		str, err := p.stringValue("$code")
+		if err != nil {
+			return nil, err
+		}
+
+		scope, err := p.objectValue("$scope")
+		if err != nil {
+			return nil, err
+		}
+
+		document, err := scope.document()
+		return JavascriptWScope{str, document}, err
+	case p.hasKeys("$timestamp"):
+		timestamp, err := p.objectValue("$timestamp")
+		if err != nil {
+			return nil, err
+		}
+
+		if !timestamp.hasKeys("t", "i") {
+			return nil, errors.New("$timestamp must have the t and i fields")
+		}
+
+		t, err := timestamp.uint32Value("t")
+		if err != nil {
+			return nil, err
+		}
+
+		i, err := timestamp.uint32Value("i")
+		if err != nil {
+			return nil, err
+		}
+
+		return Timestamp{int64(uint64(t)<<32 | uint64(i))}, nil
+	case p.hasKeys("$regularExpression"):
+		regexp, err := p.objectValue("$regularExpression")
+		if err != nil {
+			return nil, err
+		}
+
+		if !regexp.hasKeys("pattern", "options") {
+			return nil, errors.New("$regularExpression must have the pattern and options fields")
+		}
+
+		pattern, err := regexp.stringValue("pattern")
+		if err != nil {
+			return nil, err
+		}
+
+		options, err := regexp.stringValue("options")
+		if err != nil {
+			return nil, err
+		}
+
+		if strings.IndexByte(pattern, 0) != -1 || strings.IndexByte(options, 0) != -1 {
+			return nil, errors.New("$regularExpression can not contain null bytes")
+		}
+
+		return RegExp{pattern, sortRegExpOptions(options)}, nil
+	case p.hasKeys("$dbPointer"):
+		pointer, err := p.objectValue("$dbPointer")
+		if err != nil {
+			return nil, err
+		}
+
+		if !pointer.hasKeys("$ref", "$id") {
+			return nil, errors.New("$dbPointer must have the $ref and $id fields")
+		}
+
+		namespace, err := pointer.stringValue("$ref")
+		if err != nil {
+			return nil, err
+		}
+
+		value, _ := pointer.get("$id")
+		id, err := extJSONToValue(value)
+		if err != nil {
+			return nil, err
+		}
+
+		objectId, ok := id.(ObjectId)
+		if !ok {
+			return nil, errors.New("$dbPointer $id must be an objectid")
+		}
+
+		return DBPointer{namespace, objectId}, nil
+	case p.hasKeys("$date"):
+		value, _ := p.get("$date")
+		switch date := value.(type) {
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, date)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%v is not a valid ISO-8601 date", date))
+			}
+
+			return Date{t.Unix()*1000 + int64(t.Nanosecond()/1e6)}, nil
+		case *extJSONObject:
+			milliseconds, err := date.bsonValue()
+			if err != nil {
+				return nil, err
+			}
+
+			if i, ok := milliseconds.(int64); ok && date.hasKeys("$numberLong") {
+				return Date{i}, nil
+			}
+		}
+
+		return nil, errors.New("$date must be a string or $numberLong")
+	case p.hasKeys("$minKey"):
+		if value, _ := p.get("$minKey"); value != json.Number("1") {
+			return nil, errors.New("$minKey must be 1")
+		}
+
+		return Min{}, nil
+	case p.hasKeys("$maxKey"):
+		if value, _ := p.get("$maxKey"); value != json.Number("1") {
+			return nil, errors.New("$maxKey must be 1")
+		}
+
+		return Max{}, nil
+	case p.hasKeys("$undefined"):
+		if value, _ := p.get("$undefined"); value != true {
+			return nil, errors.New("$undefined must be true")
+		}
+
+		return Undefined{}, nil
+	}
+
+	return nil, errors.New(fmt.Sprintf("invalid extended json object with keys %v", p.keys))
+}
@@ -0,0 +1,163 @@
+package mongo
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tokens of a JSON string, used to compare extended json ignoring
+// whitespace and escaping while keeping the order of keys
+func jsonTokens(t *testing.T, str string) []interface{} {
+	decoder := json.NewDecoder(strings.NewReader(str))
+	decoder.UseNumber()
+
+	tokens := make([]interface{}, 0)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return tokens
+		} else if err != nil {
+			t.Fatalf("invalid json %s %v", str, err)
+		}
+
+		tokens = append(tokens, token)
+	}
+}
+
+func jsonEqual(t *testing.T, expected string, actual []byte) bool {
+	return reflect.DeepEqual(jsonTokens(t, expected), jsonTokens(t, string(actual)))
+}
+
+func TestExtJSONCorpus(t *testing.T) {
+	parser := NewBSON()
+
+	for file, tests := range loadBSONCorpus(t, "*.json") {
+		for _, test := range tests.Valid {
+			name := file + " " + test.Description
+			canonicalBson := decodeHex(t, test.CanonicalBson)
+
+			// BSON to canonical extended json
+			b, err := parser.MarshalExtJSON(RawDocument(canonicalBson), true)
+			if err != nil || !jsonEqual(t, test.CanonicalExtJSON, b) {
+				t.Errorf("%s: expected canonical %s got %s %v", name, test.CanonicalExtJSON, b, err)
+			}
+
+			// BSON to relaxed extended json and back
+			if test.RelaxedExtJSON != "" {
+				b, err := parser.MarshalExtJSON(RawDocument(canonicalBson), false)
+				if err != nil || !jsonEqual(t, test.RelaxedExtJSON, b) {
+					t.Errorf("%s: expected relaxed %s got %s %v", name, test.RelaxedExtJSON, b, err)
+				}
+
+				document := NewDocument()
+				err = parser.UnmarshalExtJSON([]byte(test.RelaxedExtJSON), document)
+				if err != nil {
+					t.Errorf("%s: failed to parse relaxed %v", name, err)
+				} else if b, err := parser.MarshalExtJSON(document, false); err != nil || !jsonEqual(t, test.RelaxedExtJSON, b) {
+					t.Errorf("%s: relaxed round trip returned %s %v", name, b, err)
+				}
+			}
+
+			// Degenerate BSON to canonical extended json
+			if test.DegenerateBson != "" {
+				b, err := parser.MarshalExtJSON(RawDocument(decodeHex(t, test.DegenerateBson)), true)
+				if err != nil || !jsonEqual(t, test.CanonicalExtJSON, b) {
+					t.Errorf("%s: expected canonical %s from degenerate bson got %s %v", name, test.CanonicalExtJSON, b, err)
+				}
+			}
+
+			if test.Lossy {
+				continue
+			}
+
+			// Canonical and degenerate extended json to canonical BSON
+			strs := []string{test.CanonicalExtJSON}
+			if test.DegenerateExtJSON != "" {
+				strs = append(strs, test.DegenerateExtJSON)
+			}
+
+			for _, str := range strs {
+				document := NewDocument()
+				err := parser.UnmarshalExtJSON([]byte(str), document)
+				if err != nil {
+					t.Errorf("%s: failed to parse %s %v", name, str, err)
+					continue
+				}
+
+				b, err := parser.Marshall(document, nil, 0)
+				if err != nil || !bytes.Equal(b, canonicalBson) {
+					t.Errorf("%s: parsing %s returned %X %v", name, str, b, err)
+				}
+			}
+		}
+
+		for _, test := range tests.ParseErrors {
+			str := test.String
+			// Decimal128 parse errors are the number strings
+			if tests.BsonType == "0x13" {
+				continue
+			}
+
+			if err := parser.UnmarshalExtJSON([]byte(str), NewDocument()); err == nil {
+				t.Errorf("%s %s: expected an error parsing %s", file, test.Description, str)
+			}
+		}
+	}
+}
+
+func TestExtJSONStruct(t *testing.T) {
+	type Inner struct {
+		Name string `bson:"name"`
+	}
+
+	type T struct {
+		Id     ObjectId  `bson:"_id"`
+		Count  int64     `bson:"count"`
+		Ratio  float64   `bson:"ratio"`
+		Tags   []string  `bson:"tags"`
+		Inner  *Inner    `bson:"inner"`
+		Create time.Time `bson:"create"`
+	}
+
+	value := &T{
+		Id:     ObjectId{testObjectId},
+		Count:  10,
+		Ratio:  0.5,
+		Tags:   []string{"a", "b"},
+		Inner:  &Inner{"inner"},
+		Create: time.Date(2015, 2, 5, 10, 30, 0, 123000000, time.UTC),
+	}
+
+	parser := NewBSON()
+	relaxed := `{"_id":{"$oid":"54d38e1f5d3a1c2b11223344"},"count":10,"ratio":0.5,"tags":["a","b"],"inner":{"name":"inner"},"create":{"$date":"2015-02-05T10:30:00.123Z"}}`
+	canonical := `{"_id":{"$oid":"54d38e1f5d3a1c2b11223344"},"count":{"$numberLong":"10"},"ratio":{"$numberDouble":"0.5"},"tags":["a","b"],"inner":{"name":"inner"},"create":{"$date":{"$numberLong":"1423132200123"}}}`
+
+	if b, err := parser.MarshalExtJSON(value, false); err != nil || string(b) != relaxed {
+		t.Errorf("unexpected relaxed extended json %s %v", b, err)
+	}
+
+	if b, err := parser.MarshalExtJSON(value, true); err != nil || string(b) != canonical {
+		t.Errorf("unexpected canonical extended json %s %v", b, err)
+	}
+
+	// Relaxed numbers that fit in an int32 are decoded into the int64 field
+	for _, str := range []string{relaxed, canonical} {
+		result := &T{}
+		err := parser.UnmarshalExtJSON([]byte(str), result)
+		if err != nil || !reflect.DeepEqual(result, value) {
+			t.Errorf("unexpected struct %+v %v", result, err)
+		}
+	}
+
+	// Only objects can be decoded
+	for _, str := range []string{`[]`, `1`, `{"a":1} {}`, `{"a":`} {
+		if err := parser.UnmarshalExtJSON([]byte(str), &T{}); err == nil {
+			t.Errorf("expected an error parsing %s", str)
+		}
+	}
+}
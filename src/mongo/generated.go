@@ -0,0 +1,315 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+// Helpers used by the AppendBSON and UnmarshalBSON methods generated by
+// bsongen, they follow the type mapping of the reflection based encoder
+// and decoder
+
+// Start a document, start is len(dst) before the call
+func AppendDocumentStart(dst []byte) []byte {
+	return append(dst, 0, 0, 0, 0)
+}
+
+// Terminate the document started at start and write its size
+func AppendDocumentEnd(dst []byte, start int) []byte {
+	dst = append(dst, 0)
+	writeU32(dst, start, uint32(len(dst)-start))
+	return dst
+}
+
+func appendElementName(dst []byte, kind bsonType, key string) []byte {
+	dst = append(dst, byte(kind))
+	dst = append(dst, key...)
+	return append(dst, 0)
+}
+
+func appendInt32(dst []byte, value int32) []byte {
+	return append(dst, byte(value), byte(value>>8), byte(value>>16), byte(value>>24))
+}
+
+func appendInt64(dst []byte, value int64) []byte {
+	return append(dst, byte(value), byte(value>>8), byte(value>>16), byte(value>>24),
+		byte(value>>32), byte(value>>40), byte(value>>48), byte(value>>56))
+}
+
+func AppendStringElement(dst []byte, key string, value string) []byte {
+	dst = appendElementName(dst, bsonString, key)
+	dst = appendInt32(dst, int32(len(value)+1))
+	dst = append(dst, value...)
+	return append(dst, 0)
+}
+
+func AppendBooleanElement(dst []byte, key string, value bool) []byte {
+	dst = appendElementName(dst, bsonBoolean, key)
+	if value {
+		return append(dst, 1)
+	}
+
+	return append(dst, 0)
+}
+
+func AppendDoubleElement(dst []byte, key string, value float64) []byte {
+	dst = appendElementName(dst, bsonDouble, key)
+	return appendInt64(dst, int64(math.Float64bits(value)))
+}
+
+func AppendInt32Element(dst []byte, key string, value int32) []byte {
+	dst = appendElementName(dst, bsonInt32, key)
+	return appendInt32(dst, value)
+}
+
+func AppendInt64Element(dst []byte, key string, value int64) []byte {
+	dst = appendElementName(dst, bsonInt64, key)
+	return appendInt64(dst, value)
+}
+
+// Append an int as an int32 when it fits like the encoder does for int
+// fields and minsize int64 fields
+func AppendIntElement(dst []byte, key string, value int64) []byte {
+	if value >= math.MinInt32 && value <= math.MaxInt32 {
+		return AppendInt32Element(dst, key, int32(value))
+	}
+
+	return AppendInt64Element(dst, key, value)
+}
+
+func AppendDateTimeElement(dst []byte, key string, value time.Time) []byte {
+	dst = appendElementName(dst, bsonDateTime, key)
+	return appendInt64(dst, timeToMilliseconds(value))
+}
+
+func AppendObjectIdElement(dst []byte, key string, value ObjectId) ([]byte, error) {
+	if !value.IsValid() {
+		return dst, errors.New(fmt.Sprintf("field %v objectid must be 12 bytes got %v", key, len(value.Id)))
+	}
+
+	dst = appendElementName(dst, bsonObjectId, key)
+	return append(dst, value.Id...), nil
+}
+
+func AppendBinaryElement(dst []byte, key string, subType byte, data []byte) []byte {
+	dst = appendElementName(dst, bsonBinary, key)
+
+	// The old binary subtype wraps the data in a second length
+	if subType == 0x02 {
+		dst = appendInt32(dst, int32(len(data)+4))
+		dst = append(dst, subType)
+		dst = appendInt32(dst, int32(len(data)))
+	} else {
+		dst = appendInt32(dst, int32(len(data)))
+		dst = append(dst, subType)
+	}
+
+	return append(dst, data...)
+}
+
+func AppendNullElement(dst []byte, key string) []byte {
+	return appendElementName(dst, bsonNull, key)
+}
+
+// Append an embedded document encoded by the value itself
+func AppendDocumentElement(dst []byte, key string, value Appender) ([]byte, error) {
+	return value.AppendBSON(appendElementName(dst, bsonDocument, key))
+}
+
+// Start an array element, the array is terminated with AppendDocumentEnd
+// passing the returned start
+func AppendArrayElementStart(dst []byte, key string) ([]byte, int) {
+	dst = appendElementName(dst, bsonArray, key)
+	return AppendDocumentStart(dst), len(dst)
+}
+
+// The key of the array element at index
+func ArrayIndexKey(index int) string {
+	return itoa(index)
+}
+
+// Append any value using the reflection based encoder
+func AppendValueElement(dst []byte, key string, value interface{}) ([]byte, error) {
+	return appendValueElement(dst, key, value, false)
+}
+
+// Append any value of a minsize field using the reflection based encoder,
+// integers that fit are stored as int32
+func AppendMinSizeValueElement(dst []byte, key string, value interface{}) ([]byte, error) {
+	return appendValueElement(dst, key, value, true)
+}
+
+func appendValueElement(dst []byte, key string, value interface{}, minSize bool) ([]byte, error) {
+	encoder := defaultBSON.newEncoder(dst[:cap(dst)], len(dst), false)
+	err := encoder.packValue(key, reflect.ValueOf(value), minSize)
+	if err != nil {
+		return dst, err
+	}
+
+	return encoder.out[:encoder.index], nil
+}
+
+// Is anything registered in DefaultRegistry, generated code only checks
+// the types of its fields when there is
+func HasRegisteredCodecs() bool {
+	return DefaultRegistry.load() != nil
+}
+
+// Is a codec registered in DefaultRegistry for the type of the typed nil
+// pointer, generated code then encodes and decodes the field like the
+// reflection based encoder and decoder do so the codec is used
+func Registered(pointer interface{}) bool {
+	return DefaultRegistry.load().registered(reflect.TypeOf(pointer).Elem())
+}
+
+// Is the value empty for the purpose of omitempty
+func IsEmpty(value interface{}) bool {
+	return isEmpty(reflect.ValueOf(value))
+}
+
+// Add the field name to a decoding error, the path of a *DecodeError
+// returned by an embedded document is prefixed with it
+func FieldError(fieldName string, err error) error {
+	if _, ok := err.(*DecodeError); ok {
+		return prefixDecodeError(fieldName, err)
+	}
+
+	return &DecodeError{fieldName, err.Error()}
+}
+
+func DecodeString(value Raw) (string, error) {
+	if value.IsNull() {
+		return "", nil
+	}
+
+	return value.StringValue()
+}
+
+func DecodeBool(value Raw) (bool, error) {
+	if value.IsNull() {
+		return false, nil
+	}
+
+	return value.Bool()
+}
+
+// Decode any number, integers must be represented exactly by a double
+func DecodeFloat64(value Raw) (float64, error) {
+	if value.IsNull() {
+		return 0, nil
+	}
+
+	number, err := value.number()
+	if err != nil {
+		return 0, err
+	}
+
+	return numberToFloat64(number)
+}
+
+// Decode any number that fits an int32, doubles must be integral
+func DecodeInt32(value Raw) (int32, error) {
+	i, err := DecodeInt64(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if i < math.MinInt32 || i > math.MaxInt32 {
+		return 0, errors.New(fmt.Sprintf("value %v overflows int32", i))
+	}
+
+	return int32(i), nil
+}
+
+// Decode any number that fits an int, doubles must be integral
+func DecodeInt(value Raw) (int, error) {
+	i, err := DecodeInt64(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if int64(int(i)) != i {
+		return 0, errors.New(fmt.Sprintf("value %v overflows int", i))
+	}
+
+	return int(i), nil
+}
+
+// Decode any number, doubles must be integral
+func DecodeInt64(value Raw) (int64, error) {
+	if value.IsNull() {
+		return 0, nil
+	}
+
+	return value.AsInt64()
+}
+
+func DecodeTime(value Raw) (time.Time, error) {
+	if value.IsNull() {
+		return time.Time{}, nil
+	}
+
+	return value.Time()
+}
+
+func DecodeObjectId(value Raw) (ObjectId, error) {
+	if value.IsNull() {
+		return ObjectId{}, nil
+	}
+
+	return value.ObjectId()
+}
+
+// Decode binary data into a copy
+func DecodeBytes(value Raw) ([]byte, error) {
+	if value.IsNull() {
+		return nil, nil
+	}
+
+	_, data, err := value.Binary()
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{}, data...), nil
+}
+
+// Decode an embedded document into a value decoding itself, null leaves
+// the value untouched
+func DecodeDocument(value Raw, target Unmarshaler) error {
+	if value.IsNull() {
+		return nil
+	}
+
+	document, err := value.Document()
+	if err != nil {
+		return err
+	}
+
+	return target.UnmarshalBSON(document)
+}
+
+// Call decode for each value of an array, null has no values
+func DecodeArray(value Raw, decode func(element Raw) error) error {
+	if value.IsNull() {
+		return nil
+	}
+
+	array, err := value.Array()
+	if err != nil {
+		return err
+	}
+
+	iterator := array.Iterator()
+	for iterator.Next() {
+		err := decode(iterator.Element().Value())
+		if err != nil {
+			return FieldError(iterator.Element().Key(), err)
+		}
+	}
+
+	return iterator.Err()
+}
@@ -0,0 +1,253 @@
+package mongo
+
+import (
+	"bytes"
+	"gopkg.in/mgo.v2/bson"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The generated test documents without the generated methods, encoded
+// and decoded with reflection
+type reflectTestT1 struct {
+	Int int32 `bson:"int,omitempty"`
+}
+
+type reflectTestItem struct {
+	Name  string   `bson:"name"`
+	Count int64    `bson:"count"`
+	Price float64  `bson:"price"`
+	Tags  []string `bson:"tags"`
+}
+
+type reflectTestDocument struct {
+	Id         ObjectId               `bson:"_id"`
+	Name       string                 `bson:"name"`
+	Active     bool                   `bson:"active"`
+	Ratio      float64                `bson:"ratio"`
+	Small      float32                `bson:"small"`
+	Count      int                    `bson:"count"`
+	Int8       int8                   `bson:"int8"`
+	Int32      int32                  `bson:"int32"`
+	Int64      int64                  `bson:"int64"`
+	MinSize    int64                  `bson:"minsize,minsize"`
+	MinSizePtr *int64                 `bson:"minsizeptr,minsize"`
+	Created    time.Time              `bson:"created"`
+	Data       []byte                 `bson:"data"`
+	Inner      reflectTestT1          `bson:"inner"`
+	Pointer    *reflectTestT1         `bson:"pointer"`
+	Items      []reflectTestItem      `bson:"items"`
+	Counts     []int                  `bson:"counts"`
+	Value      *string                `bson:"value"`
+	Map        map[string]interface{} `bson:"map"`
+	Any        interface{}            `bson:"any"`
+	Omitted    string                 `bson:"omitted,omitempty"`
+	OmitMap    map[string]int         `bson:"omitmap,omitempty"`
+	Untagged   string
+	Ignored    string `bson:"-"`
+	unexported string
+}
+
+type reflectRegisteredDocument struct {
+	Inner   generatedRegisteredInner   `bson:"inner"`
+	Pointer *generatedRegisteredInner  `bson:"pointer"`
+	Items   []generatedRegisteredInner `bson:"items"`
+	Plain   generatedBenchmarkT1       `bson:"plain"`
+}
+
+func newGeneratedTestDocuments() (*generatedTestDocument, *reflectTestDocument) {
+	value := "value"
+	minSize := int64(12)
+	created := millisecondsToTime(1423132200123)
+
+	generated := &generatedTestDocument{
+		Id: ObjectId{testObjectId}, Name: "name", Active: true, Ratio: 0.5, Small: 1.5,
+		Count: 1 << 40, Int8: -8, Int32: 32, Int64: 64, MinSize: 10, MinSizePtr: &minSize, Created: created,
+		Data: []byte{1, 2, 3}, Inner: generatedBenchmarkT1{1}, Pointer: &generatedBenchmarkT1{2},
+		Items:  []generatedBenchmarkItem{{"a", 1, 1.5, []string{"x"}}, {"b", 2, 2.5, []string{}}},
+		Counts: []int{1, 1 << 40}, Value: &value, Map: map[string]interface{}{"a": "b"},
+		Any: "any", Untagged: "untagged", Ignored: "ignored",
+	}
+
+	reflected := &reflectTestDocument{
+		Id: ObjectId{testObjectId}, Name: "name", Active: true, Ratio: 0.5, Small: 1.5,
+		Count: 1 << 40, Int8: -8, Int32: 32, Int64: 64, MinSize: 10, MinSizePtr: &minSize, Created: created,
+		Data: []byte{1, 2, 3}, Inner: reflectTestT1{1}, Pointer: &reflectTestT1{2},
+		Items:  []reflectTestItem{{"a", 1, 1.5, []string{"x"}}, {"b", 2, 2.5, []string{}}},
+		Counts: []int{1, 1 << 40}, Value: &value, Map: map[string]interface{}{"a": "b"},
+		Any: "any", Untagged: "untagged", Ignored: "ignored",
+	}
+
+	return generated, reflected
+}
+
+func TestGeneratedMatchesReflection(t *testing.T) {
+	parser := NewBSON()
+	generated, reflected := newGeneratedTestDocuments()
+
+	expected, err := parser.Marshall(reflected, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to marshal with reflection %v", err)
+	}
+
+	b, err := generated.MarshalBSON()
+	if err != nil || !bytes.Equal(b, expected) {
+		t.Fatalf("generated encoding differs from reflection\n%X\n%X %v", b, expected, err)
+	}
+
+	// The encoder uses the generated methods
+	if b, err := parser.Marshall(generated, nil, 0); err != nil || !bytes.Equal(b, expected) {
+		t.Errorf("encoder did not use AppendBSON %X %v", b, err)
+	}
+
+	if size, err := parser.Size(generated); err != nil || size != len(expected) {
+		t.Errorf("unexpected size %v %v", size, err)
+	}
+
+	// AppendBSON appends after the existing bytes
+	if b, err := parser.MarshalAppend([]byte{1, 2}, generated); err != nil || !bytes.Equal(b[2:], expected) || b[0] != 1 {
+		t.Errorf("unexpected appended document %X %v", b, err)
+	}
+
+	// Decoding gives the same values as reflection
+	result := &generatedTestDocument{}
+	if err := parser.Unmarshal(expected, result); err != nil {
+		t.Fatalf("failed to unmarshal %v", err)
+	}
+
+	generated.Ignored = ""
+	if !reflect.DeepEqual(result, generated) {
+		t.Errorf("unexpected decoded document\n%+v\n%+v", result, generated)
+	}
+
+	// Zero values and nil pointers
+	expected, err = parser.Marshall(&reflectTestDocument{Id: ObjectId{testObjectId}}, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to marshal with reflection %v", err)
+	}
+
+	if b, err := (&generatedTestDocument{Id: ObjectId{testObjectId}}).MarshalBSON(); err != nil || !bytes.Equal(b, expected) {
+		t.Errorf("generated encoding of the zero value differs from reflection\n%X\n%X %v", b, expected, err)
+	}
+
+	// Encoding errors leave dst unchanged
+	if b, err := (&generatedTestDocument{}).AppendBSON([]byte{1}); err == nil || len(b) != 1 {
+		t.Errorf("expected an invalid ObjectId error got %X %v", b, err)
+	}
+}
+
+func TestGeneratedMatchesMGO(t *testing.T) {
+	doc := newGeneratedBenchmarkDocument()
+	expected, err := bson.Marshal(newLargeBenchmarkDocument())
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	if b, err := doc.MarshalBSON(); err != nil || !bytes.Equal(b, expected) {
+		t.Errorf("generated encoding differs from mgo\n%X\n%X %v", b, expected, err)
+	}
+
+	result := &generatedBenchmarkDocument{}
+	if err := result.UnmarshalBSON(expected); err != nil || !reflect.DeepEqual(result, doc) {
+		t.Errorf("unexpected decoded document %+v %v", result, err)
+	}
+}
+
+func TestGeneratedDecoding(t *testing.T) {
+	// Null values and Go field names
+	b, _ := bson.Marshal(bson.D{
+		{Name: "String", Value: "hello"},
+		{Name: "doc", Value: nil},
+		{Name: "unknown", Value: 1},
+	})
+
+	result := &generatedBenchmarkT2{Doc: &generatedBenchmarkT1{1}}
+	if err := result.UnmarshalBSON(b); err != nil || result.String != "hello" || result.Doc != nil {
+		t.Errorf("unexpected decoded document %+v %v", result, err)
+	}
+
+	// Type mismatches name the field
+	b, _ = bson.Marshal(bson.M{"doc": bson.M{"int": "a"}})
+	err := NewBSON().Unmarshal(b, &generatedBenchmarkT2{})
+	if err == nil || !strings.Contains(err.Error(), "field doc.int:") {
+		t.Errorf("expected an error naming the field got %v", err)
+	}
+
+	// Corrupt documents
+	if err := result.UnmarshalBSON([]byte{10, 0, 0, 0, 0x10, 'a', 0, 1, 0, 0}); err == nil {
+		t.Errorf("expected an error decoding a corrupt document")
+	}
+}
+
+func TestGeneratedUsesRegistry(t *testing.T) {
+	// The registered inner struct is encoded as its string value
+	innerType := reflect.TypeOf(generatedRegisteredInner{})
+	DefaultRegistry.RegisterEncoder(innerType, func(value reflect.Value) (Raw, error) {
+		return stringRaw(value.Interface().(generatedRegisteredInner).Value), nil
+	})
+
+	DefaultRegistry.RegisterDecoder(innerType, func(raw Raw, value reflect.Value) error {
+		if raw.IsNull() {
+			value.Set(reflect.Zero(innerType))
+			return nil
+		}
+
+		str, err := raw.StringValue()
+		value.Set(reflect.ValueOf(generatedRegisteredInner{str}))
+		return err
+	})
+
+	parser := NewBSON()
+	expected, err := parser.Marshall(&reflectRegisteredDocument{
+		generatedRegisteredInner{"a"}, &generatedRegisteredInner{"b"},
+		[]generatedRegisteredInner{{"c"}}, generatedBenchmarkT1{1},
+	}, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to marshal with reflection %v", err)
+	}
+
+	if value, err := RawDocument(expected).Lookup("inner"); err != nil || value.Kind != byte(bsonString) {
+		t.Fatalf("expected the registered encoder to be used %v %v", value, err)
+	}
+
+	generated := &generatedRegisteredDocument{
+		generatedRegisteredInner{"a"}, &generatedRegisteredInner{"b"},
+		[]generatedRegisteredInner{{"c"}}, generatedBenchmarkT1{1},
+	}
+
+	if b, err := generated.MarshalBSON(); err != nil || !bytes.Equal(b, expected) {
+		t.Errorf("generated encoding differs from reflection\n%X\n%X %v", b, expected, err)
+	}
+
+	// The same documents decode to the same values, null resets the fields
+	null, _ := bson.Marshal(bson.D{
+		{Name: "inner", Value: nil},
+		{Name: "pointer", Value: nil},
+		{Name: "items", Value: []interface{}{nil, "d"}},
+		{Name: "plain", Value: nil},
+	})
+
+	for _, b := range [][]byte{expected, null} {
+		result := &generatedRegisteredDocument{
+			generatedRegisteredInner{"x"}, &generatedRegisteredInner{"y"}, nil, generatedBenchmarkT1{2},
+		}
+
+		reflected := &reflectRegisteredDocument{
+			generatedRegisteredInner{"x"}, &generatedRegisteredInner{"y"}, nil, generatedBenchmarkT1{2},
+		}
+
+		if err := result.UnmarshalBSON(b); err != nil {
+			t.Fatalf("failed to decode with the generated decoder %v", err)
+		}
+
+		if err := parser.Unmarshal(b, reflected); err != nil {
+			t.Fatalf("failed to decode with reflection %v", err)
+		}
+
+		if !reflect.DeepEqual(result, (*generatedRegisteredDocument)(reflected)) {
+			t.Errorf("generated decoding differs from reflection\n%+v\n%+v", result, reflected)
+		}
+	}
+}
@@ -0,0 +1,1001 @@
+// Code generated by bsongen -type generatedBenchmarkT1,generatedBenchmarkT2,generatedBenchmarkItem,generatedBenchmarkDocument,generatedTestDocument,generatedRegisteredInner,generatedRegisteredDocument; DO NOT EDIT.
+
+package mongo
+
+import (
+	"time"
+)
+
+// Append the BSON document of the struct to dst
+func (p *generatedBenchmarkT1) AppendBSON(dst []byte) ([]byte, error) {
+	var err error
+	registered := HasRegisteredCodecs()
+	start := len(dst)
+	dst = AppendDocumentStart(dst)
+	if p.Int != 0 {
+		if registered && Registered((*int32)(nil)) {
+			dst, err = AppendValueElement(dst, "int", p.Int)
+			if err != nil {
+				return dst[:start], err
+			}
+		} else {
+			dst = AppendInt32Element(dst, "int", p.Int)
+		}
+	}
+	return AppendDocumentEnd(dst, start), nil
+}
+
+func (p *generatedBenchmarkT1) MarshalBSON() ([]byte, error) {
+	return p.AppendBSON(nil)
+}
+
+// Decode a BSON document into the struct
+func (p *generatedBenchmarkT1) UnmarshalBSON(data []byte) error {
+	registered := HasRegisteredCodecs()
+	iterator := RawDocument(data).Iterator()
+	for iterator.Next() {
+		element := iterator.Element()
+		value := element.Value()
+
+		var err error
+		switch string(element.KeyBytes()) {
+		case "int", "Int":
+			if registered && Registered((*int32)(nil)) {
+				err = value.Unmarshal(&p.Int)
+			} else {
+				p.Int, err = DecodeInt32(value)
+			}
+		}
+
+		if err != nil {
+			return FieldError(element.Key(), err)
+		}
+	}
+
+	return iterator.Err()
+}
+
+// Append the BSON document of the struct to dst
+func (p *generatedBenchmarkT2) AppendBSON(dst []byte) ([]byte, error) {
+	var err error
+	registered := HasRegisteredCodecs()
+	start := len(dst)
+	dst = AppendDocumentStart(dst)
+	if p.String != "" {
+		if registered && Registered((*string)(nil)) {
+			dst, err = AppendValueElement(dst, "string", p.String)
+			if err != nil {
+				return dst[:start], err
+			}
+		} else {
+			dst = AppendStringElement(dst, "string", p.String)
+		}
+	}
+	if p.Doc != nil {
+		if registered && Registered((**generatedBenchmarkT1)(nil)) {
+			dst, err = AppendValueElement(dst, "doc", p.Doc)
+			if err != nil {
+				return dst[:start], err
+			}
+		} else {
+			if registered && Registered((*generatedBenchmarkT1)(nil)) {
+				dst, err = AppendValueElement(dst, "doc", *p.Doc)
+				if err != nil {
+					return dst[:start], err
+				}
+			} else {
+				dst, err = AppendDocumentElement(dst, "doc", p.Doc)
+				if err != nil {
+					return dst[:start], err
+				}
+			}
+		}
+	}
+	return AppendDocumentEnd(dst, start), nil
+}
+
+func (p *generatedBenchmarkT2) MarshalBSON() ([]byte, error) {
+	return p.AppendBSON(nil)
+}
+
+// Decode a BSON document into the struct
+func (p *generatedBenchmarkT2) UnmarshalBSON(data []byte) error {
+	registered := HasRegisteredCodecs()
+	iterator := RawDocument(data).Iterator()
+	for iterator.Next() {
+		element := iterator.Element()
+		value := element.Value()
+
+		var err error
+		switch string(element.KeyBytes()) {
+		case "string", "String":
+			if registered && Registered((*string)(nil)) {
+				err = value.Unmarshal(&p.String)
+			} else {
+				p.String, err = DecodeString(value)
+			}
+		case "doc", "Doc":
+			if registered && Registered((**generatedBenchmarkT1)(nil)) {
+				err = value.Unmarshal(&p.Doc)
+			} else {
+				p.Doc = nil
+				if !value.IsNull() {
+					p.Doc = new(generatedBenchmarkT1)
+					if registered && Registered((*generatedBenchmarkT1)(nil)) {
+						err = value.Unmarshal(p.Doc)
+					} else {
+						err = DecodeDocument(value, p.Doc)
+					}
+				}
+			}
+		}
+
+		if err != nil {
+			return FieldError(element.Key(), err)
+		}
+	}
+
+	return iterator.Err()
+}
+
+// Append the BSON document of the struct to dst
+func (p *generatedBenchmarkItem) AppendBSON(dst []byte) ([]byte, error) {
+	var err error
+	registered := HasRegisteredCodecs()
+	start := len(dst)
+	dst = AppendDocumentStart(dst)
+	if registered && Registered((*string)(nil)) {
+		dst, err = AppendValueElement(dst, "name", p.Name)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendStringElement(dst, "name", p.Name)
+	}
+	if registered && Registered((*int64)(nil)) {
+		dst, err = AppendValueElement(dst, "count", p.Count)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendInt64Element(dst, "count", p.Count)
+	}
+	if registered && Registered((*float64)(nil)) {
+		dst, err = AppendValueElement(dst, "price", p.Price)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendDoubleElement(dst, "price", p.Price)
+	}
+	if registered && Registered((*[]string)(nil)) {
+		dst, err = AppendValueElement(dst, "tags", p.Tags)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		{
+			var arrayStart int
+			dst, arrayStart = AppendArrayElementStart(dst, "tags")
+			for i := range p.Tags {
+				if registered && Registered((*string)(nil)) {
+					dst, err = AppendValueElement(dst, ArrayIndexKey(i), p.Tags[i])
+					if err != nil {
+						return dst[:start], err
+					}
+				} else {
+					dst = AppendStringElement(dst, ArrayIndexKey(i), p.Tags[i])
+				}
+			}
+			dst = AppendDocumentEnd(dst, arrayStart)
+		}
+	}
+	return AppendDocumentEnd(dst, start), nil
+}
+
+func (p *generatedBenchmarkItem) MarshalBSON() ([]byte, error) {
+	return p.AppendBSON(nil)
+}
+
+// Decode a BSON document into the struct
+func (p *generatedBenchmarkItem) UnmarshalBSON(data []byte) error {
+	registered := HasRegisteredCodecs()
+	iterator := RawDocument(data).Iterator()
+	for iterator.Next() {
+		element := iterator.Element()
+		value := element.Value()
+
+		var err error
+		switch string(element.KeyBytes()) {
+		case "name", "Name":
+			if registered && Registered((*string)(nil)) {
+				err = value.Unmarshal(&p.Name)
+			} else {
+				p.Name, err = DecodeString(value)
+			}
+		case "count", "Count":
+			if registered && Registered((*int64)(nil)) {
+				err = value.Unmarshal(&p.Count)
+			} else {
+				p.Count, err = DecodeInt64(value)
+			}
+		case "price", "Price":
+			if registered && Registered((*float64)(nil)) {
+				err = value.Unmarshal(&p.Price)
+			} else {
+				p.Price, err = DecodeFloat64(value)
+			}
+		case "tags", "Tags":
+			if registered && Registered((*[]string)(nil)) {
+				err = value.Unmarshal(&p.Tags)
+			} else {
+				p.Tags = nil
+				if !value.IsNull() {
+					p.Tags = make([]string, 0)
+					err = DecodeArray(value, func(value Raw) error {
+						var item string
+						var err error
+						if registered && Registered((*string)(nil)) {
+							decoded := new(string)
+							err = value.Unmarshal(decoded)
+							item = *decoded
+						} else {
+							item, err = DecodeString(value)
+						}
+						if err != nil {
+							return err
+						}
+
+						p.Tags = append(p.Tags, item)
+						return nil
+					})
+				}
+			}
+		}
+
+		if err != nil {
+			return FieldError(element.Key(), err)
+		}
+	}
+
+	return iterator.Err()
+}
+
+// Append the BSON document of the struct to dst
+func (p *generatedBenchmarkDocument) AppendBSON(dst []byte) ([]byte, error) {
+	var err error
+	registered := HasRegisteredCodecs()
+	start := len(dst)
+	dst = AppendDocumentStart(dst)
+	if registered && Registered((*string)(nil)) {
+		dst, err = AppendValueElement(dst, "_id", p.Id)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendStringElement(dst, "_id", p.Id)
+	}
+	if registered && Registered((*[]generatedBenchmarkItem)(nil)) {
+		dst, err = AppendValueElement(dst, "items", p.Items)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		{
+			var arrayStart int
+			dst, arrayStart = AppendArrayElementStart(dst, "items")
+			for i := range p.Items {
+				if registered && Registered((*generatedBenchmarkItem)(nil)) {
+					dst, err = AppendValueElement(dst, ArrayIndexKey(i), p.Items[i])
+					if err != nil {
+						return dst[:start], err
+					}
+				} else {
+					dst, err = AppendDocumentElement(dst, ArrayIndexKey(i), &p.Items[i])
+					if err != nil {
+						return dst[:start], err
+					}
+				}
+			}
+			dst = AppendDocumentEnd(dst, arrayStart)
+		}
+	}
+	return AppendDocumentEnd(dst, start), nil
+}
+
+func (p *generatedBenchmarkDocument) MarshalBSON() ([]byte, error) {
+	return p.AppendBSON(nil)
+}
+
+// Decode a BSON document into the struct
+func (p *generatedBenchmarkDocument) UnmarshalBSON(data []byte) error {
+	registered := HasRegisteredCodecs()
+	iterator := RawDocument(data).Iterator()
+	for iterator.Next() {
+		element := iterator.Element()
+		value := element.Value()
+
+		var err error
+		switch string(element.KeyBytes()) {
+		case "_id", "Id":
+			if registered && Registered((*string)(nil)) {
+				err = value.Unmarshal(&p.Id)
+			} else {
+				p.Id, err = DecodeString(value)
+			}
+		case "items", "Items":
+			if registered && Registered((*[]generatedBenchmarkItem)(nil)) {
+				err = value.Unmarshal(&p.Items)
+			} else {
+				p.Items = nil
+				if !value.IsNull() {
+					p.Items = make([]generatedBenchmarkItem, 0)
+					err = DecodeArray(value, func(value Raw) error {
+						var item generatedBenchmarkItem
+						var err error
+						if registered && Registered((*generatedBenchmarkItem)(nil)) {
+							decoded := new(generatedBenchmarkItem)
+							err = value.Unmarshal(decoded)
+							item = *decoded
+						} else {
+							item = generatedBenchmarkItem{}
+							err = DecodeDocument(value, &item)
+						}
+						if err != nil {
+							return err
+						}
+
+						p.Items = append(p.Items, item)
+						return nil
+					})
+				}
+			}
+		}
+
+		if err != nil {
+			return FieldError(element.Key(), err)
+		}
+	}
+
+	return iterator.Err()
+}
+
+// Append the BSON document of the struct to dst
+func (p *generatedTestDocument) AppendBSON(dst []byte) ([]byte, error) {
+	var err error
+	registered := HasRegisteredCodecs()
+	start := len(dst)
+	dst = AppendDocumentStart(dst)
+	if registered && Registered((*ObjectId)(nil)) {
+		dst, err = AppendValueElement(dst, "_id", p.Id)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst, err = AppendObjectIdElement(dst, "_id", p.Id)
+		if err != nil {
+			return dst[:start], err
+		}
+	}
+	if registered && Registered((*string)(nil)) {
+		dst, err = AppendValueElement(dst, "name", p.Name)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendStringElement(dst, "name", p.Name)
+	}
+	if registered && Registered((*bool)(nil)) {
+		dst, err = AppendValueElement(dst, "active", p.Active)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendBooleanElement(dst, "active", p.Active)
+	}
+	if registered && Registered((*float64)(nil)) {
+		dst, err = AppendValueElement(dst, "ratio", p.Ratio)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendDoubleElement(dst, "ratio", p.Ratio)
+	}
+	if registered && Registered((*float32)(nil)) {
+		dst, err = AppendValueElement(dst, "small", p.Small)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendDoubleElement(dst, "small", float64(p.Small))
+	}
+	if registered && Registered((*int)(nil)) {
+		dst, err = AppendValueElement(dst, "count", p.Count)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendIntElement(dst, "count", int64(p.Count))
+	}
+	if registered && Registered((*int8)(nil)) {
+		dst, err = AppendValueElement(dst, "int8", p.Int8)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendInt32Element(dst, "int8", int32(p.Int8))
+	}
+	if registered && Registered((*int32)(nil)) {
+		dst, err = AppendValueElement(dst, "int32", p.Int32)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendInt32Element(dst, "int32", p.Int32)
+	}
+	if registered && Registered((*int64)(nil)) {
+		dst, err = AppendValueElement(dst, "int64", p.Int64)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendInt64Element(dst, "int64", p.Int64)
+	}
+	if registered && Registered((*int64)(nil)) {
+		dst, err = AppendValueElement(dst, "minsize", p.MinSize)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendIntElement(dst, "minsize", p.MinSize)
+	}
+	dst, err = AppendMinSizeValueElement(dst, "minsizeptr", p.MinSizePtr)
+	if err != nil {
+		return dst[:start], err
+	}
+	if registered && Registered((*time.Time)(nil)) {
+		dst, err = AppendValueElement(dst, "created", p.Created)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendDateTimeElement(dst, "created", p.Created)
+	}
+	if registered && Registered((*[]byte)(nil)) {
+		dst, err = AppendValueElement(dst, "data", p.Data)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendBinaryElement(dst, "data", 0x00, p.Data)
+	}
+	if registered && Registered((*generatedBenchmarkT1)(nil)) {
+		dst, err = AppendValueElement(dst, "inner", p.Inner)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst, err = AppendDocumentElement(dst, "inner", &p.Inner)
+		if err != nil {
+			return dst[:start], err
+		}
+	}
+	if registered && Registered((**generatedBenchmarkT1)(nil)) {
+		dst, err = AppendValueElement(dst, "pointer", p.Pointer)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		if p.Pointer == nil {
+			dst = AppendNullElement(dst, "pointer")
+		} else {
+			if registered && Registered((*generatedBenchmarkT1)(nil)) {
+				dst, err = AppendValueElement(dst, "pointer", *p.Pointer)
+				if err != nil {
+					return dst[:start], err
+				}
+			} else {
+				dst, err = AppendDocumentElement(dst, "pointer", p.Pointer)
+				if err != nil {
+					return dst[:start], err
+				}
+			}
+		}
+	}
+	if registered && Registered((*[]generatedBenchmarkItem)(nil)) {
+		dst, err = AppendValueElement(dst, "items", p.Items)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		{
+			var arrayStart int
+			dst, arrayStart = AppendArrayElementStart(dst, "items")
+			for i := range p.Items {
+				if registered && Registered((*generatedBenchmarkItem)(nil)) {
+					dst, err = AppendValueElement(dst, ArrayIndexKey(i), p.Items[i])
+					if err != nil {
+						return dst[:start], err
+					}
+				} else {
+					dst, err = AppendDocumentElement(dst, ArrayIndexKey(i), &p.Items[i])
+					if err != nil {
+						return dst[:start], err
+					}
+				}
+			}
+			dst = AppendDocumentEnd(dst, arrayStart)
+		}
+	}
+	if registered && Registered((*[]int)(nil)) {
+		dst, err = AppendValueElement(dst, "counts", p.Counts)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		{
+			var arrayStart int
+			dst, arrayStart = AppendArrayElementStart(dst, "counts")
+			for i := range p.Counts {
+				if registered && Registered((*int)(nil)) {
+					dst, err = AppendValueElement(dst, ArrayIndexKey(i), p.Counts[i])
+					if err != nil {
+						return dst[:start], err
+					}
+				} else {
+					dst = AppendIntElement(dst, ArrayIndexKey(i), int64(p.Counts[i]))
+				}
+			}
+			dst = AppendDocumentEnd(dst, arrayStart)
+		}
+	}
+	dst, err = AppendValueElement(dst, "value", p.Value)
+	if err != nil {
+		return dst[:start], err
+	}
+	dst, err = AppendValueElement(dst, "map", p.Map)
+	if err != nil {
+		return dst[:start], err
+	}
+	dst, err = AppendValueElement(dst, "any", p.Any)
+	if err != nil {
+		return dst[:start], err
+	}
+	if p.Omitted != "" {
+		if registered && Registered((*string)(nil)) {
+			dst, err = AppendValueElement(dst, "omitted", p.Omitted)
+			if err != nil {
+				return dst[:start], err
+			}
+		} else {
+			dst = AppendStringElement(dst, "omitted", p.Omitted)
+		}
+	}
+	if !IsEmpty(p.OmitMap) {
+		dst, err = AppendValueElement(dst, "omitmap", p.OmitMap)
+		if err != nil {
+			return dst[:start], err
+		}
+	}
+	if registered && Registered((*string)(nil)) {
+		dst, err = AppendValueElement(dst, "Untagged", p.Untagged)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendStringElement(dst, "Untagged", p.Untagged)
+	}
+	return AppendDocumentEnd(dst, start), nil
+}
+
+func (p *generatedTestDocument) MarshalBSON() ([]byte, error) {
+	return p.AppendBSON(nil)
+}
+
+// Decode a BSON document into the struct
+func (p *generatedTestDocument) UnmarshalBSON(data []byte) error {
+	registered := HasRegisteredCodecs()
+	iterator := RawDocument(data).Iterator()
+	for iterator.Next() {
+		element := iterator.Element()
+		value := element.Value()
+
+		var err error
+		switch string(element.KeyBytes()) {
+		case "_id", "Id":
+			if registered && Registered((*ObjectId)(nil)) {
+				err = value.Unmarshal(&p.Id)
+			} else {
+				p.Id, err = DecodeObjectId(value)
+			}
+		case "name", "Name":
+			if registered && Registered((*string)(nil)) {
+				err = value.Unmarshal(&p.Name)
+			} else {
+				p.Name, err = DecodeString(value)
+			}
+		case "active", "Active":
+			if registered && Registered((*bool)(nil)) {
+				err = value.Unmarshal(&p.Active)
+			} else {
+				p.Active, err = DecodeBool(value)
+			}
+		case "ratio", "Ratio":
+			if registered && Registered((*float64)(nil)) {
+				err = value.Unmarshal(&p.Ratio)
+			} else {
+				p.Ratio, err = DecodeFloat64(value)
+			}
+		case "small", "Small":
+			err = value.Unmarshal(&p.Small)
+		case "count", "Count":
+			if registered && Registered((*int)(nil)) {
+				err = value.Unmarshal(&p.Count)
+			} else {
+				p.Count, err = DecodeInt(value)
+			}
+		case "int8", "Int8":
+			err = value.Unmarshal(&p.Int8)
+		case "int32", "Int32":
+			if registered && Registered((*int32)(nil)) {
+				err = value.Unmarshal(&p.Int32)
+			} else {
+				p.Int32, err = DecodeInt32(value)
+			}
+		case "int64", "Int64":
+			if registered && Registered((*int64)(nil)) {
+				err = value.Unmarshal(&p.Int64)
+			} else {
+				p.Int64, err = DecodeInt64(value)
+			}
+		case "minsize", "MinSize":
+			if registered && Registered((*int64)(nil)) {
+				err = value.Unmarshal(&p.MinSize)
+			} else {
+				p.MinSize, err = DecodeInt64(value)
+			}
+		case "minsizeptr", "MinSizePtr":
+			if registered && Registered((**int64)(nil)) {
+				err = value.Unmarshal(&p.MinSizePtr)
+			} else {
+				p.MinSizePtr = nil
+				if !value.IsNull() {
+					p.MinSizePtr = new(int64)
+					if registered && Registered((*int64)(nil)) {
+						err = value.Unmarshal(p.MinSizePtr)
+					} else {
+						(*p.MinSizePtr), err = DecodeInt64(value)
+					}
+				}
+			}
+		case "created", "Created":
+			if registered && Registered((*time.Time)(nil)) {
+				err = value.Unmarshal(&p.Created)
+			} else {
+				p.Created, err = DecodeTime(value)
+			}
+		case "data", "Data":
+			if registered && Registered((*[]byte)(nil)) {
+				err = value.Unmarshal(&p.Data)
+			} else {
+				p.Data, err = DecodeBytes(value)
+			}
+		case "inner", "Inner":
+			if registered && Registered((*generatedBenchmarkT1)(nil)) {
+				err = value.Unmarshal(&p.Inner)
+			} else {
+				p.Inner = generatedBenchmarkT1{}
+				err = DecodeDocument(value, &p.Inner)
+			}
+		case "pointer", "Pointer":
+			if registered && Registered((**generatedBenchmarkT1)(nil)) {
+				err = value.Unmarshal(&p.Pointer)
+			} else {
+				p.Pointer = nil
+				if !value.IsNull() {
+					p.Pointer = new(generatedBenchmarkT1)
+					if registered && Registered((*generatedBenchmarkT1)(nil)) {
+						err = value.Unmarshal(p.Pointer)
+					} else {
+						err = DecodeDocument(value, p.Pointer)
+					}
+				}
+			}
+		case "items", "Items":
+			if registered && Registered((*[]generatedBenchmarkItem)(nil)) {
+				err = value.Unmarshal(&p.Items)
+			} else {
+				p.Items = nil
+				if !value.IsNull() {
+					p.Items = make([]generatedBenchmarkItem, 0)
+					err = DecodeArray(value, func(value Raw) error {
+						var item generatedBenchmarkItem
+						var err error
+						if registered && Registered((*generatedBenchmarkItem)(nil)) {
+							decoded := new(generatedBenchmarkItem)
+							err = value.Unmarshal(decoded)
+							item = *decoded
+						} else {
+							item = generatedBenchmarkItem{}
+							err = DecodeDocument(value, &item)
+						}
+						if err != nil {
+							return err
+						}
+
+						p.Items = append(p.Items, item)
+						return nil
+					})
+				}
+			}
+		case "counts", "Counts":
+			if registered && Registered((*[]int)(nil)) {
+				err = value.Unmarshal(&p.Counts)
+			} else {
+				p.Counts = nil
+				if !value.IsNull() {
+					p.Counts = make([]int, 0)
+					err = DecodeArray(value, func(value Raw) error {
+						var item int
+						var err error
+						if registered && Registered((*int)(nil)) {
+							decoded := new(int)
+							err = value.Unmarshal(decoded)
+							item = *decoded
+						} else {
+							item, err = DecodeInt(value)
+						}
+						if err != nil {
+							return err
+						}
+
+						p.Counts = append(p.Counts, item)
+						return nil
+					})
+				}
+			}
+		case "value", "Value":
+			if registered && Registered((**string)(nil)) {
+				err = value.Unmarshal(&p.Value)
+			} else {
+				p.Value = nil
+				if !value.IsNull() {
+					p.Value = new(string)
+					if registered && Registered((*string)(nil)) {
+						err = value.Unmarshal(p.Value)
+					} else {
+						(*p.Value), err = DecodeString(value)
+					}
+				}
+			}
+		case "map", "Map":
+			err = value.Unmarshal(&p.Map)
+		case "any", "Any":
+			err = value.Unmarshal(&p.Any)
+		case "omitted", "Omitted":
+			if registered && Registered((*string)(nil)) {
+				err = value.Unmarshal(&p.Omitted)
+			} else {
+				p.Omitted, err = DecodeString(value)
+			}
+		case "omitmap", "OmitMap":
+			err = value.Unmarshal(&p.OmitMap)
+		case "Untagged":
+			if registered && Registered((*string)(nil)) {
+				err = value.Unmarshal(&p.Untagged)
+			} else {
+				p.Untagged, err = DecodeString(value)
+			}
+		}
+
+		if err != nil {
+			return FieldError(element.Key(), err)
+		}
+	}
+
+	return iterator.Err()
+}
+
+// Append the BSON document of the struct to dst
+func (p *generatedRegisteredInner) AppendBSON(dst []byte) ([]byte, error) {
+	var err error
+	registered := HasRegisteredCodecs()
+	start := len(dst)
+	dst = AppendDocumentStart(dst)
+	if registered && Registered((*string)(nil)) {
+		dst, err = AppendValueElement(dst, "value", p.Value)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst = AppendStringElement(dst, "value", p.Value)
+	}
+	return AppendDocumentEnd(dst, start), nil
+}
+
+func (p *generatedRegisteredInner) MarshalBSON() ([]byte, error) {
+	return p.AppendBSON(nil)
+}
+
+// Decode a BSON document into the struct
+func (p *generatedRegisteredInner) UnmarshalBSON(data []byte) error {
+	registered := HasRegisteredCodecs()
+	iterator := RawDocument(data).Iterator()
+	for iterator.Next() {
+		element := iterator.Element()
+		value := element.Value()
+
+		var err error
+		switch string(element.KeyBytes()) {
+		case "value", "Value":
+			if registered && Registered((*string)(nil)) {
+				err = value.Unmarshal(&p.Value)
+			} else {
+				p.Value, err = DecodeString(value)
+			}
+		}
+
+		if err != nil {
+			return FieldError(element.Key(), err)
+		}
+	}
+
+	return iterator.Err()
+}
+
+// Append the BSON document of the struct to dst
+func (p *generatedRegisteredDocument) AppendBSON(dst []byte) ([]byte, error) {
+	var err error
+	registered := HasRegisteredCodecs()
+	start := len(dst)
+	dst = AppendDocumentStart(dst)
+	if registered && Registered((*generatedRegisteredInner)(nil)) {
+		dst, err = AppendValueElement(dst, "inner", p.Inner)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst, err = AppendDocumentElement(dst, "inner", &p.Inner)
+		if err != nil {
+			return dst[:start], err
+		}
+	}
+	if registered && Registered((**generatedRegisteredInner)(nil)) {
+		dst, err = AppendValueElement(dst, "pointer", p.Pointer)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		if p.Pointer == nil {
+			dst = AppendNullElement(dst, "pointer")
+		} else {
+			if registered && Registered((*generatedRegisteredInner)(nil)) {
+				dst, err = AppendValueElement(dst, "pointer", *p.Pointer)
+				if err != nil {
+					return dst[:start], err
+				}
+			} else {
+				dst, err = AppendDocumentElement(dst, "pointer", p.Pointer)
+				if err != nil {
+					return dst[:start], err
+				}
+			}
+		}
+	}
+	if registered && Registered((*[]generatedRegisteredInner)(nil)) {
+		dst, err = AppendValueElement(dst, "items", p.Items)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		{
+			var arrayStart int
+			dst, arrayStart = AppendArrayElementStart(dst, "items")
+			for i := range p.Items {
+				if registered && Registered((*generatedRegisteredInner)(nil)) {
+					dst, err = AppendValueElement(dst, ArrayIndexKey(i), p.Items[i])
+					if err != nil {
+						return dst[:start], err
+					}
+				} else {
+					dst, err = AppendDocumentElement(dst, ArrayIndexKey(i), &p.Items[i])
+					if err != nil {
+						return dst[:start], err
+					}
+				}
+			}
+			dst = AppendDocumentEnd(dst, arrayStart)
+		}
+	}
+	if registered && Registered((*generatedBenchmarkT1)(nil)) {
+		dst, err = AppendValueElement(dst, "plain", p.Plain)
+		if err != nil {
+			return dst[:start], err
+		}
+	} else {
+		dst, err = AppendDocumentElement(dst, "plain", &p.Plain)
+		if err != nil {
+			return dst[:start], err
+		}
+	}
+	return AppendDocumentEnd(dst, start), nil
+}
+
+func (p *generatedRegisteredDocument) MarshalBSON() ([]byte, error) {
+	return p.AppendBSON(nil)
+}
+
+// Decode a BSON document into the struct
+func (p *generatedRegisteredDocument) UnmarshalBSON(data []byte) error {
+	registered := HasRegisteredCodecs()
+	iterator := RawDocument(data).Iterator()
+	for iterator.Next() {
+		element := iterator.Element()
+		value := element.Value()
+
+		var err error
+		switch string(element.KeyBytes()) {
+		case "inner", "Inner":
+			if registered && Registered((*generatedRegisteredInner)(nil)) {
+				err = value.Unmarshal(&p.Inner)
+			} else {
+				p.Inner = generatedRegisteredInner{}
+				err = DecodeDocument(value, &p.Inner)
+			}
+		case "pointer", "Pointer":
+			if registered && Registered((**generatedRegisteredInner)(nil)) {
+				err = value.Unmarshal(&p.Pointer)
+			} else {
+				p.Pointer = nil
+				if !value.IsNull() {
+					p.Pointer = new(generatedRegisteredInner)
+					if registered && Registered((*generatedRegisteredInner)(nil)) {
+						err = value.Unmarshal(p.Pointer)
+					} else {
+						err = DecodeDocument(value, p.Pointer)
+					}
+				}
+			}
+		case "items", "Items":
+			if registered && Registered((*[]generatedRegisteredInner)(nil)) {
+				err = value.Unmarshal(&p.Items)
+			} else {
+				p.Items = nil
+				if !value.IsNull() {
+					p.Items = make([]generatedRegisteredInner, 0)
+					err = DecodeArray(value, func(value Raw) error {
+						var item generatedRegisteredInner
+						var err error
+						if registered && Registered((*generatedRegisteredInner)(nil)) {
+							decoded := new(generatedRegisteredInner)
+							err = value.Unmarshal(decoded)
+							item = *decoded
+						} else {
+							item = generatedRegisteredInner{}
+							err = DecodeDocument(value, &item)
+						}
+						if err != nil {
+							return err
+						}
+
+						p.Items = append(p.Items, item)
+						return nil
+					})
+				}
+			}
+		case "plain", "Plain":
+			if registered && Registered((*generatedBenchmarkT1)(nil)) {
+				err = value.Unmarshal(&p.Plain)
+			} else {
+				p.Plain = generatedBenchmarkT1{}
+				err = DecodeDocument(value, &p.Plain)
+			}
+		}
+
+		if err != nil {
+			return FieldError(element.Key(), err)
+		}
+	}
+
+	return iterator.Err()
+}
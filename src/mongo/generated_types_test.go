@@ -0,0 +1,79 @@
+package mongo
+
+import (
+	"time"
+)
+
+//go:generate go run bsongen -type generatedBenchmarkT1,generatedBenchmarkT2,generatedBenchmarkItem,generatedBenchmarkDocument,generatedTestDocument,generatedRegisteredInner,generatedRegisteredDocument
+
+// The benchmark documents with generated codecs
+type generatedBenchmarkT1 struct {
+	Int int32 `bson:"int,omitempty"`
+}
+
+type generatedBenchmarkT2 struct {
+	String string                `bson:"string,omitempty"`
+	Doc    *generatedBenchmarkT1 `bson:"doc,omitempty"`
+}
+
+type generatedBenchmarkItem struct {
+	Name  string   `bson:"name"`
+	Count int64    `bson:"count"`
+	Price float64  `bson:"price"`
+	Tags  []string `bson:"tags"`
+}
+
+type generatedBenchmarkDocument struct {
+	Id    string                   `bson:"_id"`
+	Items []generatedBenchmarkItem `bson:"items"`
+}
+
+func newGeneratedBenchmarkDocument() *generatedBenchmarkDocument {
+	doc := &generatedBenchmarkDocument{Id: "large"}
+	for i := 0; i < 50; i++ {
+		doc.Items = append(doc.Items, generatedBenchmarkItem{"item", int64(i), 1.5, []string{"a", "b", "c"}})
+	}
+
+	return doc
+}
+
+// Every kind of field the generator handles
+type generatedTestDocument struct {
+	Id         ObjectId                 `bson:"_id"`
+	Name       string                   `bson:"name"`
+	Active     bool                     `bson:"active"`
+	Ratio      float64                  `bson:"ratio"`
+	Small      float32                  `bson:"small"`
+	Count      int                      `bson:"count"`
+	Int8       int8                     `bson:"int8"`
+	Int32      int32                    `bson:"int32"`
+	Int64      int64                    `bson:"int64"`
+	MinSize    int64                    `bson:"minsize,minsize"`
+	MinSizePtr *int64                   `bson:"minsizeptr,minsize"`
+	Created    time.Time                `bson:"created"`
+	Data       []byte                   `bson:"data"`
+	Inner      generatedBenchmarkT1     `bson:"inner"`
+	Pointer    *generatedBenchmarkT1    `bson:"pointer"`
+	Items      []generatedBenchmarkItem `bson:"items"`
+	Counts     []int                    `bson:"counts"`
+	Value      *string                  `bson:"value"`
+	Map        map[string]interface{}   `bson:"map"`
+	Any        interface{}              `bson:"any"`
+	Omitted    string                   `bson:"omitted,omitempty"`
+	OmitMap    map[string]int           `bson:"omitmap,omitempty"`
+	Untagged   string
+	Ignored    string `bson:"-"`
+	unexported string
+}
+
+// A struct the tests register a codec for in DefaultRegistry
+type generatedRegisteredInner struct {
+	Value string `bson:"value"`
+}
+
+type generatedRegisteredDocument struct {
+	Inner   generatedRegisteredInner   `bson:"inner"`
+	Pointer *generatedRegisteredInner  `bson:"pointer"`
+	Items   []generatedRegisteredInner `bson:"items"`
+	Plain   generatedBenchmarkT1       `bson:"plain"`
+}
@@ -0,0 +1,192 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Describes a value that could not be decoded into its field, Path is the
+// dotted path of the field from the top level document
+type DecodeError struct {
+	Path    string
+	Message string
+}
+
+func (p *DecodeError) Error() string {
+	if p.Path == "" {
+		return p.Message
+	}
+
+	return fmt.Sprintf("field %v: %v", p.Path, p.Message)
+}
+
+func newDecodeError(path string, format string, args ...interface{}) error {
+	return &DecodeError{path, fmt.Sprintf(format, args...)}
+}
+
+// Prefix the path of a decoding error with the field of the document the
+// error happened in, other errors are returned as is
+func prefixDecodeError(fieldName string, err error) error {
+	if decodeError, ok := err.(*DecodeError); ok {
+		path := fieldName
+		if decodeError.Path != "" {
+			path = joinPath(fieldName, decodeError.Path)
+		}
+
+		return &DecodeError{path, decodeError.Message}
+	}
+
+	return err
+}
+
+// Largest double that converts to an int64 or uint64 without overflowing
+const maxInt64Float = 1 << 63
+const maxUint64Float = 1 << 64
+
+// The value of a BSON int32, int64 or double as an int64, doubles must
+// be integral
+func numberToInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		if v != math.Trunc(v) {
+			return 0, errors.New(fmt.Sprintf("double %v would be truncated to an integer", v))
+		}
+
+		if v < -maxInt64Float || v >= maxInt64Float {
+			return 0, errors.New(fmt.Sprintf("double %v overflows int64", v))
+		}
+
+		return int64(v), nil
+	}
+
+	return 0, errors.New(fmt.Sprintf("BSON %T is not a number", value))
+}
+
+// The value of a BSON int32, int64 or double as an uint64, the value can
+// not be negative
+func numberToUint64(value interface{}) (uint64, error) {
+	// Doubles can be larger than an int64
+	if v, ok := value.(float64); ok && v >= maxInt64Float && v == math.Trunc(v) {
+		if v >= maxUint64Float {
+			return 0, errors.New(fmt.Sprintf("double %v overflows uint64", v))
+		}
+
+		return uint64(v), nil
+	}
+
+	i, err := numberToInt64(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if i < 0 {
+		return 0, errors.New(fmt.Sprintf("negative value %v overflows an unsigned integer", i))
+	}
+
+	return uint64(i), nil
+}
+
+// The value of a BSON int32, int64 or double as a float64, integers must
+// be represented exactly
+func numberToFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), nil
+	case int64:
+		f := float64(v)
+		if f >= maxInt64Float || int64(f) != v {
+			return 0, errors.New(fmt.Sprintf("int64 %v can not be represented exactly by a double", v))
+		}
+
+		return f, nil
+	case float64:
+		return v, nil
+	}
+
+	return 0, errors.New(fmt.Sprintf("BSON %T is not a number", value))
+}
+
+// Is the value a decoded BSON int32, int64 or double
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int32, int64, float64:
+		return true
+	}
+
+	return false
+}
+
+// Set a BSON int32, int64 or double on a field of any integer or float
+// kind, returns false if the value or the field are not numbers. Values
+// that overflow the field or would lose their fractional part are errors,
+// doubles are rounded to the nearest float32.
+func setNumber(path string, field reflect.Value, value interface{}) (bool, error) {
+	if !isNumber(value) {
+		return false, nil
+	}
+
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := numberToInt64(value)
+		if err != nil {
+			return true, newDecodeError(path, "%v", err)
+		}
+
+		if field.OverflowInt(i) {
+			return true, newDecodeError(path, "value %v overflows %v", i, field.Type())
+		}
+
+		field.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := numberToUint64(value)
+		if err != nil {
+			return true, newDecodeError(path, "%v", err)
+		}
+
+		if field.OverflowUint(u) {
+			return true, newDecodeError(path, "value %v overflows %v", u, field.Type())
+		}
+
+		field.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := numberToFloat64(value)
+		if err != nil {
+			return true, newDecodeError(path, "%v", err)
+		}
+
+		if !math.IsInf(f, 0) && field.OverflowFloat(f) {
+			return true, newDecodeError(path, "value %v overflows %v", f, field.Type())
+		}
+
+		// Integers must also be exact in a float32
+		if _, ok := value.(float64); !ok && field.Kind() == reflect.Float32 && float64(float32(f)) != f {
+			return true, newDecodeError(path, "value %v can not be represented exactly by %v", value, field.Type())
+		}
+
+		field.SetFloat(f)
+	default:
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// The number stored in a raw BSON int32, int64 or double
+func (p Raw) number() (interface{}, error) {
+	switch p.Kind {
+	case byte(bsonInt32):
+		return p.Int32()
+	case byte(bsonInt64):
+		return p.Int64()
+	case byte(bsonDouble):
+		return p.Float64()
+	}
+
+	return nil, p.typeError("number")
+}
@@ -0,0 +1,210 @@
+package mongo
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+type numericTestDocument struct {
+	Int     int     `bson:"int"`
+	Int8    int8    `bson:"int8"`
+	Int16   int16   `bson:"int16"`
+	Int32   int32   `bson:"int32"`
+	Int64   int64   `bson:"int64"`
+	Uint    uint    `bson:"uint"`
+	Uint8   uint8   `bson:"uint8"`
+	Uint32  uint32  `bson:"uint32"`
+	Uint64  uint64  `bson:"uint64"`
+	Float32 float32 `bson:"float32"`
+	Float64 float64 `bson:"float64"`
+}
+
+func decodeNumericTest(t *testing.T, key string, value interface{}) (*numericTestDocument, error) {
+	document := NewDocument()
+	document.Add(key, value)
+
+	b, err := NewBSON().Marshall(document, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to marshal %v", err)
+	}
+
+	result := &numericTestDocument{}
+	return result, NewBSON().Unmarshal(b, result)
+}
+
+func TestNumericConversion(t *testing.T) {
+	tests := []struct {
+		key      string
+		value    interface{}
+		expected numericTestDocument
+	}{
+		{"int", int64(1 << 40), numericTestDocument{Int: 1 << 40}},
+		{"int", float64(-3), numericTestDocument{Int: -3}},
+		{"int8", int32(-128), numericTestDocument{Int8: -128}},
+		{"int16", int64(300), numericTestDocument{Int16: 300}},
+		{"int32", int64(math.MaxInt32), numericTestDocument{Int32: math.MaxInt32}},
+		{"int32", float64(10), numericTestDocument{Int32: 10}},
+		{"int64", float64(1 << 60), numericTestDocument{Int64: 1 << 60}},
+		{"uint", int32(7), numericTestDocument{Uint: 7}},
+		{"uint8", int64(255), numericTestDocument{Uint8: 255}},
+		{"uint32", int64(math.MaxUint32), numericTestDocument{Uint32: math.MaxUint32}},
+		{"uint64", float64(1 << 63), numericTestDocument{Uint64: 1 << 63}},
+		{"float32", int32(1 << 24), numericTestDocument{Float32: 1 << 24}},
+		{"float32", float64(0.1), numericTestDocument{Float32: 0.1}},
+		{"float64", int32(5), numericTestDocument{Float64: 5}},
+		{"float64", int64(1 << 53), numericTestDocument{Float64: 1 << 53}},
+	}
+
+	for _, test := range tests {
+		result, err := decodeNumericTest(t, test.key, test.value)
+		if err != nil || *result != test.expected {
+			t.Errorf("%v %T %v: unexpected result %+v %v", test.key, test.value, test.value, result, err)
+		}
+	}
+}
+
+func TestNumericConversionErrors(t *testing.T) {
+	tests := []struct {
+		key     string
+		value   interface{}
+		message string
+	}{
+		{"int8", int32(128), "field int8: value 128 overflows int8"},
+		{"int16", int32(-40000), "field int16: value -40000 overflows int16"},
+		{"int32", int64(1 << 40), "field int32: value 1099511627776 overflows int32"},
+		{"int", float64(1.5), "field int: double 1.5 would be truncated to an integer"},
+		{"int64", float64(1e19), "field int64: double 1e+19 overflows int64"},
+		{"int64", math.NaN(), "field int64: double NaN would be truncated to an integer"},
+		{"uint", int32(-1), "field uint: negative value -1 overflows an unsigned integer"},
+		{"uint8", int32(256), "field uint8: value 256 overflows uint8"},
+		{"uint64", float64(1e20), "field uint64: double 1e+20 overflows uint64"},
+		{"float32", int32(1<<24 + 1), "field float32: value 16777217 can not be represented exactly by float32"},
+		{"float32", float64(1e39), "field float32: value 1e+39 overflows float32"},
+		{"float64", int64(1<<53 + 1), "field float64: int64 9007199254740993 can not be represented exactly by a double"},
+		{"int", "1", "field int: cannot decode BSON string into type int"},
+	}
+
+	for _, test := range tests {
+		_, err := decodeNumericTest(t, test.key, test.value)
+		if err == nil || err.Error() != test.message {
+			t.Errorf("%v %T %v: expected error %q got %v", test.key, test.value, test.value, test.message, err)
+		}
+
+		if _, ok := err.(*DecodeError); !ok {
+			t.Errorf("%v: expected a *DecodeError got %T", test.key, err)
+		}
+	}
+
+	// Infinities are doubles of any width
+	if result, err := decodeNumericTest(t, "float32", math.Inf(-1)); err != nil || !math.IsInf(float64(result.Float32), -1) {
+		t.Errorf("unexpected infinity %v %v", result.Float32, err)
+	}
+}
+
+func TestNumericConversionErrorPath(t *testing.T) {
+	type Item struct {
+		Count int8 `bson:"count"`
+	}
+
+	type Order struct {
+		Item   *Item             `bson:"item"`
+		Items  map[string]Item   `bson:"items"`
+		Counts []uint8           `bson:"counts"`
+		Nested map[string][]int8 `bson:"nested"`
+	}
+
+	item := NewDocument()
+	item.Add("count", int32(1000))
+
+	items := NewDocument()
+	items.Add("a", item)
+
+	nested := NewDocument()
+	nested.Add("a", []interface{}{int32(1), int32(-1000)})
+
+	tests := map[string]string{
+		"item":   "field item.count: value 1000 overflows int8",
+		"items":  "field items.a.count: value 1000 overflows int8",
+		"counts": "field counts.1: negative value -1 overflows an unsigned integer",
+		"nested": "field nested.a.1: value -1000 overflows int8",
+	}
+
+	values := map[string]interface{}{"item": item, "items": items, "counts": []interface{}{int32(1), int32(-1)}, "nested": nested}
+	for key, message := range tests {
+		document := NewDocument()
+		document.Add(key, values[key])
+		b, _ := NewBSON().Marshall(document, nil, 0)
+
+		err := NewBSON().Unmarshal(b, &Order{})
+		if err == nil || err.Error() != message {
+			t.Errorf("%v: expected error %q got %v", key, message, err)
+		}
+	}
+}
+
+func TestPointerFieldAllocation(t *testing.T) {
+	type T struct {
+		Int     *int     `bson:"int"`
+		Float   *float32 `bson:"float"`
+		String  *string  `bson:"string"`
+		Bool    *bool    `bson:"bool"`
+		Pointer **int64  `bson:"pointer"`
+		Null    *int     `bson:"null"`
+	}
+
+	document := NewDocument()
+	document.Add("int", int32(1))
+	document.Add("float", float64(1.5))
+	document.Add("string", "a")
+	document.Add("bool", true)
+	document.Add("pointer", int32(2))
+	document.Add("null", nil)
+	b, _ := NewBSON().Marshall(document, nil, 0)
+
+	one := 1
+	result := &T{Null: &one}
+	if err := NewBSON().Unmarshal(b, result); err != nil {
+		t.Fatalf("failed to unmarshal %v", err)
+	}
+
+	if result.Int == nil || *result.Int != 1 || result.Float == nil || *result.Float != 1.5 || result.String == nil || *result.String != "a" ||
+		result.Bool == nil || !*result.Bool || result.Pointer == nil || **result.Pointer != 2 || result.Null != nil {
+		t.Errorf("unexpected pointers %+v", result)
+	}
+}
+
+func TestGeneratedNumericConversion(t *testing.T) {
+	for _, value := range []interface{}{int32(5), int64(5), float64(5)} {
+		document := NewDocument()
+		document.Add("count", value)
+		document.Add("price", value)
+		b, _ := NewBSON().Marshall(document, nil, 0)
+
+		result := &generatedBenchmarkItem{}
+		if err := result.UnmarshalBSON(b); err != nil || result.Count != 5 || result.Price != 5 {
+			t.Errorf("%T: unexpected item %+v %v", value, result, err)
+		}
+	}
+
+	document := NewDocument()
+	document.Add("int", int64(1<<40))
+	b, _ := NewBSON().Marshall(document, nil, 0)
+
+	err := (&generatedBenchmarkT1{}).UnmarshalBSON(b)
+	if err == nil || !strings.Contains(err.Error(), "field int: value 1099511627776 overflows int32") {
+		t.Errorf("expected an overflow error got %v", err)
+	}
+
+	// Array elements are named by their index
+	item := NewDocument()
+	item.Add("price", int64(1<<53+1))
+	document = NewDocument()
+	document.Add("items", []interface{}{NewDocument(), item})
+	b, _ = NewBSON().Marshall(document, nil, 0)
+
+	err = (&generatedBenchmarkDocument{}).UnmarshalBSON(b)
+	if err == nil || err.Error() != "field items.1.price: int64 9007199254740993 can not be represented exactly by a double" {
+		t.Errorf("expected an error naming the path got %v", err)
+	}
+}
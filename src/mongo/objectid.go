@@ -0,0 +1,163 @@
+package mongo
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Random value unique to the process, the middle 5 bytes of every ObjectId
+var objectIdProcessUnique = readObjectIdRandom(5)
+
+// Counter for the last 3 bytes, starting at a random value
+var objectIdCounter = readObjectIdCounter()
+
+func readObjectIdRandom(size int) []byte {
+	b := make([]byte, size)
+	_, err := io.ReadFull(rand.Reader, b)
+	if err != nil {
+		panic(fmt.Sprintf("cannot initialize the objectid generator: %v", err))
+	}
+
+	return b
+}
+
+func readObjectIdCounter() uint32 {
+	b := readObjectIdRandom(3)
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+// Create a new ObjectId from the current time, the process unique random
+// value and the counter
+func NewObjectId() ObjectId {
+	return NewObjectIdFromTimestamp(time.Now())
+}
+
+// Create a new ObjectId with the timestamp t
+func NewObjectIdFromTimestamp(t time.Time) ObjectId {
+	id := make([]byte, 12)
+	// Seconds since the unix epoch, big endian
+	binary.BigEndian.PutUint32(id[0:4], uint32(t.Unix()))
+	copy(id[4:9], objectIdProcessUnique)
+
+	// Counter, big endian
+	counter := atomic.AddUint32(&objectIdCounter, 1)
+	id[9] = byte(counter >> 16)
+	id[10] = byte(counter >> 8)
+	id[11] = byte(counter)
+
+	return ObjectId{id}
+}
+
+// Parse the 24 character hex representation of an ObjectId
+func ObjectIdFromHex(str string) (ObjectId, error) {
+	id, err := hex.DecodeString(str)
+	if err != nil || len(id) != 12 {
+		return ObjectId{}, errors.New(fmt.Sprintf("%v is not a valid objectid", str))
+	}
+
+	return ObjectId{id}, nil
+}
+
+// Is the id exactly 12 bytes
+func (p ObjectId) IsValid() bool {
+	return len(p.Id) == 12
+}
+
+func (p ObjectId) Hex() string {
+	return hex.EncodeToString(p.Id)
+}
+
+func (p ObjectId) String() string {
+	return fmt.Sprintf("ObjectId(%q)", p.Hex())
+}
+
+// The time the id was created, with a resolution of a second
+func (p ObjectId) Timestamp() time.Time {
+	if !p.IsValid() {
+		return time.Time{}
+	}
+
+	return time.Unix(int64(binary.BigEndian.Uint32(p.Id[0:4])), 0).UTC()
+}
+
+// Compare the bytes of the ids, returns -1, 0 or 1 like bytes.Compare,
+// ids created later by the same process compare greater
+func (p ObjectId) Compare(id ObjectId) int {
+	return bytes.Compare(p.Id, id.Id)
+}
+
+func (p ObjectId) Equal(id ObjectId) bool {
+	return bytes.Equal(p.Id, id.Id)
+}
+
+// Marshal as the hex string, the empty id is marshalled as null
+func (p ObjectId) MarshalJSON() ([]byte, error) {
+	if len(p.Id) == 0 {
+		return []byte("null"), nil
+	}
+
+	if !p.IsValid() {
+		return nil, errors.New(fmt.Sprintf("objectid must be 12 bytes got %v", len(p.Id)))
+	}
+
+	return json.Marshal(p.Hex())
+}
+
+// Unmarshal the hex string or the {"$oid": "..."} extended json form,
+// null and the empty string set the empty id
+func (p *ObjectId) UnmarshalJSON(data []byte) error {
+	if string(data) == "null" {
+		p.Id = nil
+		return nil
+	}
+
+	var str string
+	if len(data) > 0 && data[0] == '{' {
+		var extJSON struct {
+			Oid *string `json:"$oid"`
+		}
+
+		err := json.Unmarshal(data, &extJSON)
+		if err != nil || extJSON.Oid == nil {
+			return errors.New(fmt.Sprintf("%s is not a valid objectid", data))
+		}
+
+		str = *extJSON.Oid
+	} else if err := json.Unmarshal(data, &str); err != nil {
+		return errors.New(fmt.Sprintf("%s is not a valid objectid", data))
+	}
+
+	return p.UnmarshalText([]byte(str))
+}
+
+func (p ObjectId) MarshalText() ([]byte, error) {
+	if len(p.Id) != 0 && !p.IsValid() {
+		return nil, errors.New(fmt.Sprintf("objectid must be 12 bytes got %v", len(p.Id)))
+	}
+
+	return []byte(p.Hex()), nil
+}
+
+// Unmarshal the hex string, the empty string sets the empty id
+func (p *ObjectId) UnmarshalText(data []byte) error {
+	if len(data) == 0 {
+		p.Id = nil
+		return nil
+	}
+
+	id, err := ObjectIdFromHex(string(data))
+	if err != nil {
+		return err
+	}
+
+	*p = id
+	return nil
+}
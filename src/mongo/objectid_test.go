@@ -0,0 +1,160 @@
+package mongo
+
+import (
+	"encoding/json"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+	"time"
+)
+
+func TestNewObjectId(t *testing.T) {
+	before := time.Now().Add(-time.Second)
+	first := NewObjectId()
+	second := NewObjectId()
+
+	if !first.IsValid() || !second.IsValid() {
+		t.Fatalf("expected 12 byte ids got %v %v", first, second)
+	}
+
+	// The timestamp is the creation time
+	if first.Timestamp().Before(before.Truncate(time.Second)) || first.Timestamp().After(time.Now()) {
+		t.Errorf("unexpected timestamp %v", first.Timestamp())
+	}
+
+	// Same process value and incrementing counter
+	if string(first.Id[4:9]) != string(second.Id[4:9]) {
+		t.Errorf("expected the same process value in %v and %v", first, second)
+	}
+
+	if first.Equal(second) {
+		t.Errorf("expected different ids got %v twice", first)
+	}
+
+	counter := func(id ObjectId) uint32 {
+		return uint32(id.Id[9])<<16 | uint32(id.Id[10])<<8 | uint32(id.Id[11])
+	}
+
+	if (counter(first)+1)&0xffffff != counter(second) {
+		t.Errorf("expected an incremented counter in %v and %v", first, second)
+	}
+
+	// The timestamp is big endian seconds
+	created := time.Unix(0x54d38e1f, 0).UTC()
+	id := NewObjectIdFromTimestamp(created)
+	if id.Hex()[:8] != "54d38e1f" || !id.Timestamp().Equal(created) {
+		t.Errorf("unexpected id %v with timestamp %v", id, id.Timestamp())
+	}
+}
+
+func TestObjectIdHex(t *testing.T) {
+	id, err := ObjectIdFromHex("54d38e1f5d3a1c2b11223344")
+	if err != nil {
+		t.Fatalf("failed to parse the objectid %v", err)
+	}
+
+	if string(id.Id) != string(testObjectId) {
+		t.Errorf("unexpected id bytes %v", id.Id)
+	}
+
+	if id.Hex() != "54d38e1f5d3a1c2b11223344" || id.String() != `ObjectId("54d38e1f5d3a1c2b11223344")` {
+		t.Errorf("unexpected hex %v and string %v", id.Hex(), id.String())
+	}
+
+	// Matches the hex representation of mgo
+	if id.Hex() != bson.ObjectId(testObjectId).Hex() {
+		t.Errorf("expected %v got %v", bson.ObjectId(testObjectId).Hex(), id.Hex())
+	}
+
+	for _, str := range []string{"", "54d38e1f", "54d38e1f5d3a1c2b1122334455", "54d38e1f5d3a1c2b1122334g"} {
+		if _, err := ObjectIdFromHex(str); err == nil {
+			t.Errorf("expected an error parsing %v", str)
+		}
+	}
+}
+
+func TestObjectIdCompare(t *testing.T) {
+	older := NewObjectIdFromTimestamp(time.Unix(1000, 0))
+	newer := NewObjectIdFromTimestamp(time.Unix(2000, 0))
+
+	if older.Compare(newer) != -1 || newer.Compare(older) != 1 || older.Compare(older) != 0 {
+		t.Errorf("unexpected comparison of %v and %v", older, newer)
+	}
+
+	if !older.Equal(ObjectId{append([]byte{}, older.Id...)}) || older.Equal(newer) {
+		t.Errorf("unexpected equality of %v and %v", older, newer)
+	}
+}
+
+func TestObjectIdJSON(t *testing.T) {
+	type T struct {
+		Id    ObjectId  `json:"id"`
+		Ref   *ObjectId `json:"ref"`
+		Empty ObjectId  `json:"empty"`
+	}
+
+	id := ObjectId{testObjectId}
+	b, err := json.Marshal(&T{Id: id, Ref: &id})
+	if err != nil || string(b) != `{"id":"54d38e1f5d3a1c2b11223344","ref":"54d38e1f5d3a1c2b11223344","empty":null}` {
+		t.Errorf("unexpected json %s %v", b, err)
+	}
+
+	// Hex strings and extended json
+	for _, str := range []string{
+		`{"id":"54d38e1f5d3a1c2b11223344","ref":"54d38e1f5d3a1c2b11223344","empty":null}`,
+		`{"id":{"$oid":"54d38e1f5d3a1c2b11223344"},"ref":{"$oid":"54d38e1f5d3a1c2b11223344"},"empty":""}`,
+	} {
+		result := &T{}
+		err := json.Unmarshal([]byte(str), result)
+		if err != nil || !result.Id.Equal(id) || result.Ref == nil || !result.Ref.Equal(id) || result.Empty.Id != nil {
+			t.Errorf("unexpected result %+v %v parsing %s", result, err, str)
+		}
+	}
+
+	for _, str := range []string{`"54d38e1f"`, `1`, `{"$id":"54d38e1f5d3a1c2b11223344"}`} {
+		if err := json.Unmarshal([]byte(str), &ObjectId{}); err == nil {
+			t.Errorf("expected an error parsing %s", str)
+		}
+	}
+
+	// Text marshalling is used for map keys
+	b, err = json.Marshal(map[string]interface{}{"id": id})
+	if err != nil || string(b) != `{"id":"54d38e1f5d3a1c2b11223344"}` {
+		t.Errorf("unexpected json %s %v", b, err)
+	}
+
+	text, err := id.MarshalText()
+	if err != nil || string(text) != "54d38e1f5d3a1c2b11223344" {
+		t.Errorf("unexpected text %s %v", text, err)
+	}
+
+	result := ObjectId{}
+	if err := result.UnmarshalText(text); err != nil || !result.Equal(id) {
+		t.Errorf("unexpected id %v %v", result, err)
+	}
+}
+
+func TestObjectIdSerializationErrors(t *testing.T) {
+	parser := NewBSON()
+
+	for _, doc := range []interface{}{
+		&struct{ Id ObjectId }{ObjectId{[]byte{1, 2, 3}}},
+		&struct{ Id ObjectId }{},
+		&struct{ Ref DBPointer }{DBPointer{"test.users", ObjectId{make([]byte, 13)}}},
+	} {
+		if _, err := parser.Marshall(doc, nil, 0); err == nil {
+			t.Errorf("expected an error serializing %+v", doc)
+		}
+	}
+
+	// Valid ids round trip
+	doc := &struct{ Id ObjectId }{NewObjectId()}
+	b, err := parser.Marshall(doc, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to serialize %v", err)
+	}
+
+	result := &struct{ Id ObjectId }{}
+	if err := parser.Unmarshal(b, result); err != nil || !result.Id.Equal(doc.Id) {
+		t.Errorf("expected %v got %v %v", doc.Id, result.Id, err)
+	}
+}
@@ -0,0 +1,43 @@
+package mongo
+
+import (
+	"sync"
+)
+
+// A buffer from a BufferPool, append to Bytes and return it with Put once
+// nothing refers to the bytes anymore
+type Buffer struct {
+	Bytes []byte
+}
+
+// Pool of encoding buffers shared between goroutines, buffers that grew
+// beyond the maximum size are dropped instead of being kept alive
+type BufferPool struct {
+	pool    sync.Pool
+	maxSize int
+}
+
+func NewBufferPool(initialSize int, maxSize int) *BufferPool {
+	pool := &BufferPool{maxSize: maxSize}
+	pool.pool.New = func() interface{} {
+		return &Buffer{make([]byte, 0, initialSize)}
+	}
+
+	return pool
+}
+
+// Get an empty buffer
+func (p *BufferPool) Get() *Buffer {
+	buffer := p.pool.Get().(*Buffer)
+	buffer.Bytes = buffer.Bytes[:0]
+	return buffer
+}
+
+// Return a buffer to the pool
+func (p *BufferPool) Put(buffer *Buffer) {
+	if cap(buffer.Bytes) > p.maxSize {
+		return
+	}
+
+	p.pool.Put(buffer)
+}
@@ -0,0 +1,77 @@
+package mongo
+
+import (
+	"bytes"
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+)
+
+func TestMarshalAppend(t *testing.T) {
+	type Item struct {
+		Name string `bson:"name"`
+	}
+
+	parser := NewBSON()
+	expected, _ := bson.Marshal(&Item{"item"})
+	header := []byte{1, 2, 3, 4}
+
+	// Appended after the existing bytes without reallocating
+	dst := make([]byte, 0, 64)
+	dst = append(dst, header...)
+	b, err := parser.MarshalAppend(dst, &Item{"item"})
+	if err != nil || !bytes.Equal(b, append(append([]byte{}, header...), expected...)) {
+		t.Fatalf("unexpected bytes %v %v", b, err)
+	}
+
+	if &b[0] != &dst[0] {
+		t.Errorf("expected the bytes to be appended in place")
+	}
+
+	// Grows like append keeping the existing bytes
+	b, err = parser.MarshalAppend(header, &Item{"item"})
+	if err != nil || !bytes.Equal(b, append(append([]byte{}, header...), expected...)) {
+		t.Errorf("unexpected bytes %v %v", b, err)
+	}
+
+	// Several documents one after the other
+	b, err = parser.MarshalAppend(nil, &Item{"item"})
+	if err == nil {
+		b, err = parser.MarshalAppend(b, &Item{"item"})
+	}
+
+	if err != nil || !bytes.Equal(b, append(append([]byte{}, expected...), expected...)) {
+		t.Errorf("unexpected bytes %v %v", b, err)
+	}
+
+	// Errors return dst unchanged
+	b, err = parser.MarshalAppend(dst, &struct{ Id ObjectId }{})
+	if err == nil || !bytes.Equal(b, header) {
+		t.Errorf("expected an error and the original bytes got %v %v", b, err)
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	pool := NewBufferPool(16, 64)
+
+	buffer := pool.Get()
+	if len(buffer.Bytes) != 0 || cap(buffer.Bytes) != 16 {
+		t.Errorf("expected an empty buffer of capacity 16 got %v %v", len(buffer.Bytes), cap(buffer.Bytes))
+	}
+
+	buffer.Bytes, _ = NewBSON().MarshalAppend(buffer.Bytes, map[string]interface{}{"a": "b"})
+	pool.Put(buffer)
+
+	// Buffers are returned empty
+	if buffer := pool.Get(); len(buffer.Bytes) != 0 {
+		t.Errorf("expected an empty buffer got %v", buffer.Bytes)
+	}
+
+	// Large buffers are dropped
+	large := &Buffer{make([]byte, 0, 128)}
+	pool.Put(large)
+	for i := 0; i < 10; i++ {
+		if pool.Get() == large {
+			t.Errorf("expected the large buffer to be dropped")
+		}
+	}
+}
@@ -0,0 +1,347 @@
+package mongo
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// A BSON document read in place, lookups and iteration return slices of
+// the document instead of decoding it
+type RawDocument []byte
+
+// A single element of a RawDocument, the type byte, the null terminated
+// field name and the value
+type RawElement []byte
+
+// Returned when a lookup does not match any element
+var ErrElementNotFound = errors.New("element not found")
+
+// Read the element starting at index, returns the element and the index
+// after it
+func (p RawDocument) readElement(index int) (RawElement, int, error) {
+	// Elements can not overlap the null terminator of the document
+	body := p[:len(p)-1]
+
+	// Type and field name
+	keyEnd := bytes.IndexByte(body[index+1:], 0x00)
+	if keyEnd == -1 {
+		return nil, index, errors.New("could not decode cstring, possibly corrupt bson")
+	}
+
+	// Find the end of the value
+	valueIndex := index + 1 + keyEnd + 1
+	end, err := valueEnd(body, valueIndex, body[index])
+	if err != nil {
+		return nil, index, err
+	}
+
+	return RawElement(p[index:end]), end, nil
+}
+
+// Check the document size and terminator
+func (p RawDocument) checkSize() error {
+	if len(p) < 5 {
+		return errors.New(fmt.Sprintf("document of %v bytes is smaller than the minimum size of 5", len(p)))
+	}
+
+	if int(readUInt32(p, 0)) != len(p) {
+		return errors.New(fmt.Sprintf("document length %v does not match the %v bytes available", readUInt32(p, 0), len(p)))
+	}
+
+	if p[len(p)-1] != 0 {
+		return errors.New("document is not null terminated")
+	}
+
+	return nil
+}
+
+// Validate the document and all the documents embedded in it
+func (p RawDocument) Validate() error {
+	return Validate(p)
+}
+
+// Return the value at the path, each key can be a dotted path itself,
+// Lookup("a", "b") and Lookup("a.b") are equivalent
+func (p RawDocument) Lookup(keys ...string) (Raw, error) {
+	if len(keys) == 0 {
+		return Raw{}, ErrElementNotFound
+	}
+
+	document := p
+	var value Raw
+
+	for _, key := range keys {
+		for {
+			// Take the next part of the dotted path
+			part := key
+			if dot := strings.IndexByte(key, '.'); dot != -1 {
+				part = key[:dot]
+				key = key[dot+1:]
+			} else {
+				key = ""
+			}
+
+			// Only documents and arrays can be traversed
+			if document == nil {
+				return Raw{}, ErrElementNotFound
+			}
+
+			element, err := document.lookupKey(part)
+			if err != nil {
+				return Raw{}, err
+			}
+
+			value = element.Value()
+			document = nil
+			if value.Kind == byte(bsonDocument) || value.Kind == byte(bsonArray) {
+				document = RawDocument(value.Data)
+			}
+
+			if key == "" {
+				break
+			}
+		}
+	}
+
+	return value, nil
+}
+
+// Find the element with the key at the top level of the document
+func (p RawDocument) lookupKey(key string) (RawElement, error) {
+	iterator := p.Iterator()
+	for iterator.Next() {
+		element := iterator.Element()
+		if element.keyEquals(key) {
+			return element, nil
+		}
+	}
+
+	if iterator.Err() != nil {
+		return nil, iterator.Err()
+	}
+
+	return nil, ErrElementNotFound
+}
+
+// Return all the elements of the document
+func (p RawDocument) Elements() ([]RawElement, error) {
+	elements := make([]RawElement, 0)
+	iterator := p.Iterator()
+	for iterator.Next() {
+		elements = append(elements, iterator.Element())
+	}
+
+	return elements, iterator.Err()
+}
+
+// Iterates over the elements of a RawDocument
+type RawIterator struct {
+	document RawDocument
+	index    int
+	element  RawElement
+	err      error
+}
+
+func (p RawDocument) Iterator() *RawIterator {
+	iterator := new(RawIterator)
+	iterator.document = p
+	iterator.index = 4
+	iterator.err = p.checkSize()
+	return iterator
+}
+
+// Move to the next element, returns false at the end of the document or
+// on an error
+func (p *RawIterator) Next() bool {
+	if p.err != nil || p.index >= len(p.document)-1 {
+		return false
+	}
+
+	p.element, p.index, p.err = p.document.readElement(p.index)
+	return p.err == nil
+}
+
+// The current element
+func (p *RawIterator) Element() RawElement {
+	return p.element
+}
+
+// The error that stopped the iteration if any
+func (p *RawIterator) Err() error {
+	return p.err
+}
+
+// Compare the key without converting it to a string
+func (p RawElement) keyEquals(key string) bool {
+	if len(p) < len(key)+2 || p[len(key)+1] != 0 {
+		return false
+	}
+
+	for i := 0; i < len(key); i++ {
+		if p[i+1] != key[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The field name of the element
+func (p RawElement) Key() string {
+	return string(p[1 : 1+bytes.IndexByte(p[1:], 0x00)])
+}
+
+// The field name of the element without converting it to a string
+func (p RawElement) KeyBytes() []byte {
+	return p[1 : 1+bytes.IndexByte(p[1:], 0x00)]
+}
+
+// The value of the element
+func (p RawElement) Value() Raw {
+	index := 1 + bytes.IndexByte(p[1:], 0x00) + 1
+	return Raw{p[0], p[index:]}
+}
+
+// Validate the element and any document embedded in it
+func (p RawElement) Validate() error {
+	key, index, err := validateCString(p, 1, len(p), "")
+	if err != nil {
+		return err
+	}
+
+	end, err := validateValue(p, index, len(p), p[0], key)
+	if err != nil {
+		return err
+	}
+
+	if end != len(p) {
+		return newValidationError(end, key, "unexpected %v bytes after the value", len(p)-end)
+	}
+
+	return nil
+}
+
+func (p Raw) IsNull() bool {
+	return p.Kind == byte(bsonNull)
+}
+
+func (p Raw) typeError(expected string) error {
+	return errors.New(fmt.Sprintf("value of BSON type 0x%02x is not a %v", p.Kind, expected))
+}
+
+func (p Raw) Float64() (float64, error) {
+	if p.Kind != byte(bsonDouble) {
+		return 0, p.typeError("double")
+	}
+
+	return math.Float64frombits(readUInt64(p.Data, 0)), nil
+}
+
+func (p Raw) StringValue() (string, error) {
+	if p.Kind != byte(bsonString) {
+		return "", p.typeError("string")
+	}
+
+	return string(p.Data[4 : len(p.Data)-1]), nil
+}
+
+func (p Raw) Document() (RawDocument, error) {
+	if p.Kind != byte(bsonDocument) {
+		return nil, p.typeError("document")
+	}
+
+	return RawDocument(p.Data), nil
+}
+
+func (p Raw) Array() (RawDocument, error) {
+	if p.Kind != byte(bsonArray) {
+		return nil, p.typeError("array")
+	}
+
+	return RawDocument(p.Data), nil
+}
+
+// The binary subtype and data, the data is not copied
+func (p Raw) Binary() (byte, []byte, error) {
+	if p.Kind != byte(bsonBinary) {
+		return 0, nil, p.typeError("binary")
+	}
+
+	// The old binary subtype wraps the data in a second length
+	data := p.Data[5:]
+	if p.Data[4] == 0x02 && len(data) >= 4 {
+		data = data[4:]
+	}
+
+	return p.Data[4], data, nil
+}
+
+func (p Raw) ObjectId() (ObjectId, error) {
+	if p.Kind != byte(bsonObjectId) {
+		return ObjectId{}, p.typeError("objectid")
+	}
+
+	return readObjectId(p.Data, 0), nil
+}
+
+func (p Raw) Bool() (bool, error) {
+	if p.Kind != byte(bsonBoolean) {
+		return false, p.typeError("boolean")
+	}
+
+	return p.Data[0] == 1, nil
+}
+
+func (p Raw) Time() (time.Time, error) {
+	if p.Kind != byte(bsonDateTime) {
+		return time.Time{}, p.typeError("datetime")
+	}
+
+	return millisecondsToTime(int64(readUInt64(p.Data, 0))), nil
+}
+
+func (p Raw) Int32() (int32, error) {
+	if p.Kind != byte(bsonInt32) {
+		return 0, p.typeError("int32")
+	}
+
+	return int32(readUInt32(p.Data, 0)), nil
+}
+
+func (p Raw) Timestamp() (Timestamp, error) {
+	if p.Kind != byte(bsonTimestamp) {
+		return Timestamp{}, p.typeError("timestamp")
+	}
+
+	return Timestamp{int64(readUInt64(p.Data, 0))}, nil
+}
+
+func (p Raw) Int64() (int64, error) {
+	if p.Kind != byte(bsonInt64) {
+		return 0, p.typeError("int64")
+	}
+
+	return int64(readUInt64(p.Data, 0)), nil
+}
+
+func (p Raw) Decimal128() (Decimal128, error) {
+	if p.Kind != byte(bsonDecimal128) {
+		return Decimal128{}, p.typeError("decimal128")
+	}
+
+	return Decimal128{readUInt64(p.Data, 8), readUInt64(p.Data, 0)}, nil
+}
+
+// Return any numeric value as an int64, doubles must be integral
+func (p Raw) AsInt64() (int64, error) {
+	value, err := p.number()
+	if err != nil {
+		return 0, err
+	}
+
+	return numberToInt64(value)
+}
@@ -0,0 +1,171 @@
+package mongo
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"testing"
+	"time"
+)
+
+func rawTestDocument(t *testing.T) RawDocument {
+	b, err := bson.Marshal(bson.D{
+		{Name: "find", Value: "users"},
+		{Name: "batchSize", Value: int32(2)},
+		{Name: "cursor", Value: bson.D{
+			{Name: "id", Value: int64(1 << 40)},
+			{Name: "ns", Value: "test.users"},
+		}},
+		{Name: "ids", Value: []interface{}{"a", bson.M{"b": 1.5}}},
+		{Name: "lsid", Value: bson.M{"id": bson.Binary{Kind: 0x04, Data: []byte{1, 2, 3}}}},
+		{Name: "ok", Value: true},
+		{Name: "time", Value: time.Date(2015, 2, 5, 10, 30, 0, 0, time.UTC)},
+		{Name: "oid", Value: bson.ObjectId(testObjectId)},
+		{Name: "regexp", Value: bson.RegEx{Pattern: "^a", Options: "i"}},
+		{Name: "scope", Value: bson.JavaScript{Code: "function() {}", Scope: bson.M{"a": 1}}},
+	})
+
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	return RawDocument(b)
+}
+
+func TestRawDocumentLookup(t *testing.T) {
+	document := rawTestDocument(t)
+
+	if err := document.Validate(); err != nil {
+		t.Fatalf("unexpected validation error %v", err)
+	}
+
+	if value, err := document.Lookup("find"); err != nil {
+		t.Errorf("failed to lookup find %v", err)
+	} else if name, err := value.StringValue(); err != nil || name != "users" {
+		t.Errorf("unexpected find %v %v", name, err)
+	}
+
+	// Nested lookups with keys and dotted paths
+	for _, path := range [][]string{{"cursor", "id"}, {"cursor.id"}} {
+		value, err := document.Lookup(path...)
+		if err != nil {
+			t.Errorf("failed to lookup %v %v", path, err)
+			continue
+		}
+
+		if id, err := value.Int64(); err != nil || id != 1<<40 {
+			t.Errorf("unexpected cursor id %v %v", id, err)
+		}
+	}
+
+	// Arrays are indexed by position
+	if value, err := document.Lookup("ids.1.b"); err != nil {
+		t.Errorf("failed to lookup ids.1.b %v", err)
+	} else if b, err := value.Float64(); err != nil || b != 1.5 {
+		t.Errorf("unexpected ids.1.b %v %v", b, err)
+	}
+
+	if value, err := document.Lookup("lsid", "id"); err != nil {
+		t.Errorf("failed to lookup lsid.id %v", err)
+	} else if subType, data, err := value.Binary(); err != nil || subType != 0x04 || len(data) != 3 {
+		t.Errorf("unexpected lsid %v %v %v", subType, data, err)
+	}
+
+	if value, err := document.Lookup("batchSize"); err != nil {
+		t.Errorf("failed to lookup batchSize %v", err)
+	} else if size, err := value.AsInt64(); err != nil || size != 2 {
+		t.Errorf("unexpected batchSize %v %v", size, err)
+	}
+
+	if value, _ := document.Lookup("ok"); value.Kind != 0x08 {
+		t.Errorf("unexpected ok kind %v", value.Kind)
+	} else if ok, err := value.Bool(); err != nil || !ok {
+		t.Errorf("unexpected ok %v %v", ok, err)
+	}
+
+	if value, _ := document.Lookup("time"); value.Kind != 0x09 {
+		t.Errorf("unexpected time kind %v", value.Kind)
+	} else if date, err := value.Time(); err != nil || !date.Equal(time.Date(2015, 2, 5, 10, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected time %v %v", date, err)
+	}
+
+	if value, _ := document.Lookup("oid"); value.Kind != 0x07 {
+		t.Errorf("unexpected oid kind %v", value.Kind)
+	} else if id, err := value.ObjectId(); err != nil || string(id.Id) != string(testObjectId) {
+		t.Errorf("unexpected oid %v %v", id, err)
+	}
+
+	// Missing fields and paths through values
+	for _, path := range []string{"missing", "find.a", "cursor.missing", "ids.5", "regexp.a"} {
+		if _, err := document.Lookup(path); err != ErrElementNotFound {
+			t.Errorf("expected %v looking up %v got %v", ErrElementNotFound, path, err)
+		}
+	}
+
+	// Typed accessors check the type
+	if value, _ := document.Lookup("find"); value.Kind == 0x02 {
+		if _, err := value.Int32(); err == nil {
+			t.Errorf("expected an error reading a string as an int32")
+		}
+	}
+}
+
+func TestRawDocumentIteration(t *testing.T) {
+	document := rawTestDocument(t)
+
+	elements, err := document.Elements()
+	if err != nil {
+		t.Fatalf("failed to read the elements %v", err)
+	}
+
+	keys := []string{"find", "batchSize", "cursor", "ids", "lsid", "ok", "time", "oid", "regexp", "scope"}
+	if len(elements) != len(keys) {
+		t.Fatalf("expected %v elements got %v", len(keys), len(elements))
+	}
+
+	for i, element := range elements {
+		if element.Key() != keys[i] {
+			t.Errorf("expected key %v got %v", keys[i], element.Key())
+		}
+	}
+
+	// The value can be decoded
+	cursor := NewDocument()
+	err = elements[2].Value().Unmarshal(cursor)
+	if err != nil {
+		t.Fatalf("failed to unmarshal the cursor %v", err)
+	}
+
+	if ns, err := cursor.String("ns"); err != nil || ns != "test.users" {
+		t.Errorf("unexpected ns %v %v", ns, err)
+	}
+}
+
+func TestRawDocumentValidation(t *testing.T) {
+	valid := []byte(rawTestDocument(t))
+
+	// Corrupt the document in different ways
+	tests := map[string][]byte{
+		"short":          valid[:4],
+		"size":           append(append([]byte{}, valid...), 0),
+		"terminator":     append(append([]byte{}, valid[:len(valid)-1]...), 1),
+		"string size":    []byte{13, 0, 0, 0, 0x02, 'a', 0, 10, 0, 0, 0, 0, 0},
+		"empty string":   []byte{13, 0, 0, 0, 0x02, 'a', 0, 0, 0, 0, 0, 0, 0},
+		"unknown type":   []byte{8, 0, 0, 0, 0x20, 'a', 0, 0},
+		"boolean":        []byte{9, 0, 0, 0, 0x08, 'a', 0, 2, 0},
+		"key":            []byte{8, 0, 0, 0, 0x08, 'a', 'b', 0},
+		"embedded size":  []byte{13, 0, 0, 0, 0x03, 'a', 0, 6, 0, 0, 0, 0, 0},
+		"overlaps end":   []byte{11, 0, 0, 0, 0x10, 'a', 0, 1, 0, 0, 0},
+		"truncated int":  []byte{10, 0, 0, 0, 0x10, 'a', 0, 1, 0, 0},
+		"truncated name": []byte{7, 0, 0, 0, 0x10, 'a', 0},
+	}
+
+	for name, b := range tests {
+		if err := RawDocument(b).Validate(); err == nil {
+			t.Errorf("%v: expected a validation error", name)
+		}
+
+		// Lookups fail instead of panicking
+		if _, err := RawDocument(b).Lookup("a.b"); err == nil {
+			t.Errorf("%v: expected a lookup error", name)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package mongo
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// Encodes a Go value as a BSON value, Kind is the BSON type of the value
+// and Data its bytes without the type and field name
+type ValueEncoder func(value reflect.Value) (Raw, error)
+
+// Decodes a BSON value into a settable Go value, null values are passed
+// to the decoder as well. The bytes of raw must be copied if they are kept.
+type ValueDecoder func(raw Raw, value reflect.Value) error
+
+// Encoders and decoders for Go types the package does not know about or
+// should handle differently, like uuid.UUID, big.Int or net.IP. They are
+// used before GetBSON, SetBSON and the default type mapping.
+type Registry struct {
+	// Serializes the registrations
+	lock sync.Mutex
+	// The *registryCodecs in use, replaced on every registration so
+	// lookups do not lock
+	codecs atomic.Value
+}
+
+type registryKey struct {
+	kind  byte
+	value reflect.Type
+}
+
+type registryCodecs struct {
+	encoders     map[reflect.Type]ValueEncoder
+	decoders     map[reflect.Type]ValueDecoder
+	typeDecoders map[registryKey]ValueDecoder
+	// The types with an encoder or decoder of any kind
+	types map[reflect.Type]bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// The registry used by the parsers returned by NewBSON
+var DefaultRegistry = NewRegistry()
+
+// Use encoder for all the values of type t
+func (p *Registry) RegisterEncoder(t reflect.Type, encoder ValueEncoder) {
+	p.update(func(codecs *registryCodecs) {
+		codecs.encoders[t] = encoder
+	})
+}
+
+// Use decoder for all the BSON values decoded into a value of type t
+func (p *Registry) RegisterDecoder(t reflect.Type, decoder ValueDecoder) {
+	p.update(func(codecs *registryCodecs) {
+		codecs.decoders[t] = decoder
+	})
+}
+
+// Use decoder for the BSON values of the given type decoded into a value
+// of type t, it takes precedence over the decoder registered for t and
+// allows overrides like decoding a BSON int32 into an int
+func (p *Registry) RegisterTypeDecoder(kind byte, t reflect.Type, decoder ValueDecoder) {
+	p.update(func(codecs *registryCodecs) {
+		codecs.typeDecoders[registryKey{kind, t}] = decoder
+	})
+}
+
+// Copy the codecs, apply the change and publish the copy
+func (p *Registry) update(change func(codecs *registryCodecs)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	codecs := &registryCodecs{
+		encoders:     make(map[reflect.Type]ValueEncoder),
+		decoders:     make(map[reflect.Type]ValueDecoder),
+		typeDecoders: make(map[registryKey]ValueDecoder),
+	}
+
+	if current := p.load(); current != nil {
+		for t, encoder := range current.encoders {
+			codecs.encoders[t] = encoder
+		}
+
+		for t, decoder := range current.decoders {
+			codecs.decoders[t] = decoder
+		}
+
+		for key, decoder := range current.typeDecoders {
+			codecs.typeDecoders[key] = decoder
+		}
+	}
+
+	change(codecs)
+
+	codecs.types = make(map[reflect.Type]bool)
+	for t := range codecs.encoders {
+		codecs.types[t] = true
+	}
+
+	for t := range codecs.decoders {
+		codecs.types[t] = true
+	}
+
+	for key := range codecs.typeDecoders {
+		codecs.types[key.value] = true
+	}
+
+	p.codecs.Store(codecs)
+}
+
+// The codecs in use, nil if nothing was registered so the encoder and
+// decoder can skip the lookups
+func (p *Registry) load() *registryCodecs {
+	if p == nil {
+		return nil
+	}
+
+	codecs, _ := p.codecs.Load().(*registryCodecs)
+	return codecs
+}
+
+func (p *registryCodecs) encoder(t reflect.Type) ValueEncoder {
+	if p == nil {
+		return nil
+	}
+
+	return p.encoders[t]
+}
+
+func (p *registryCodecs) decoder(kind byte, t reflect.Type) ValueDecoder {
+	if p == nil {
+		return nil
+	}
+
+	if decoder := p.typeDecoders[registryKey{kind, t}]; decoder != nil {
+		return decoder
+	}
+
+	return p.decoders[t]
+}
+
+// Is there an encoder or decoder for values of type t
+func (p *registryCodecs) registered(t reflect.Type) bool {
+	if p == nil {
+		return false
+	}
+
+	return p.types[t]
+}
@@ -0,0 +1,229 @@
+package mongo
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type registryTestUUID [16]byte
+
+type registryTestDocument struct {
+	Id      registryTestUUID    `bson:"_id"`
+	Amount  *big.Int            `bson:"amount"`
+	Address net.IP              `bson:"address"`
+	Count   int                 `bson:"count"`
+	Totals  map[string]*big.Int `bson:"totals"`
+}
+
+func newTestRegistry() *Registry {
+	registry := NewRegistry()
+
+	// UUIDs as binary subtype 4
+	registry.RegisterEncoder(reflect.TypeOf(registryTestUUID{}), func(value reflect.Value) (Raw, error) {
+		id := value.Interface().(registryTestUUID)
+		return Raw{byte(bsonBinary), append([]byte{16, 0, 0, 0, 0x04}, id[:]...)}, nil
+	})
+
+	registry.RegisterDecoder(reflect.TypeOf(registryTestUUID{}), func(raw Raw, value reflect.Value) error {
+		subType, data, err := raw.Binary()
+		if err != nil || subType != 0x04 || len(data) != 16 {
+			return errors.New("expected a binary uuid")
+		}
+
+		reflect.Copy(value, reflect.ValueOf(data))
+		return nil
+	})
+
+	// Big integers and addresses as strings
+	registry.RegisterEncoder(reflect.TypeOf(&big.Int{}), func(value reflect.Value) (Raw, error) {
+		return stringRaw(value.Interface().(*big.Int).String()), nil
+	})
+
+	registry.RegisterDecoder(reflect.TypeOf(&big.Int{}), func(raw Raw, value reflect.Value) error {
+		str, err := raw.StringValue()
+		if err != nil {
+			return err
+		}
+
+		i, ok := new(big.Int).SetString(str, 10)
+		if !ok {
+			return errors.New("invalid integer " + str)
+		}
+
+		value.Set(reflect.ValueOf(i))
+		return nil
+	})
+
+	registry.RegisterEncoder(reflect.TypeOf(net.IP{}), func(value reflect.Value) (Raw, error) {
+		return stringRaw(value.Interface().(net.IP).String()), nil
+	})
+
+	registry.RegisterDecoder(reflect.TypeOf(net.IP{}), func(raw Raw, value reflect.Value) error {
+		str, err := raw.StringValue()
+		if err != nil {
+			return err
+		}
+
+		value.Set(reflect.ValueOf(net.ParseIP(str)))
+		return nil
+	})
+
+	return registry
+}
+
+func stringRaw(str string) Raw {
+	data := make([]byte, 4, 4+len(str)+1)
+	writeU32(data, 0, uint32(len(str)+1))
+	data = append(data, str...)
+	return Raw{byte(bsonString), append(data, 0)}
+}
+
+func TestRegistryEncodingAndDecoding(t *testing.T) {
+	parser := NewBSON()
+	parser.SetRegistry(newTestRegistry())
+
+	amount, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	value := &registryTestDocument{
+		Id:      registryTestUUID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		Amount:  amount,
+		Address: net.ParseIP("10.0.0.1"),
+		Count:   10,
+		Totals:  map[string]*big.Int{"a": big.NewInt(1)},
+	}
+
+	b, err := parser.Marshall(value, nil, 0)
+	if err != nil {
+		t.Fatalf("failed to marshal %v", err)
+	}
+
+	// The same document built by hand
+	totals := NewDocument()
+	totals.Add("a", "1")
+	document := NewDocument()
+	document.Add("_id", &Binary{0x04, value.Id[:]})
+	document.Add("amount", "123456789012345678901234567890")
+	document.Add("address", "10.0.0.1")
+	document.Add("count", int32(10))
+	document.Add("totals", totals)
+
+	expected, _ := NewBSON().Marshall(document, nil, 0)
+	if !bytes.Equal(b, expected) {
+		t.Errorf("unexpected document\n%X\n%X", b, expected)
+	}
+
+	// Sizes go through the registered encoders too
+	if size, err := parser.Size(value); err != nil || size != len(expected) {
+		t.Errorf("unexpected size %v %v", size, err)
+	}
+
+	result := &registryTestDocument{}
+	if err := parser.Unmarshal(b, result); err != nil {
+		t.Fatalf("failed to unmarshal %v", err)
+	}
+
+	if result.Id != value.Id || result.Amount.Cmp(amount) != 0 || !result.Address.Equal(value.Address) || result.Count != 10 || result.Totals["a"].Int64() != 1 {
+		t.Errorf("unexpected decoded document %+v", result)
+	}
+
+	// Parsers without the registry use the default type mapping
+	if b, _ := NewBSON().Marshall(value, nil, 0); bytes.Equal(b, expected) {
+		t.Errorf("expected the default type mapping without the registry")
+	}
+}
+
+func TestRegistryTypeDecoderOverride(t *testing.T) {
+	registry := NewRegistry()
+	registry.RegisterTypeDecoder(byte(bsonString), reflect.TypeOf(int(0)), func(raw Raw, value reflect.Value) error {
+		str, err := raw.StringValue()
+		if err != nil {
+			return err
+		}
+
+		i, err := strconv.Atoi(str)
+		value.SetInt(int64(i))
+		return err
+	})
+
+	parser := NewBSON()
+	parser.SetRegistry(registry)
+
+	// Strings are converted, int32 values keep the default decoding
+	for _, count := range []interface{}{"42", int32(42)} {
+		document := NewDocument()
+		document.Add("count", count)
+		b, _ := parser.Marshall(document, nil, 0)
+
+		result := &registryTestDocument{}
+		if err := parser.Unmarshal(b, result); err != nil || result.Count != 42 {
+			t.Errorf("unexpected count %v %v", result.Count, err)
+		}
+	}
+
+	// Decoder errors name the field
+	document := NewDocument()
+	document.Add("count", "a")
+	b, _ := parser.Marshall(document, nil, 0)
+	if err := parser.Unmarshal(b, &registryTestDocument{}); err == nil || !strings.Contains(err.Error(), "field count") {
+		t.Errorf("expected an error naming the field got %v", err)
+	}
+}
+
+func TestRegistryEncoderErrors(t *testing.T) {
+	registry := NewRegistry()
+	registry.RegisterEncoder(reflect.TypeOf(net.IP{}), func(value reflect.Value) (Raw, error) {
+		return Raw{}, errors.New("failed")
+	})
+
+	// A value that does not match its type would corrupt the document
+	registry.RegisterEncoder(reflect.TypeOf(&big.Int{}), func(value reflect.Value) (Raw, error) {
+		return Raw{byte(bsonInt32), []byte{1}}, nil
+	})
+
+	parser := NewBSON()
+	parser.SetRegistry(registry)
+
+	if _, err := parser.Marshall(&registryTestDocument{Address: net.IP{1, 2, 3, 4}}, nil, 0); err == nil || !strings.Contains(err.Error(), "field address: failed") {
+		t.Errorf("expected the encoder error got %v", err)
+	}
+
+	if _, err := parser.Marshall(&registryTestDocument{Amount: big.NewInt(1)}, nil, 0); err == nil {
+		t.Errorf("expected an error encoding an invalid value")
+	}
+}
+
+type registryDefaultTestValue struct {
+	Value string
+}
+
+func TestDefaultRegistry(t *testing.T) {
+	DefaultRegistry.RegisterDecoder(reflect.TypeOf(registryDefaultTestValue{}), func(raw Raw, value reflect.Value) error {
+		str, err := raw.StringValue()
+		value.Set(reflect.ValueOf(registryDefaultTestValue{str}))
+		return err
+	})
+
+	// Raw values are decoded with the default registry
+	result := registryDefaultTestValue{}
+	if err := stringRaw("hello").Unmarshal(&result); err != nil || result.Value != "hello" {
+		t.Errorf("unexpected value %+v %v", result, err)
+	}
+
+	// As are parsers created with NewBSON
+	document := NewDocument()
+	document.Add("value", "world")
+	b, _ := NewBSON().Marshall(document, nil, 0)
+
+	var target struct {
+		Value registryDefaultTestValue `bson:"value"`
+	}
+
+	if err := NewBSON().Unmarshal(b, &target); err != nil || target.Value.Value != "world" {
+		t.Errorf("unexpected value %+v %v", target, err)
+	}
+}
@@ -0,0 +1,134 @@
+package mongo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Default maximum size of the documents read or written by a Decoder or
+// Encoder, the 16MB server limit plus room for the command overhead
+const DefaultMaxDocumentSize = 16*1024*1024 + 16*1024
+
+// Reads length prefixed BSON documents one at a time from a reader, like
+// a mongodump .bson file or a stream of wire message bodies
+type Decoder struct {
+	reader          io.Reader
+	parser          *BSON
+	buffer          []byte
+	maxDocumentSize int
+}
+
+func NewDecoder(reader io.Reader) *Decoder {
+	return NewBSON().NewDecoder(reader)
+}
+
+// Create a Decoder using the type cache and settings of the parser
+func (p *BSON) NewDecoder(reader io.Reader) *Decoder {
+	return &Decoder{reader, p, nil, DefaultMaxDocumentSize}
+}
+
+// Set the size above which documents are rejected without being read
+func (p *Decoder) SetMaxDocumentSize(size int) {
+	p.maxDocumentSize = size
+}
+
+// Read the next document, the returned document is only valid until the
+// next call as the buffer is reused. Returns io.EOF when the reader ends
+// between documents and io.ErrUnexpectedEOF when it ends inside one.
+func (p *Decoder) DecodeRaw() (RawDocument, error) {
+	var size [4]byte
+	_, err := io.ReadFull(p.reader, size[:])
+	if err != nil {
+		return nil, err
+	}
+
+	documentSize := int(int32(readUInt32(size[:], 0)))
+	if documentSize < 5 {
+		return nil, errors.New(fmt.Sprintf("document size %v is smaller than the minimum size of 5", documentSize))
+	}
+
+	if documentSize > p.maxDocumentSize {
+		return nil, errors.New(fmt.Sprintf("document size %v exceeds the maximum document size %v", documentSize, p.maxDocumentSize))
+	}
+
+	// Reuse the buffer when it is large enough
+	if cap(p.buffer) < documentSize {
+		p.buffer = make([]byte, documentSize)
+	}
+
+	p.buffer = p.buffer[:documentSize]
+	copy(p.buffer, size[:])
+
+	_, err = io.ReadFull(p.reader, p.buffer[4:])
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	return RawDocument(p.buffer), nil
+}
+
+// Read the next document and decode it into obj, obj can be any target
+// supported by Unmarshal. Returns io.EOF when there are no more documents.
+func (p *Decoder) Decode(obj interface{}) error {
+	document, err := p.DecodeRaw()
+	if err != nil {
+		return err
+	}
+
+	return p.parser.Unmarshal(document, obj)
+}
+
+// Writes BSON documents to a writer one after the other
+type Encoder struct {
+	writer          io.Writer
+	parser          *BSON
+	buffer          []byte
+	maxDocumentSize int
+}
+
+func NewEncoder(writer io.Writer) *Encoder {
+	return NewBSON().NewEncoder(writer)
+}
+
+// Create an Encoder using the type cache and settings of the parser
+func (p *BSON) NewEncoder(writer io.Writer) *Encoder {
+	return &Encoder{writer, p, nil, DefaultMaxDocumentSize}
+}
+
+// Set the size above which documents are rejected without being written
+func (p *Encoder) SetMaxDocumentSize(size int) {
+	p.maxDocumentSize = size
+}
+
+// Encode doc and write it, a RawDocument is validated and written as is
+func (p *Encoder) Encode(doc interface{}) error {
+	var b []byte
+
+	if raw, ok := doc.(RawDocument); ok {
+		err := raw.Validate()
+		if err != nil {
+			return err
+		}
+
+		b = raw
+	} else {
+		var err error
+		b, err = p.parser.Marshall(doc, p.buffer, 0)
+		if err != nil {
+			return err
+		}
+
+		// Keep the buffer, it may have grown
+		p.buffer = b[:cap(b)]
+	}
+
+	if len(b) > p.maxDocumentSize {
+		return errors.New(fmt.Sprintf("document size %v exceeds the maximum document size %v", len(b), p.maxDocumentSize))
+	}
+
+	_, err := p.writer.Write(b)
+	return err
+}
@@ -0,0 +1,166 @@
+package mongo
+
+import (
+	"bytes"
+	"gopkg.in/mgo.v2/bson"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestEncoderAndDecoder(t *testing.T) {
+	type Item struct {
+		Name  string  `bson:"name"`
+		Count int64   `bson:"count"`
+		Price float64 `bson:"price"`
+	}
+
+	document := NewDocument()
+	document.Add("name", "document")
+
+	raw, err := bson.Marshal(bson.M{"name": "raw"})
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	// Documents are written one after the other
+	var buffer bytes.Buffer
+	encoder := NewEncoder(&buffer)
+	for _, doc := range []interface{}{
+		&Item{"first", 1, 1.5},
+		&Item{"second with a longer name", 1 << 40, 2.5},
+		document,
+		map[string]interface{}{"name": "map"},
+		RawDocument(raw),
+	} {
+		if err := encoder.Encode(doc); err != nil {
+			t.Fatalf("failed to encode %v %v", doc, err)
+		}
+	}
+
+	// Matches mgo writing the same documents
+	var expected bytes.Buffer
+	for _, doc := range []interface{}{
+		&Item{"first", 1, 1.5},
+		&Item{"second with a longer name", 1 << 40, 2.5},
+		bson.M{"name": "document"},
+		bson.M{"name": "map"},
+		bson.M{"name": "raw"},
+	} {
+		b, _ := bson.Marshal(doc)
+		expected.Write(b)
+	}
+
+	if !bytes.Equal(buffer.Bytes(), expected.Bytes()) {
+		t.Fatalf("expected %v got %v", expected.Bytes(), buffer.Bytes())
+	}
+
+	// Read them back into the different targets
+	decoder := NewDecoder(&buffer)
+	first := &Item{}
+	second := &Item{}
+	for _, item := range []*Item{first, second} {
+		if err := decoder.Decode(item); err != nil {
+			t.Fatalf("failed to decode %v", err)
+		}
+	}
+
+	// Values do not share the reused buffer
+	if !reflect.DeepEqual(first, &Item{"first", 1, 1.5}) || !reflect.DeepEqual(second, &Item{"second with a longer name", 1 << 40, 2.5}) {
+		t.Errorf("unexpected items %+v %+v", first, second)
+	}
+
+	result := NewDocument()
+	if err := decoder.Decode(result); err != nil || !result.Equal(document) {
+		t.Errorf("expected %v got %v %v", document, result, err)
+	}
+
+	values := make(map[string]interface{})
+	if err := decoder.Decode(values); err != nil || values["name"] != "map" {
+		t.Errorf("unexpected map %v %v", values, err)
+	}
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		t.Errorf("failed to decode into an interface %v", err)
+	} else if name, _ := value.(*Document).String("name"); name != "raw" {
+		t.Errorf("unexpected document %v", value)
+	}
+
+	if err := decoder.Decode(&value); err != io.EOF {
+		t.Errorf("expected io.EOF got %v", err)
+	}
+}
+
+func TestDecoderRaw(t *testing.T) {
+	first, _ := bson.Marshal(bson.M{"name": "a longer first document"})
+	second, _ := bson.Marshal(bson.M{"name": "short"})
+
+	decoder := NewDecoder(bytes.NewReader(append(append([]byte{}, first...), second...)))
+	document, err := decoder.DecodeRaw()
+	if err != nil || !bytes.Equal(document, first) {
+		t.Fatalf("expected %v got %v %v", first, document, err)
+	}
+
+	// The buffer is reused for smaller documents
+	next, err := decoder.DecodeRaw()
+	if err != nil || !bytes.Equal(next, second) {
+		t.Fatalf("expected %v got %v %v", second, next, err)
+	}
+
+	if &next[0] != &document[0] {
+		t.Errorf("expected the buffer to be reused")
+	}
+
+	if _, err := decoder.DecodeRaw(); err != io.EOF {
+		t.Errorf("expected io.EOF got %v", err)
+	}
+}
+
+func TestDecoderErrors(t *testing.T) {
+	b, _ := bson.Marshal(bson.M{"name": "value"})
+
+	// Truncated in the size or in the document
+	for _, size := range []int{2, 4, len(b) - 1} {
+		decoder := NewDecoder(bytes.NewReader(b[:size]))
+		if err := decoder.Decode(NewDocument()); err != io.ErrUnexpectedEOF {
+			t.Errorf("expected io.ErrUnexpectedEOF reading %v bytes got %v", size, err)
+		}
+	}
+
+	// Invalid and too large sizes are rejected before reading
+	decoder := NewDecoder(bytes.NewReader([]byte{4, 0, 0, 0, 0}))
+	if err := decoder.Decode(NewDocument()); err == nil {
+		t.Errorf("expected an error for a document size of 4")
+	}
+
+	decoder = NewDecoder(bytes.NewReader(b))
+	decoder.SetMaxDocumentSize(len(b) - 1)
+	if err := decoder.Decode(NewDocument()); err == nil {
+		t.Errorf("expected an error for a document over the maximum size")
+	}
+
+	// Corrupt documents are not decoded
+	corrupt := append([]byte{}, b...)
+	corrupt[len(corrupt)-1] = 1
+	decoder = NewDecoder(bytes.NewReader(corrupt))
+	if err := decoder.Decode(NewDocument()); err == nil {
+		t.Errorf("expected an error for a corrupt document")
+	}
+
+	// The encoder checks the size and raw documents
+	var buffer bytes.Buffer
+	encoder := NewEncoder(&buffer)
+	encoder.SetMaxDocumentSize(len(b) - 1)
+	if err := encoder.Encode(bson.M{"name": "value"}); err == nil {
+		t.Errorf("expected an error for a document over the maximum size")
+	}
+
+	if err := NewEncoder(&buffer).Encode(RawDocument(corrupt)); err == nil {
+		t.Errorf("expected an error for a corrupt raw document")
+	}
+
+	if buffer.Len() != 0 {
+		t.Errorf("expected nothing to be written got %v", buffer.Bytes())
+	}
+}
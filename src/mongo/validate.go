@@ -0,0 +1,225 @@
+package mongo
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// Describes where and why a BSON document is invalid
+type ValidationError struct {
+	Offset  int
+	Path    string
+	Message string
+}
+
+func (p *ValidationError) Error() string {
+	if p.Path == "" {
+		return fmt.Sprintf("invalid BSON at offset %v: %v", p.Offset, p.Message)
+	}
+
+	return fmt.Sprintf("invalid BSON at offset %v in field %v: %v", p.Offset, p.Path, p.Message)
+}
+
+func newValidationError(offset int, path string, format string, args ...interface{}) error {
+	return &ValidationError{offset, path, fmt.Sprintf(format, args...)}
+}
+
+// Join a field name to the path of its parent document
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// Validate a BSON document, checking every length prefix, terminator and
+// type so it can be decoded without reading outside of the buffer
+func Validate(bson []byte) error {
+	end, err := validateDocument(bson, 0, len(bson), "")
+	if err != nil {
+		return err
+	}
+
+	if end != len(bson) {
+		return newValidationError(0, "", "document size %v does not match the %v bytes available", end, len(bson))
+	}
+
+	return nil
+}
+
+// Validate the document starting at index that must end before limit,
+// returns the index after the document
+func validateDocument(bson []byte, index int, limit int, path string) (int, error) {
+	if limit-index < 5 {
+		return index, newValidationError(index, path, "expected a document of at least 5 bytes, %v available", limit-index)
+	}
+
+	size := int(int32(readUInt32(bson, index)))
+	if size < 5 {
+		return index, newValidationError(index, path, "document size %v is smaller than the minimum size of 5", size)
+	}
+
+	if size > limit-index {
+		return index, newValidationError(index, path, "document size %v exceeds the %v bytes available", size, limit-index)
+	}
+
+	end := index + size
+	if bson[end-1] != 0 {
+		return index, newValidationError(end-1, path, "expected the document terminator 0x00 got 0x%02x", bson[end-1])
+	}
+
+	// Validate the elements, they can not overlap the terminator
+	elementIndex := index + 4
+	for elementIndex < end-1 {
+		bsonType := bson[elementIndex]
+
+		key, valueIndex, err := validateCString(bson, elementIndex+1, end-1, path)
+		if err != nil {
+			return index, err
+		}
+
+		elementIndex, err = validateValue(bson, valueIndex, end-1, bsonType, joinPath(path, key))
+		if err != nil {
+			return index, err
+		}
+	}
+
+	return end, nil
+}
+
+// Validate a null terminated string ending before limit
+func validateCString(bson []byte, index int, limit int, path string) (string, int, error) {
+	for i := index; i < limit; i++ {
+		if bson[i] == 0 {
+			return string(bson[index:i]), i + 1, nil
+		}
+	}
+
+	return "", index, newValidationError(index, path, "cstring is not null terminated before offset %v", limit)
+}
+
+// Validate a length prefixed UTF-8 string ending before limit
+func validateString(bson []byte, index int, limit int, path string) (int, error) {
+	if limit-index < 4 {
+		return index, newValidationError(index, path, "expected a 4 byte string length, %v bytes available", limit-index)
+	}
+
+	size := int(int32(readUInt32(bson, index)))
+	if size < 1 {
+		return index, newValidationError(index, path, "string length %v is smaller than the minimum of 1", size)
+	}
+
+	if size > limit-index-4 {
+		return index, newValidationError(index, path, "string length %v exceeds the %v bytes available", size, limit-index-4)
+	}
+
+	end := index + 4 + size
+	if bson[end-1] != 0 {
+		return index, newValidationError(end-1, path, "expected the string terminator 0x00 got 0x%02x", bson[end-1])
+	}
+
+	if !utf8.Valid(bson[index+4 : end-1]) {
+		return index, newValidationError(index+4, path, "string is not valid UTF-8")
+	}
+
+	return end, nil
+}
+
+// Validate a value of the given type ending before limit, returns the
+// index after the value
+func validateValue(bson []byte, index int, limit int, bsonType byte, path string) (int, error) {
+	size := 0
+
+	switch bsonType {
+	case byte(bsonUndefined), byte(bsonNull), byte(bsonMinKey), byte(bsonMaxKey):
+		return index, nil
+	case byte(bsonBoolean):
+		size = 1
+		if index < limit && bson[index] > 1 {
+			return index, newValidationError(index, path, "expected a boolean value of 0 or 1 got %v", bson[index])
+		}
+	case byte(bsonInt32):
+		size = 4
+	case byte(bsonDouble), byte(bsonDateTime), byte(bsonTimestamp), byte(bsonInt64):
+		size = 8
+	case byte(bsonObjectId):
+		size = 12
+	case byte(bsonDecimal128):
+		size = 16
+	case byte(bsonString), byte(bsonJavaScript), byte(bsonSymbol):
+		return validateString(bson, index, limit, path)
+	case byte(bsonDBPointer):
+		end, err := validateString(bson, index, limit, path)
+		if err != nil {
+			return index, err
+		}
+
+		// The namespace is followed by an ObjectId
+		return validateValue(bson, end, limit, byte(bsonObjectId), path)
+	case byte(bsonDocument), byte(bsonArray):
+		return validateDocument(bson, index, limit, path)
+	case byte(bsonBinary):
+		if limit-index < 5 {
+			return index, newValidationError(index, path, "expected a 5 byte binary header, %v bytes available", limit-index)
+		}
+
+		binarySize := int(int32(readUInt32(bson, index)))
+		if binarySize < 0 || binarySize > limit-index-5 {
+			return index, newValidationError(index, path, "binary length %v is invalid, %v bytes available", binarySize, limit-index-5)
+		}
+
+		// The old binary subtype wraps the data in a second length
+		if bson[index+4] == 0x02 {
+			if binarySize < 4 || int(int32(readUInt32(bson, index+5))) != binarySize-4 {
+				return index, newValidationError(index+5, path, "old binary length does not match the binary length %v", binarySize)
+			}
+		}
+
+		return index + 5 + binarySize, nil
+	case byte(bsonRegExp):
+		// Pattern and options
+		_, end, err := validateCString(bson, index, limit, path)
+		if err != nil {
+			return index, err
+		}
+
+		_, end, err = validateCString(bson, end, limit, path)
+		return end, err
+	case byte(bsonJavaScriptWScope):
+		if limit-index < 4 {
+			return index, newValidationError(index, path, "expected a 4 byte javascript with scope length, %v bytes available", limit-index)
+		}
+
+		// Total size, code and scope
+		totalSize := int(int32(readUInt32(bson, index)))
+		if totalSize < 14 || totalSize > limit-index {
+			return index, newValidationError(index, path, "javascript with scope length %v is invalid, %v bytes available", totalSize, limit-index)
+		}
+
+		end := index + totalSize
+		scopeIndex, err := validateString(bson, index+4, end, path)
+		if err != nil {
+			return index, err
+		}
+
+		scopeEnd, err := validateDocument(bson, scopeIndex, end, joinPath(path, "$scope"))
+		if err != nil {
+			return index, err
+		}
+
+		if scopeEnd != end {
+			return index, newValidationError(index, path, "javascript with scope length %v does not match its contents of %v bytes", totalSize, scopeEnd-index)
+		}
+
+		return end, nil
+	default:
+		return index, newValidationError(index, path, "unknown BSON type 0x%02x", bsonType)
+	}
+
+	if size > limit-index {
+		return index, newValidationError(index, path, "expected %v bytes for BSON type 0x%02x, %v available", size, bsonType, limit-index)
+	}
+
+	return index + size, nil
+}
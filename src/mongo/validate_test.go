@@ -0,0 +1,104 @@
+package mongo
+
+import (
+	"testing"
+)
+
+func TestValidateCorpus(t *testing.T) {
+	parser := NewBSON()
+
+	for file, tests := range loadBSONCorpus(t, "*.json") {
+		for _, test := range tests.Valid {
+			if err := Validate(decodeHex(t, test.CanonicalBson)); err != nil {
+				t.Errorf("%s %s: unexpected validation error %v", file, test.Description, err)
+			}
+		}
+
+		for _, test := range tests.DecodeErrors {
+			b := decodeHex(t, test.Bson)
+
+			if err := Validate(b); err == nil {
+				t.Errorf("%s %s: expected a validation error", file, test.Description)
+			}
+
+			if err := parser.Unmarshal(b, NewDocument()); err == nil {
+				t.Errorf("%s %s: expected a decoding error", file, test.Description)
+			}
+		}
+	}
+}
+
+func TestValidationError(t *testing.T) {
+	// {a: {b: "x"}} with the string length of b too long
+	b := []byte{
+		22, 0, 0, 0,
+		0x03, 'a', 0,
+		14, 0, 0, 0,
+		0x02, 'b', 0, 10, 0, 0, 0, 'x', 0,
+		0,
+		0,
+	}
+
+	err := Validate(b)
+	validationError, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("expected a validation error got %v", err)
+	}
+
+	if validationError.Offset != 14 || validationError.Path != "a.b" {
+		t.Errorf("unexpected offset %v and path %v", validationError.Offset, validationError.Path)
+	}
+
+	if err.Error() != "invalid BSON at offset 14 in field a.b: string length 10 exceeds the 2 bytes available" {
+		t.Errorf("unexpected error message %v", err)
+	}
+
+	// The decoder returns the same error
+	if err := NewBSON().Unmarshal(b, NewDocument()); err == nil || err.Error() != validationError.Error() {
+		t.Errorf("expected %v decoding got %v", validationError, err)
+	}
+
+	// Raw values are checked before decoding
+	var value string
+	if err := (Raw{byte(bsonString), []byte{10, 0, 0, 0, 'x', 0}}).Unmarshal(&value); err == nil {
+		t.Errorf("expected an error decoding a corrupt raw string")
+	}
+}
+
+// Decoding corrupt documents returns an error instead of panicking
+func FuzzUnmarshal(f *testing.F) {
+	for _, tests := range loadBSONCorpus(f, "*.json") {
+		for _, test := range tests.Valid {
+			f.Add(decodeHex(f, test.CanonicalBson))
+		}
+
+		for _, test := range tests.DecodeErrors {
+			f.Add(decodeHex(f, test.Bson))
+		}
+	}
+
+	type T struct {
+		Name  string                 `bson:"name"`
+		Count int64                  `bson:"count"`
+		Tags  []string               `bson:"tags"`
+		Inner map[string]interface{} `bson:"inner"`
+	}
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		parser := NewBSON()
+		err := parser.Unmarshal(b, NewDocument())
+		if err == nil && Validate(b) != nil {
+			t.Errorf("decoded a document that does not validate")
+		}
+
+		parser.Unmarshal(b, &T{})
+		parser.Unmarshal(b, make(map[string]interface{}))
+
+		var value interface{}
+		parser.Unmarshal(b, &value)
+
+		// Lookups and iteration
+		RawDocument(b).Lookup("name.a")
+		RawDocument(b).Elements()
+	})
+}
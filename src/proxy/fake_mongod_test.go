@@ -0,0 +1,475 @@
+package proxy
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Number of documents returned per batch by the fake servers
+const fakeBatchSize = 2
+
+// Operation received by a fake server
+type fakeOperation struct {
+	OpCode     int32
+	Collection string
+	Command    string
+}
+
+// In process stand in for a mongod replicaset member or a mongos router
+// speaking the legacy wire protocol
+type fakeMongod struct {
+	Address     string
+	Tags        bson.D
+	Mongos      bool
+	set         *fakeReplSet
+	listener    net.Listener
+	operations  []fakeOperation
+	connections []net.Conn
+	open        int
+	collections map[string][]interface{}
+	cursors     map[int64][]interface{}
+	cursorId    int64
+	mutex       sync.Mutex
+}
+
+// Group of fake servers forming a replicaset
+type fakeReplSet struct {
+	Name    string
+	Members []*fakeMongod
+	primary *fakeMongod
+	mutex   sync.Mutex
+}
+
+func startFakeMongod(t *testing.T) *fakeMongod {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+
+	server := &fakeMongod{
+		Address:     ln.Addr().String(),
+		listener:    ln,
+		collections: make(map[string][]interface{}),
+		cursors:     make(map[int64][]interface{}),
+	}
+
+	t.Cleanup(server.Close)
+	go server.accept()
+	return server
+}
+
+// Start a replicaset of fake servers, the first member is the primary
+func startFakeReplSet(t *testing.T, members int) *fakeReplSet {
+	set := &fakeReplSet{Name: "rs"}
+
+	for i := 0; i < members; i++ {
+		server := startFakeMongod(t)
+		server.set = set
+		set.Members = append(set.Members, server)
+	}
+
+	set.primary = set.Members[0]
+	return set
+}
+
+// Connection uri listing all the members
+func (p *fakeReplSet) Uri() string {
+	addresses := make([]string, len(p.Members))
+	for i, member := range p.Members {
+		addresses[i] = member.Address
+	}
+
+	return "mongodb://" + strings.Join(addresses, ",") + "/admin"
+}
+
+func (p *fakeReplSet) Primary() *fakeMongod {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.primary
+}
+
+// Simulate the primary stepping down in favour of member, dropping all
+// client connections like a real stepdown does
+func (p *fakeReplSet) StepDown(member *fakeMongod) {
+	p.mutex.Lock()
+	p.primary = member
+	p.mutex.Unlock()
+
+	for _, server := range p.Members {
+		server.CloseConnections()
+	}
+}
+
+// Load a collection returned by finds through canned cursors
+func (p *fakeMongod) SetCollection(namespace string, documents ...interface{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.collections[namespace] = documents
+}
+
+// Operations received so far
+func (p *fakeMongod) Operations() []fakeOperation {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return append([]fakeOperation{}, p.operations...)
+}
+
+// Has the server received an operation that is not a topology check
+func (p *fakeMongod) Received(opCode int32, collection string) bool {
+	for _, operation := range p.Operations() {
+		if operation.OpCode == opCode && operation.Collection == collection {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Number of client connections that have not been closed yet
+func (p *fakeMongod) OpenConnections() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.open
+}
+
+func (p *fakeMongod) CloseConnections() {
+	p.mutex.Lock()
+	connections := p.connections
+	p.connections = nil
+	p.mutex.Unlock()
+
+	for _, conn := range connections {
+		conn.Close()
+	}
+}
+
+func (p *fakeMongod) Close() {
+	p.listener.Close()
+	p.CloseConnections()
+}
+
+func (p *fakeMongod) accept() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		p.mutex.Lock()
+		p.connections = append(p.connections, conn)
+		p.open = p.open + 1
+		p.mutex.Unlock()
+
+		go p.serve(conn)
+	}
+}
+
+func (p *fakeMongod) serve(conn net.Conn) {
+	defer conn.Close()
+	defer func() {
+		p.mutex.Lock()
+		p.open = p.open - 1
+		p.mutex.Unlock()
+	}()
+
+	for {
+		_, wireMessage, err := readWireMessage(conn)
+		if err != nil {
+			return
+		}
+
+		reply := p.handle(wireMessage)
+		if reply == nil {
+			continue
+		}
+
+		_, err = conn.Write(append(addInt32(nil, int32(len(reply)+4)), reply...))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Process a single message returning the reply if there is one
+func (p *fakeMongod) handle(wireMessage []byte) []byte {
+	requestId := readInt32(wireMessage[0:4])
+	opCode := readInt32(wireMessage[8:12])
+
+	switch opCode {
+	case OP_QUERY:
+		query, err := parseQuery(wireMessage)
+		if err != nil {
+			return newReplyMessage(requestId, bson.M{"ok": 0, "errmsg": err.Error()})
+		}
+
+		if query.IsCommand() {
+			command := bson.M{}
+			bson.Unmarshal(query.Query, command)
+			if wrapped, ok := command["$query"].(bson.M); ok {
+				command = wrapped
+			}
+
+			name := commandName(query.Query)
+			p.record(fakeOperation{opCode, query.Collection, name})
+			return newReplyMessage(requestId, p.command(query.Database(), name, command))
+		}
+
+		// Legacy query returning a canned cursor
+		p.record(fakeOperation{opCode, query.Collection, ""})
+		cursorId, batch := p.openCursor(query.Collection)
+		return newCursorReplyMessage(requestId, cursorId, batch...)
+	case OP_GET_MORE:
+		collection, cursorId, _ := parseGetMore(wireMessage)
+		p.record(fakeOperation{opCode, collection, ""})
+		cursorId, batch := p.nextBatch(cursorId)
+		return newCursorReplyMessage(requestId, cursorId, batch...)
+	case OP_INSERT, OP_UPDATE, OP_DELETE:
+		collection, _, _ := readCString(wireMessage, 16)
+		p.record(fakeOperation{opCode, collection, ""})
+	case OP_KILL_CURSORS:
+		cursorIds, _ := parseKillCursors(wireMessage)
+		p.record(fakeOperation{opCode, "", ""})
+
+		p.mutex.Lock()
+		for _, cursorId := range cursorIds {
+			delete(p.cursors, cursorId)
+		}
+		p.mutex.Unlock()
+	}
+
+	return nil
+}
+
+func (p *fakeMongod) record(operation fakeOperation) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.operations = append(p.operations, operation)
+}
+
+// Execute a command returning the reply document
+func (p *fakeMongod) command(database string, name string, command bson.M) interface{} {
+	switch name {
+	case "ismaster", "isMaster":
+		return p.isMaster()
+	case "ping":
+		return bson.M{"ok": 1}
+	case "getnonce":
+		// Requested by mgo on every new socket
+		return bson.M{"nonce": "2375531c32080ae8", "ok": 1}
+	case "find":
+		collection, _ := command["find"].(string)
+		cursorId, batch := p.openCursor(database + "." + collection)
+		// Ordered so the replies of two servers have the same shape
+		return bson.D{{Name: "cursor", Value: bson.M{"id": cursorId, "ns": database + "." + collection, "firstBatch": batch}}, {Name: "ok", Value: 1}}
+	case "getMore":
+		cursorId, _ := command["getMore"].(int64)
+		collection, _ := command["collection"].(string)
+		cursorId, batch := p.nextBatch(cursorId)
+		return bson.M{"cursor": bson.M{"id": cursorId, "ns": database + "." + collection, "nextBatch": batch}, "ok": 1}
+	case "insert":
+		documents, _ := command["documents"].([]interface{})
+		return bson.M{"n": len(documents), "ok": 1}
+	case "replSetStepDown":
+		// Hand over to the next member
+		if p.set != nil {
+			for i, member := range p.set.Members {
+				if member == p {
+					go p.set.StepDown(p.set.Members[(i+1)%len(p.set.Members)])
+				}
+			}
+		}
+
+		return bson.M{"ok": 1}
+	}
+
+	return bson.M{"ok": 0, "errmsg": "no such command: " + name}
+}
+
+func (p *fakeMongod) isMaster() interface{} {
+	result := bson.M{
+		"maxBsonObjectSize":   16 * 1024 * 1024,
+		"maxMessageSizeBytes": 48000000,
+		"maxWireVersion":      3,
+		"localTime":           time.Now(),
+		"ok":                  1,
+	}
+
+	if p.Mongos {
+		result["ismaster"] = true
+		result["msg"] = "isdbgrid"
+		return result
+	}
+
+	if p.set == nil {
+		result["ismaster"] = true
+		return result
+	}
+
+	primary := p.set.Primary()
+	hosts := make([]string, len(p.set.Members))
+	for i, member := range p.set.Members {
+		hosts[i] = member.Address
+	}
+
+	result["ismaster"] = primary == p
+	result["secondary"] = primary != p
+	result["setName"] = p.set.Name
+	result["hosts"] = hosts
+	result["primary"] = primary.Address
+	result["me"] = p.Address
+
+	if len(p.Tags) > 0 {
+		result["tags"] = p.Tags
+	}
+
+	return result
+}
+
+// Open a cursor over the collection returning the first batch
+func (p *fakeMongod) openCursor(namespace string) (int64, []interface{}) {
+	p.mutex.Lock()
+	documents := p.collections[namespace]
+	p.cursorId = p.cursorId + 1
+	cursorId := p.cursorId
+	p.cursors[cursorId] = documents
+	p.mutex.Unlock()
+
+	return p.nextBatch(cursorId)
+}
+
+// Return the next batch of the cursor, the cursor id is 0 once exhausted
+func (p *fakeMongod) nextBatch(cursorId int64) (int64, []interface{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	documents, ok := p.cursors[cursorId]
+	if !ok {
+		return 0, []interface{}{}
+	}
+
+	if len(documents) <= fakeBatchSize {
+		delete(p.cursors, cursorId)
+		return 0, append([]interface{}{}, documents...)
+	}
+
+	p.cursors[cursorId] = documents[fakeBatchSize:]
+	return cursorId, documents[:fakeBatchSize]
+}
+
+// Create an OP_REPLY wire message with a cursor id without the size prefix
+func newCursorReplyMessage(responseTo int32, cursorId int64, documents ...interface{}) []byte {
+	message := newReplyMessage(responseTo, documents...)
+	copy(message[16:24], addInt64(nil, cursorId))
+	return message
+}
+
+// Create an OP_GET_MORE wire message without the size prefix
+func newGetMoreMessage(requestId int32, collection string, cursorId int64) []byte {
+	message := make([]byte, 0)
+	message = addInt32(message, requestId)
+	message = addInt32(message, 0)
+	message = addInt32(message, OP_GET_MORE)
+	message = addInt32(message, 0)
+	message = append(message, []byte(collection)...)
+	message = append(message, 0)
+	message = addInt32(message, 0)
+	return addInt64(message, cursorId)
+}
+
+// Create an OP_INSERT wire message without the size prefix
+func newInsertMessage(requestId int32, collection string, documents ...interface{}) []byte {
+	message := make([]byte, 0)
+	message = addInt32(message, requestId)
+	message = addInt32(message, 0)
+	message = addInt32(message, OP_INSERT)
+	message = addInt32(message, 0)
+	message = append(message, []byte(collection)...)
+	message = append(message, 0)
+
+	for _, document := range documents {
+		data, err := bson.Marshal(document)
+		if err != nil {
+			panic(err)
+		}
+
+		message = append(message, data...)
+	}
+
+	return message
+}
+
+// Client side of a connection to the proxy
+type fakeClient struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+// Connect a client to a proxy handler running over an in memory pipe
+func newFakeClient(t *testing.T, handler func(conn net.Conn)) *fakeClient {
+	client, server := net.Pipe()
+	go handler(server)
+	t.Cleanup(func() { client.Close() })
+	return &fakeClient{t, client}
+}
+
+// Send a message to the proxy
+func (p *fakeClient) Send(wireMessage []byte) {
+	p.conn.SetDeadline(time.Now().Add(10 * time.Second))
+	_, err := p.conn.Write(append(addInt32(nil, int32(len(wireMessage)+4)), wireMessage...))
+	if err != nil {
+		p.t.Fatalf("failed to send message %v", err)
+	}
+}
+
+// Send a message to the proxy and wait for the reply
+func (p *fakeClient) Request(wireMessage []byte) *replyMessage {
+	p.Send(wireMessage)
+
+	_, responseMessage, err := readWireMessage(p.conn)
+	if err != nil {
+		p.t.Fatalf("failed to read reply %v", err)
+	}
+
+	reply, err := parseReply(responseMessage)
+	if err != nil {
+		p.t.Fatalf("failed to parse reply %v", err)
+	}
+
+	return reply
+}
+
+// Decode the documents of a reply
+func replyDocuments(t *testing.T, reply *replyMessage) []bson.M {
+	documents := make([]bson.M, 0)
+	data := reply.Documents
+
+	for len(data) > 0 {
+		size := int(readInt32(data))
+		document := bson.M{}
+		err := bson.Unmarshal(data[:size], document)
+		if err != nil {
+			t.Fatalf("failed to decode reply document %v", err)
+		}
+
+		documents = append(documents, document)
+		data = data[size:]
+	}
+
+	return documents
+}
+
+// Wait for the condition to become true
+func waitFor(t *testing.T, description string, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", description)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
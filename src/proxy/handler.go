@@ -1,8 +1,9 @@
 package proxy
 
 import (
-	"bytes"
-	"gopkg.in/mgo.v2/bson"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"time"
@@ -17,24 +18,20 @@ const OP_GET_MORE = 2005
 const OP_DELETE = 2006
 const OP_KILL_CURSORS = 2007
 
+// The names are the lower cased field names mgo uses by default
 type isMasterResult struct {
-	Ok                  int
-	IsMaster            bool
-	Secondary           bool     `bson:",omitempty"`
-	Primary             string   `bson:",omitempty"`
-	Hosts               []string `bson:",omitempty"`
-	Passives            []string `bson:",omitempty"`
-	Tags                bson.D   `bson:",omitempty"`
-	Msg                 string   `bson:",omitempty"`
-	MaxMessageSizeBytes int
-	MaxWireVersion      int `bson:"maxWireVersion"`
-	MaxBsonObjectSize   int
-	LocalTime           time.Time
-}
-
-type readPreference struct {
-	Mode string
-	Tags []map[string]string
+	Ok                  int               `bson:"ok"`
+	IsMaster            bool              `bson:"ismaster"`
+	Secondary           bool              `bson:"secondary,omitempty"`
+	Primary             string            `bson:"primary,omitempty"`
+	Hosts               []string          `bson:"hosts,omitempty"`
+	Passives            []string          `bson:"passives,omitempty"`
+	Tags                map[string]string `bson:"tags,omitempty"`
+	Msg                 string            `bson:"msg,omitempty"`
+	MaxMessageSizeBytes int               `bson:"maxmessagesizebytes"`
+	MaxWireVersion      int               `bson:"maxWireVersion"`
+	MaxBsonObjectSize   int               `bson:"maxbsonobjectsize"`
+	LocalTime           time.Time         `bson:"localtime"`
 }
 
 type ServerConnection struct {
@@ -66,13 +63,21 @@ func addInt64(b []byte, i int64) []byte {
 		byte(i>>32), byte(i>>40), byte(i>>48), byte(i>>56))
 }
 
-func HandleConnection(set *ReplSet, conn net.Conn) {
-	var isMasterBytes = []byte("isMaster")
-	var ismasterBytes = []byte("ismaster")
-	var readPreferenceBytes = []byte("$readPreference")
-	var readPreferencebytes = []byte("$readpreference")
-	var connection net.Conn
+// The ismaster response the proxy returns to drivers, presenting
+// itself as a mongos so drivers do not attempt any monitoring
+func newIsMasterResponse(isMaster *isMasterResult) *isMasterResult {
+	return &isMasterResult{
+		Ok:                  1,
+		IsMaster:            true,
+		MaxMessageSizeBytes: isMaster.MaxMessageSizeBytes,
+		MaxBsonObjectSize:   isMaster.MaxBsonObjectSize,
+		Msg:                 "isdbgrid",
+		MaxWireVersion:      isMaster.MaxWireVersion,
+		LocalTime:           isMaster.LocalTime,
+	}
+}
 
+func HandleConnection(set *ReplSet, conn net.Conn) {
 	// Set up socket connections
 	addresses := set.Session.LiveServers()
 	masters := set.Session.LiveMasters()
@@ -101,10 +106,16 @@ func HandleConnection(set *ReplSet, conn net.Conn) {
 
 	isMaster, err := updateContext(context, set.Session, set.Timeout)
 	if err != nil {
-		log.Fatalf("failed to update the world %s", err)
+		log.Printf("failed to update the world %s", err)
 		return
 	}
 
+	// Identify the connection in the recording
+	var connectionId uint64
+	if set.Recorder != nil {
+		connectionId = set.Recorder.NewConnectionId()
+	}
+
 	// // For each entry open a tcp connection
 	// for _, addr := range addresses {
 	// 	socket, err := net.DialTimeout("tcp", addr, duration)
@@ -125,29 +136,20 @@ func HandleConnection(set *ReplSet, conn net.Conn) {
 
 	// Start reading of messages
 	for {
-		messageSizeBytes := make([]byte, 4)
-		n, err := conn.Read(messageSizeBytes)
+		messageSizeBytes, wireMessage, err := readWireMessage(conn)
 
 		// We have an error, close socket and return
-		if err != nil || int32(n) != 4 {
-			log.Printf("failed to read enough bytes to establish message size from connection %v", err)
+		if err != nil {
+			log.Printf("failed to read wire protocol message from connection %v", err)
 			break
 		}
 
-		// Get the message size
-		messageSize := readInt32(messageSizeBytes)
-
-		// We know the size of the message, read the entire message into memory
-		wireMessage := make([]byte, messageSize-4)
-		n, err = conn.Read(wireMessage)
-
-		// We had an error during the reading of the message, close socket and return
-		if err != nil || int32(n) != (messageSize-4) {
-			log.Printf("failed to read enough bytes for wire protocol message from connection %v", err)
-			break
+		log.Printf("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
+		// Record the client request
+		if set.Recorder != nil {
+			set.Recorder.Record(RECORD_REQUEST, connectionId, wireMessage)
 		}
 
-		log.Printf("@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@")
 		// Update our view of the world to match the one from the mgo driver
 		err = updateWorldView(context, set.Session, set.Timeout)
 		if err != nil {
@@ -155,117 +157,109 @@ func HandleConnection(set *ReplSet, conn net.Conn) {
 			break
 		}
 
-		// Let's unpack the wire message header
-		opCode := readInt32(wireMessage[8:12])
-		// Get possible indexes
-		index := -1
-		index1 := bytes.Index(wireMessage, readPreferenceBytes)
-		index2 := bytes.Index(wireMessage, readPreferencebytes)
-		if index1 != -1 {
-			index = index1
-		} else if index2 != -1 {
-			index = index2
+		// Route the message to the replicaset
+		start := time.Now()
+		responseMessage, err := processMessage(context, isMaster, conn, messageSizeBytes, wireMessage)
+		if err != nil {
+			log.Printf("%v", err)
+			break
 		}
 
-		// Default to primary
-		connection = context.Primary.Connection
+		// Record the reply
+		if set.Recorder != nil && responseMessage != nil {
+			set.Recorder.Record(RECORD_REPLY, connectionId, responseMessage)
+		}
 
-		// Look for readPreference provided by client in the message
-		if index != -1 {
-			log.Printf("server requesting read preference from replicaset")
-			// We know that infront of a bson object (which $readPreference is)
-			// there is the field type 1 byte
-			// and the total object length 4 bytes
-			// let's get the bson object
-			readPreferenceSize := readInt32(wireMessage[index+len(readPreferenceBytes)+1:])
-			// Get the readpreference bson doc and deserialize it
-			readPrefBytes := wireMessage[index+len(readPreferenceBytes)+1 : index+len(readPreferenceBytes)+1+int(readPreferenceSize)]
-			// Read Preference
-			readPref := &readPreference{}
-			// Unmarshal
-			err = bson.Unmarshal(readPrefBytes, readPref)
-			if err != nil {
-				log.Printf("failed to deserialize the readPreference object %v", err)
-				return
-			}
-
-			// Print the read preference
-			// log.Printf("readPreference : %+v", readPref)
-			// If we have secondary read preference
-			if (readPref.Mode == "secondary" ||
-				readPref.Mode == "secondaryPreferred" ||
-				readPref.Mode == "nearest") && len(context.Secondaries) > 0 {
-				log.Printf("execute operation against secondary")
-				connection = context.Secondaries[0].Connection
-			}
+		// Duplicate the operation to the shadow replicaset
+		if set.Mirror != nil {
+			set.Mirror.Submit(messageSizeBytes, wireMessage, time.Since(start), responseMessage)
 		}
+	}
+}
+
+// Route a single client message to the right member of the replicaset
+// and write any reply back to the client. Returns the reply (without the
+// size prefix) if the operation produced one
+func processMessage(context *ConnectionContext, isMaster *isMasterResult, conn io.Writer, messageSizeBytes []byte, wireMessage []byte) ([]byte, error) {
+	// Let's unpack the wire message header
+	opCode := readInt32(wireMessage[8:12])
+	connection := selectConnection(context, wireMessage)
+
+	// Determine if this is the ismaster command from a driver
+	if isIsMaster(wireMessage) {
+
+		// Header fields
+		requestId := wireMessage[0:4]
 
-		// Determine if this is the ismaster command from a driver
-		if bytes.Index(wireMessage, isMasterBytes) != -1 || bytes.Index(wireMessage, ismasterBytes) != -1 {
-
-			// Header fields
-			requestId := wireMessage[0:4]
-
-			// Create command
-			var ismasterCmd = &isMasterResult{
-				Ok:                  1,
-				IsMaster:            true,
-				MaxMessageSizeBytes: isMaster.MaxMessageSizeBytes,
-				MaxBsonObjectSize:   isMaster.MaxBsonObjectSize,
-				Msg:                 "isdbgrid",
-				MaxWireVersion:      isMaster.MaxWireVersion,
-				LocalTime:           isMaster.LocalTime,
-			}
-
-			ismasterCommandBytes, err := CreateResponseMessage(requestId, ismasterCmd)
-			if err != nil {
-				log.Printf("failed to create ismaster command %v", err)
-				break
-			}
-
-			// Write ismaster response
-			conn.Write(ismasterCommandBytes)
-			continue
+		// Create command
+		ismasterCmd := newIsMasterResponse(isMaster)
+
+		ismasterCommandBytes, err := CreateResponseMessage(requestId, ismasterCmd)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to create ismaster command %v", err))
 		}
 
-		// If it's write commands we need to direct it to the primary
-		if opCode == OP_INSERT || opCode == OP_UPDATE || opCode == OP_DELETE || opCode == OP_KILL_CURSORS {
-			connection.Write(messageSizeBytes)
-			connection.Write(wireMessage)
-		} else if opCode == OP_GET_MORE || opCode == OP_QUERY {
-			connection.Write(messageSizeBytes)
-			connection.Write(wireMessage)
-
-			// Read the response from the connection
-			responseMessageSizeBytes := make([]byte, 4)
-			n, err = connection.Read(responseMessageSizeBytes)
-
-			// We have an error, close socket and return
-			if err != nil || int32(n) != 4 {
-				log.Printf("failed to read enough bytes to establish message size from connection %v", err)
-				break
-			}
-
-			responseMessageSize := readInt32(responseMessageSizeBytes)
-			responseMessageBytes := make([]byte, responseMessageSize-4)
-
-			// Read the rest of the response
-			n, err = connection.Read(responseMessageBytes)
-
-			// We have an error, close socket and return
-			if err != nil || int32(n) != (responseMessageSize-4) {
-				log.Printf("failed to read enough bytes to establish message size from connection %v", err)
-				break
-			}
-
-			// Write message to initial connection
-			conn.Write(responseMessageSizeBytes)
-			conn.Write(responseMessageBytes)
-		} else {
-			log.Fatalf("opcode %v not supported", opCode)
-			break
+		// Write ismaster response
+		conn.Write(ismasterCommandBytes)
+		return ismasterCommandBytes[4:], nil
+	}
+
+	// No member available to serve the operation
+	if connection == nil {
+		return nil, errors.New(fmt.Sprintf("no server available for opcode %v", opCode))
+	}
+
+	// If it's write commands we need to direct it to the primary
+	if opCode == OP_INSERT || opCode == OP_UPDATE || opCode == OP_DELETE || opCode == OP_KILL_CURSORS {
+		connection.Write(messageSizeBytes)
+		connection.Write(wireMessage)
+	} else if opCode == OP_GET_MORE || opCode == OP_QUERY {
+		connection.Write(messageSizeBytes)
+		connection.Write(wireMessage)
+
+		// Read the response from the connection
+		responseMessageSizeBytes, responseMessageBytes, err := readWireMessage(connection)
+
+		// We have an error, close socket and return
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("failed to read response from server %v", err))
 		}
+
+		// Write message to initial connection
+		conn.Write(responseMessageSizeBytes)
+		conn.Write(responseMessageBytes)
+		return responseMessageBytes, nil
+	} else {
+		return nil, errors.New(fmt.Sprintf("opcode %v not supported", opCode))
 	}
+
+	return nil, nil
+}
+
+// The member connection an operation is sent to, the primary unless the
+// query has a read preference allowing a secondary. Returns nil if there
+// is no member available
+func selectConnection(context *ConnectionContext, wireMessage []byte) net.Conn {
+	var connection net.Conn
+
+	// Default to primary
+	if context.Primary != nil {
+		connection = context.Primary.Connection
+	}
+
+	// Look for readPreference provided by client in the query
+	if mode := readPreferenceMode(wireMessage); mode != "" {
+		log.Printf("server requesting read preference from replicaset")
+		// If we have secondary read preference
+		if (mode == "secondary" ||
+			mode == "secondaryPreferred" ||
+			mode == "nearest") && len(context.Secondaries) > 0 {
+			log.Printf("execute operation against secondary")
+			connection = context.Secondaries[0].Connection
+		}
+	}
+
+	return connection
 }
 
 func readInt32(b []byte) int32 {
@@ -0,0 +1,406 @@
+package proxy
+
+import (
+	"bytes"
+	"gopkg.in/mgo.v2/bson"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Listener port without a route
+const listenerPortForTest = 50000
+
+// Connect the proxy to a fake replicaset
+func startTestReplSet(t *testing.T, fake *fakeReplSet) *ReplSet {
+	set := NewReplSet(fake.Uri(), 10000)
+	err := set.Start()
+	if err != nil {
+		t.Fatalf("failed to connect to fake replicaset %v", err)
+	}
+
+	t.Cleanup(set.Session.Close)
+	return set
+}
+
+func newReplSetClient(t *testing.T, set *ReplSet) *fakeClient {
+	return newFakeClient(t, func(conn net.Conn) {
+		HandleConnection(set, conn)
+	})
+}
+
+func TestHandleConnectionIsMaster(t *testing.T) {
+	fake := startFakeReplSet(t, 2)
+	client := newReplSetClient(t, startTestReplSet(t, fake))
+
+	reply := client.Request(newQueryMessage(1, "admin.$cmd", bson.M{"ismaster": 1}))
+	if readInt32(reply.Documents) == 0 {
+		t.Fatalf("expected an ismaster document")
+	}
+
+	document := replyDocuments(t, reply)[0]
+	if document["ismaster"] != true || document["msg"] != "isdbgrid" || document["maxWireVersion"] != 3 {
+		t.Errorf("unexpected ismaster reply %v", document)
+	}
+
+	// The proxy hides the replicaset from the driver
+	if _, ok := document["setName"]; ok {
+		t.Errorf("ismaster reply exposes the replicaset %v", document)
+	}
+}
+
+func TestHandleConnectionReadPreference(t *testing.T) {
+	fake := startFakeReplSet(t, 2)
+	client := newReplSetClient(t, startTestReplSet(t, fake))
+	primary, secondary := fake.Members[0], fake.Members[1]
+
+	// Reads default to the primary
+	client.Request(newQueryMessage(1, "test.$cmd", bson.D{{Name: "find", Value: "users"}}))
+	if !primary.Received(OP_QUERY, "test.$cmd") || secondary.Received(OP_QUERY, "test.$cmd") {
+		t.Fatalf("expected the find to run against the primary")
+	}
+
+	// A secondary read preference goes to the secondary
+	client.Request(newQueryMessage(2, "test.$cmd", bson.D{
+		{Name: "$query", Value: bson.D{{Name: "find", Value: "users"}}},
+		{Name: "$readPreference", Value: bson.D{{Name: "mode", Value: "secondary"}}},
+	}))
+
+	if !secondary.Received(OP_QUERY, "test.$cmd") {
+		t.Errorf("expected the find to run against the secondary")
+	}
+}
+
+func TestHandleConnectionCursor(t *testing.T) {
+	fake := startFakeReplSet(t, 1)
+	fake.Members[0].SetCollection("test.users", bson.M{"a": 1}, bson.M{"a": 2}, bson.M{"a": 3})
+	client := newReplSetClient(t, startTestReplSet(t, fake))
+
+	// Legacy query returns the first batch
+	reply := client.Request(newQueryMessage(1, "test.users", bson.M{}))
+	if reply.CursorId == 0 || reply.NumberReturned != fakeBatchSize {
+		t.Fatalf("unexpected first batch cursor %v returned %v", reply.CursorId, reply.NumberReturned)
+	}
+
+	// Exhaust the cursor
+	reply = client.Request(newGetMoreMessage(2, "test.users", reply.CursorId))
+	if reply.CursorId != 0 || reply.NumberReturned != 1 {
+		t.Fatalf("unexpected last batch cursor %v returned %v", reply.CursorId, reply.NumberReturned)
+	}
+
+	if document := replyDocuments(t, reply)[0]; document["a"] != 3 {
+		t.Errorf("unexpected document %v", document)
+	}
+
+	// The find command returns a cursor in the reply document
+	reply = client.Request(newQueryMessage(3, "test.$cmd", bson.D{{Name: "find", Value: "users"}}))
+	cursor, _ := replyDocuments(t, reply)[0]["cursor"].(bson.M)
+	if cursor == nil || cursor["id"] == int64(0) || len(cursor["firstBatch"].([]interface{})) != fakeBatchSize {
+		t.Errorf("unexpected find reply %v", cursor)
+	}
+}
+
+func TestHandleConnectionWritesToPrimary(t *testing.T) {
+	fake := startFakeReplSet(t, 2)
+	client := newReplSetClient(t, startTestReplSet(t, fake))
+
+	// Legacy writes do not expect a reply
+	client.Send(newInsertMessage(1, "test.users", bson.M{"a": 1}))
+
+	waitFor(t, "insert on the primary", func() bool {
+		return fake.Members[0].Received(OP_INSERT, "test.users")
+	})
+
+	if fake.Members[1].Received(OP_INSERT, "test.users") {
+		t.Errorf("insert executed against the secondary")
+	}
+
+	// Write commands go to the primary
+	document := replyDocuments(t, client.Request(newQueryMessage(2, "test.$cmd", bson.D{
+		{Name: "insert", Value: "users"},
+		{Name: "documents", Value: []interface{}{bson.M{"a": 2}, bson.M{"a": 3}}},
+	})))[0]
+
+	if document["n"] != 2 {
+		t.Errorf("unexpected insert reply %v", document)
+	}
+}
+
+func TestHandleConnectionStepDown(t *testing.T) {
+	fake := startFakeReplSet(t, 2)
+	set := startTestReplSet(t, fake)
+	client := newReplSetClient(t, set)
+	oldPrimary, newPrimary := fake.Members[0], fake.Members[1]
+
+	client.Request(newQueryMessage(1, "admin.$cmd", bson.M{"ping": 1}))
+
+	// Elect the second member, dropping all connections
+	fake.StepDown(newPrimary)
+
+	// Wait for the driver to discover the new primary
+	waitFor(t, "the driver to see the new primary", func() bool {
+		masters := set.Session.LiveMasters()
+		return len(masters) == 1 && masters[0] == newPrimary.Address
+	})
+
+	// The next operation is routed to the new primary
+	client.Request(newQueryMessage(2, "test.$cmd", bson.D{{Name: "find", Value: "users"}}))
+
+	if !newPrimary.Received(OP_QUERY, "test.$cmd") || oldPrimary.Received(OP_QUERY, "test.$cmd") {
+		t.Errorf("expected the find to run against the new primary")
+	}
+}
+
+// Write the routing configuration file and load it into a routing table
+func startTestRoutingTable(t *testing.T, config string) (*RoutingTable, string) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	writeTestRoutingConfig(t, path, config)
+
+	table := NewRoutingTable(path, 10000)
+	err := table.Start()
+	if err != nil {
+		t.Fatalf("failed to start routing table %v", err)
+	}
+
+	t.Cleanup(func() {
+		for _, set := range table.Sets {
+			set.Close()
+		}
+	})
+
+	return table, path
+}
+
+func TestHandleRoutedConnection(t *testing.T) {
+	a := startFakeReplSet(t, 1)
+	b := startFakeReplSet(t, 1)
+
+	config := `{
+		"clusters": {"a": "` + a.Uri() + `", "b": "` + b.Uri() + `"},
+		"databases": {"tenant1": "b"},
+		"users": {"reporting": "b"},
+		"default": "a"
+	}`
+
+	table, _ := startTestRoutingTable(t, config)
+	client := newFakeClient(t, func(conn net.Conn) {
+		HandleRoutedConnection(table, listenerPortForTest, conn)
+	})
+
+	client.Request(newQueryMessage(1, "tenant1.$cmd", bson.D{{Name: "find", Value: "users"}}))
+	client.Request(newQueryMessage(2, "other.$cmd", bson.D{{Name: "find", Value: "users"}}))
+
+	if !b.Members[0].Received(OP_QUERY, "tenant1.$cmd") || a.Members[0].Received(OP_QUERY, "tenant1.$cmd") {
+		t.Errorf("expected tenant1 to be routed to cluster b")
+	}
+
+	if !a.Members[0].Received(OP_QUERY, "other.$cmd") || b.Members[0].Received(OP_QUERY, "other.$cmd") {
+		t.Errorf("expected the default database to be routed to cluster a")
+	}
+}
+
+func TestHandleRoutedConnectionCursors(t *testing.T) {
+	a := startFakeReplSet(t, 1)
+	b := startFakeReplSet(t, 1)
+	// Cursor ids are not shared between the clusters
+	b.Members[0].cursorId = 100
+
+	documents := make([]interface{}, 0)
+	for i := 0; i < 7; i++ {
+		documents = append(documents, bson.M{"a": i})
+	}
+
+	a.Members[0].SetCollection("tenant1.users", documents...)
+	b.Members[0].SetCollection("tenant1.users", documents...)
+
+	clusters := `"clusters": {"a": "` + a.Uri() + `", "b": "` + b.Uri() + `"}`
+	table, path := startTestRoutingTable(t, `{`+clusters+`, "databases": {"tenant1": "b"}, "default": "a"}`)
+	client := newFakeClient(t, func(conn net.Conn) {
+		HandleRoutedConnection(table, listenerPortForTest, conn)
+	})
+
+	// Open a command and a legacy cursor on cluster b
+	reply := client.Request(newQueryMessage(1, "tenant1.$cmd", bson.D{{Name: "find", Value: "users"}}))
+	cursor := replyDocuments(t, reply)[0]["cursor"].(bson.M)
+	legacy := client.Request(newQueryMessage(2, "tenant1.users", bson.D{}))
+
+	if legacy.CursorId == 0 || cursor["id"] == int64(0) {
+		t.Fatalf("expected cursors got %v and %v", cursor["id"], legacy.CursorId)
+	}
+
+	// Move the database to cluster a
+	writeTestRoutingConfig(t, path, `{`+clusters+`, "default": "a"}`)
+	err := table.Reload()
+	if err != nil {
+		t.Fatalf("failed to reload routing table %v", err)
+	}
+
+	// The cursors are still read from cluster b
+	reply = client.Request(newQueryMessage(3, "tenant1.$cmd", bson.D{
+		{Name: "getMore", Value: cursor["id"]},
+		{Name: "collection", Value: "users"},
+	}))
+
+	if batch := replyDocuments(t, reply)[0]["cursor"].(bson.M)["nextBatch"].([]interface{}); len(batch) != fakeBatchSize {
+		t.Errorf("expected a batch of %v documents got %v", fakeBatchSize, batch)
+	}
+
+	reply = client.Request(newGetMoreMessage(4, "tenant1.users", legacy.CursorId))
+	if len(replyDocuments(t, reply)) != fakeBatchSize {
+		t.Errorf("expected a batch of %v documents got %v", fakeBatchSize, replyDocuments(t, reply))
+	}
+
+	if countCommands(b.Members[0], "getMore") != 1 || countCommands(a.Members[0], "getMore") != 0 {
+		t.Errorf("expected the getMore command to be routed to cluster b")
+	}
+
+	if !b.Members[0].Received(OP_GET_MORE, "tenant1.users") || a.Members[0].Received(OP_GET_MORE, "tenant1.users") {
+		t.Errorf("expected the getmore to be routed to cluster b")
+	}
+
+	// New operations follow the new route
+	client.Request(newQueryMessage(5, "tenant1.$cmd", bson.D{{Name: "find", Value: "users"}}))
+	if countCommands(a.Members[0], "find") != 1 || countCommands(b.Members[0], "find") != 1 {
+		t.Errorf("expected the new find to be routed to cluster a")
+	}
+}
+
+func startTestMongosSet(t *testing.T, routers ...*fakeMongod) *MongosSet {
+	uri := "mongodb://"
+	for i, router := range routers {
+		router.Mongos = true
+		if i > 0 {
+			uri = uri + ","
+		}
+
+		uri = uri + router.Address
+	}
+
+	set := NewMongosSet(uri, 10000)
+	err := set.Start()
+	if err != nil {
+		t.Fatalf("failed to start mongos set %v", err)
+	}
+
+	t.Cleanup(set.Close)
+	return set
+}
+
+// Number of times the server executed the command
+func countCommands(server *fakeMongod, name string) int {
+	count := 0
+	for _, operation := range server.Operations() {
+		if operation.Command == name {
+			count = count + 1
+		}
+	}
+
+	return count
+}
+
+func TestHandleMongosConnectionPinsCursors(t *testing.T) {
+	routers := []*fakeMongod{startFakeMongod(t), startFakeMongod(t)}
+	// Enough documents for three getMore batches
+	documents := make([]interface{}, 0)
+	for i := 0; i < 7; i++ {
+		documents = append(documents, bson.M{"a": i})
+	}
+
+	for _, router := range routers {
+		router.SetCollection("test.users", documents...)
+	}
+
+	set := startTestMongosSet(t, routers...)
+	client := newFakeClient(t, func(conn net.Conn) {
+		HandleMongosConnection(set, conn)
+	})
+
+	// Open a command cursor
+	reply := client.Request(newQueryMessage(1, "test.$cmd", bson.D{{Name: "find", Value: "users"}}))
+	cursor := replyDocuments(t, reply)[0]["cursor"].(bson.M)
+
+	// Locate the router that owns it
+	var owner *fakeMongod
+	for _, router := range routers {
+		if router.Received(OP_QUERY, "test.$cmd") {
+			owner = router
+		}
+	}
+
+	// Every getMore must go to the same router
+	for i := int32(2); i < 5; i++ {
+		client.Request(newQueryMessage(i, "test.$cmd", bson.D{
+			{Name: "getMore", Value: cursor["id"]},
+			{Name: "collection", Value: "users"},
+		}))
+	}
+
+	for _, router := range routers {
+		getMores := countCommands(router, "getMore")
+		if router == owner && getMores != 3 || router != owner && getMores != 0 {
+			t.Errorf("router %s received %v getMores", router.Address, getMores)
+		}
+	}
+}
+
+func TestHandleMongosConnectionFailover(t *testing.T) {
+	routers := []*fakeMongod{startFakeMongod(t), startFakeMongod(t)}
+	set := startTestMongosSet(t, routers...)
+
+	client := newFakeClient(t, func(conn net.Conn) {
+		HandleMongosConnection(set, conn)
+	})
+
+	// Take the first router down before the health check notices
+	routers[0].Close()
+	pings := countCommands(routers[1], "ping")
+
+	// Every operation is served by the remaining router
+	for i := int32(1); i <= 4; i++ {
+		document := replyDocuments(t, client.Request(newQueryMessage(i, "admin.$cmd", bson.M{"ping": 1})))[0]
+		if document["ok"] != 1 {
+			t.Fatalf("unexpected ping reply %v", document)
+		}
+	}
+
+	if countCommands(routers[1], "ping")-pings != 4 {
+		t.Errorf("expected 4 pings on the healthy router got %v", countCommands(routers[1], "ping")-pings)
+	}
+}
+
+func TestCreateResponseMessage(t *testing.T) {
+	isMaster := &isMasterResult{
+		Ok:                  1,
+		IsMaster:            true,
+		Hosts:               []string{"localhost:27017"},
+		Tags:                map[string]string{"dc": "east"},
+		Msg:                 "isdbgrid",
+		MaxMessageSizeBytes: 48000000,
+		MaxWireVersion:      6,
+		MaxBsonObjectSize:   16777216,
+		LocalTime:           time.Date(2015, 2, 5, 10, 30, 0, 0, time.UTC),
+	}
+
+	message, err := CreateResponseMessage([]byte{1, 2, 3, 4}, isMaster)
+	if err != nil {
+		t.Fatalf("failed to create the response %v", err)
+	}
+
+	// The same reply encoded with mgo
+	document, err := bson.Marshal(isMaster)
+	if err != nil {
+		t.Fatalf("mgo failed to marshal %v", err)
+	}
+
+	expected := addInt32(nil, int32(36+len(document)))
+	expected = append(expected, 0, 0, 0, 0, 1, 2, 3, 4)
+	expected = addInt32(expected, OP_REPLY)
+	expected = append(expected, make([]byte, 16)...)
+	expected = addInt32(expected, 1)
+	expected = append(expected, document...)
+
+	if !bytes.Equal(message, expected) {
+		t.Errorf("expected %v got %v", expected, message)
+	}
+}
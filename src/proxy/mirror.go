@@ -0,0 +1,345 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"mongo"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of mirrored operations that can wait for a worker before
+// operations are dropped
+const mirrorQueueSize = 1024
+
+// Interval between logging of the mirror statistics
+const mirrorReportInterval = time.Minute
+
+// Read commands that are safe to mirror
+var mirrorReadCommands = map[string]bool{
+	"find":      true,
+	"count":     true,
+	"distinct":  true,
+	"aggregate": true,
+	"group":     true,
+	"geoNear":   true,
+}
+
+// Write commands mirrored when writes are enabled
+var mirrorWriteCommands = map[string]bool{
+	"insert":        true,
+	"update":        true,
+	"delete":        true,
+	"findAndModify": true,
+}
+
+// Operation waiting to be replayed against the shadow replicaset
+type mirrorRequest struct {
+	messageSizeBytes []byte
+	wireMessage      []byte
+	primaryLatency   time.Duration
+	primaryReply     []byte
+}
+
+// Statistics of the mirrored traffic
+type MirrorStats struct {
+	Mirrored       int64
+	Dropped        int64
+	Failed         int64
+	Diffs          int64
+	PrimaryLatency time.Duration
+	ShadowLatency  time.Duration
+}
+
+func NewMirror(set *ReplSet, sampleRate float64, writes bool) *Mirror {
+	mirror := new(Mirror)
+	mirror.Set = set
+	mirror.SampleRate = sampleRate
+	mirror.Writes = writes
+	mirror.queue = make(chan *mirrorRequest, mirrorQueueSize)
+	mirror.done = make(chan bool)
+	return mirror
+}
+
+// Asynchronously duplicates a sample of the client operations to a
+// shadow replicaset, discarding the replies after comparing them with
+// the replies of the primary cluster
+type Mirror struct {
+	Set        *ReplSet
+	SampleRate float64
+	Writes     bool
+	queue      chan *mirrorRequest
+	stats      MirrorStats
+	mutex      sync.Mutex
+	// Closed to stop the workers and the reporting, stopped waits for them
+	done    chan bool
+	stopped sync.WaitGroup
+	closed  bool
+}
+
+// Start the workers replaying operations against the shadow replicaset
+func (p *Mirror) Start(workers int) error {
+	err := p.Set.Start()
+	if err != nil {
+		return err
+	}
+
+	p.stopped.Add(workers + 1)
+	for i := 0; i < workers; i++ {
+		go p.worker()
+	}
+
+	go p.report()
+	return nil
+}
+
+// Stop the workers and the reporting and close the shadow replicaset,
+// operations still queued are discarded
+func (p *Mirror) Close() {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+
+	p.closed = true
+	p.mutex.Unlock()
+
+	// Let the workers finish the operation they are executing
+	close(p.done)
+	p.stopped.Wait()
+
+	if p.Set != nil {
+		p.Set.Close()
+	}
+}
+
+// Returns a copy of the current statistics
+func (p *Mirror) Stats() MirrorStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.stats
+}
+
+// Should the operation be mirrored
+func (p *Mirror) sample(wireMessage []byte) bool {
+	opCode := readInt32(wireMessage[8:12])
+
+	switch opCode {
+	case OP_INSERT, OP_UPDATE, OP_DELETE:
+		if !p.Writes {
+			return false
+		}
+	case OP_QUERY:
+		query, err := parseQuery(wireMessage)
+		if err != nil {
+			return false
+		}
+
+		// Only mirror known commands, leave out authentication and admin commands
+		if query.IsCommand() {
+			name := commandName(query.Query)
+			if !mirrorReadCommands[name] && !(p.Writes && mirrorWriteCommands[name]) {
+				return false
+			}
+		}
+	default:
+		// Cursors ids are not shared between the clusters
+		return false
+	}
+
+	return rand.Float64() < p.SampleRate
+}
+
+// Queue an operation executed against the primary cluster for mirroring,
+// the operation is dropped if the workers can not keep up
+func (p *Mirror) Submit(messageSizeBytes []byte, wireMessage []byte, primaryLatency time.Duration, primaryReply []byte) {
+	if !p.sample(wireMessage) {
+		return
+	}
+
+	select {
+	case p.queue <- &mirrorRequest{messageSizeBytes, wireMessage, primaryLatency, primaryReply}:
+	default:
+		p.mutex.Lock()
+		p.stats.Dropped = p.stats.Dropped + 1
+		p.mutex.Unlock()
+	}
+}
+
+func (p *Mirror) worker() {
+	var context *ConnectionContext
+	var isMaster *isMasterResult
+	var err error
+
+	defer p.stopped.Done()
+
+	for {
+		var request *mirrorRequest
+		select {
+		case <-p.done:
+			if context != nil {
+				context.Close()
+			}
+			return
+		case request = <-p.queue:
+		}
+
+		// Connect to the shadow replicaset
+		if context == nil {
+			context = &ConnectionContext{}
+			context.Secondaries = make([]*ServerConnection, 0)
+			isMaster, err = updateContext(context, p.Set.Session, p.Set.Timeout)
+		} else {
+			err = updateWorldView(context, p.Set.Session, p.Set.Timeout)
+		}
+
+		if err != nil {
+			log.Printf("failed to connect to mirror replicaset %v", err)
+			p.failed(context)
+			context = nil
+			continue
+		}
+
+		// Execute against the shadow replicaset discarding the reply
+		start := time.Now()
+		reply, err := processMessage(context, isMaster, ioutil.Discard, request.messageSizeBytes, request.wireMessage)
+		shadowLatency := time.Since(start)
+
+		if err != nil {
+			log.Printf("failed to execute mirrored operation %v", err)
+			p.failed(context)
+			context = nil
+			continue
+		}
+
+		// Compare the shape of the replies
+		diff := replyDiff(request.primaryReply, reply)
+		if diff != "" {
+			log.Printf("mirrored reply differs from primary cluster: %s", diff)
+		}
+
+		// Nobody reads the rest of the shadow cursor, kill it on the member
+		// that returned it so it does not stay open until it times out
+		err = killShadowCursor(context, request.wireMessage, reply)
+		if err != nil {
+			log.Printf("failed to kill mirrored cursor %v", err)
+			p.failed(context)
+			context = nil
+		}
+
+		p.mutex.Lock()
+		p.stats.Mirrored = p.stats.Mirrored + 1
+		p.stats.PrimaryLatency = p.stats.PrimaryLatency + request.primaryLatency
+		p.stats.ShadowLatency = p.stats.ShadowLatency + shadowLatency
+		if diff != "" {
+			p.stats.Diffs = p.stats.Diffs + 1
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// Kill the cursor returned by the shadow replicaset for the operation
+func killShadowCursor(context *ConnectionContext, wireMessage []byte, responseMessage []byte) error {
+	if responseMessage == nil {
+		return nil
+	}
+
+	reply, err := parseReply(responseMessage)
+	if err != nil {
+		return nil
+	}
+
+	cursorId := replyCursorId(parseMongosRequest(wireMessage), reply)
+	if cursorId == 0 {
+		return nil
+	}
+
+	// The cursor lives on the member the operation was sent to
+	connection := selectConnection(context, wireMessage)
+	if connection == nil {
+		return errors.New(fmt.Sprintf("no server available to kill cursor %v", cursorId))
+	}
+
+	killCursors := newKillCursorsMessage(readInt32(wireMessage[0:4]), cursorId)
+	return writeWireMessage(connection, addInt32(nil, int32(len(killCursors)+4)), killCursors)
+}
+
+// Record a failed operation and drop the broken connections
+func (p *Mirror) failed(context *ConnectionContext) {
+	if context != nil {
+		context.Close()
+	}
+
+	p.mutex.Lock()
+	p.stats.Failed = p.stats.Failed + 1
+	p.mutex.Unlock()
+}
+
+// Periodically log the mirror statistics
+func (p *Mirror) report() {
+	ticker := time.NewTicker(mirrorReportInterval)
+	defer ticker.Stop()
+	defer p.stopped.Done()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		stats := p.Stats()
+
+		// Average latencies
+		var primaryLatency, shadowLatency time.Duration
+		if stats.Mirrored > 0 {
+			primaryLatency = stats.PrimaryLatency / time.Duration(stats.Mirrored)
+			shadowLatency = stats.ShadowLatency / time.Duration(stats.Mirrored)
+		}
+
+		log.Printf("mirror: mirrored %v dropped %v failed %v diffs %v avg primary latency %v avg shadow latency %v",
+			stats.Mirrored, stats.Dropped, stats.Failed, stats.Diffs, primaryLatency, shadowLatency)
+	}
+}
+
+// Describe the shape of a reply, the flags, number of documents and the
+// field names and types of the first document
+func replyShape(responseMessage []byte) string {
+	if responseMessage == nil {
+		return "no reply"
+	}
+
+	reply, err := parseReply(responseMessage)
+	if err != nil {
+		return "invalid reply"
+	}
+
+	fields := make([]string, 0)
+	document := reply.FirstDocument()
+	if document != nil {
+		iterator := mongo.RawDocument(document).Iterator()
+		for iterator.Next() {
+			element := iterator.Element()
+			fields = append(fields, fmt.Sprintf("%s:0x%02x", element.Key(), element.Value().Kind))
+		}
+	}
+
+	return fmt.Sprintf("flags %v documents %v {%s}", reply.Flags, reply.NumberReturned, strings.Join(fields, ", "))
+}
+
+// Returns a description of the difference in shape of the two replies
+func replyDiff(expectedReply []byte, reply []byte) string {
+	expectedShape := replyShape(expectedReply)
+	shape := replyShape(reply)
+
+	if expectedShape == shape {
+		return ""
+	}
+
+	return fmt.Sprintf("expected [%s] got [%s]", expectedShape, shape)
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"gopkg.in/mgo.v2/bson"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMirrorSample(t *testing.T) {
+	find := newQueryMessage(1, "test.$cmd", bson.D{{Name: "find", Value: "users"}})
+	query := newQueryMessage(1, "test.users", bson.D{})
+	insertCommand := newQueryMessage(1, "test.$cmd", bson.D{{Name: "insert", Value: "users"}})
+	insert := newInsertMessage(1, "test.users", bson.M{"a": 1})
+	saslStart := newQueryMessage(1, "admin.$cmd", bson.D{{Name: "saslStart", Value: 1}})
+	getMore := newGetMoreMessage(1, "test.users", 1)
+
+	for _, test := range []struct {
+		name        string
+		sampleRate  float64
+		writes      bool
+		wireMessage []byte
+		mirrored    bool
+	}{
+		{"read command", 1, false, find, true},
+		{"legacy query", 1, false, query, true},
+		{"nothing sampled", 0, false, find, false},
+		{"write command", 1, false, insertCommand, false},
+		{"legacy write", 1, false, insert, false},
+		{"mirrored write command", 1, true, insertCommand, true},
+		{"mirrored legacy write", 1, true, insert, true},
+		{"writes not sampled", 0, true, insert, false},
+		{"authentication command", 1, true, saslStart, false},
+		{"cursor", 1, true, getMore, false},
+	} {
+		mirror := NewMirror(nil, test.sampleRate, test.writes)
+		if mirror.sample(test.wireMessage) != test.mirrored {
+			t.Errorf("%s: expected mirrored to be %v", test.name, test.mirrored)
+		}
+	}
+}
+
+func TestMirrorSubmitDropsWhenFull(t *testing.T) {
+	// Without workers nothing takes operations off the queue
+	mirror := NewMirror(nil, 1, false)
+	find := newQueryMessage(1, "test.$cmd", bson.D{{Name: "find", Value: "users"}})
+	sizeBytes := addInt32(nil, int32(len(find)+4))
+
+	for i := 0; i < mirrorQueueSize+3; i++ {
+		mirror.Submit(sizeBytes, find, 0, nil)
+	}
+
+	if len(mirror.queue) != mirrorQueueSize || mirror.Stats().Dropped != 3 {
+		t.Errorf("expected %v queued and 3 dropped got %v and %v", mirrorQueueSize, len(mirror.queue), mirror.Stats().Dropped)
+	}
+
+	// Operations that are not sampled are not dropped
+	mirror.Submit(sizeBytes, newInsertMessage(2, "test.users", bson.M{"a": 1}), 0, nil)
+	if mirror.Stats().Dropped != 3 {
+		t.Errorf("expected the insert to be skipped got %v dropped", mirror.Stats().Dropped)
+	}
+}
+
+func TestMirrorConnection(t *testing.T) {
+	primary := startFakeReplSet(t, 1)
+	shadow := startFakeReplSet(t, 1)
+
+	set := startTestReplSet(t, primary)
+	set.Mirror = NewMirror(NewReplSet(shadow.Uri(), 10000), 1, false)
+	err := set.Mirror.Start(1)
+	if err != nil {
+		t.Fatalf("failed to start mirror %v", err)
+	}
+
+	t.Cleanup(set.Mirror.Close)
+	client := newReplSetClient(t, set)
+
+	// Writes are not mirrored by default
+	client.Send(newInsertMessage(1, "test.users", bson.M{"a": 1}))
+	client.Request(newQueryMessage(2, "test.$cmd", bson.D{{Name: "find", Value: "users"}}))
+
+	waitFor(t, "the find to be mirrored", func() bool {
+		return set.Mirror.Stats().Mirrored == 1
+	})
+
+	if countCommands(shadow.Members[0], "find") != 1 {
+		t.Errorf("expected the shadow replicaset to execute the find")
+	}
+
+	if !primary.Members[0].Received(OP_INSERT, "test.users") || shadow.Members[0].Received(OP_INSERT, "test.users") {
+		t.Errorf("expected the insert to only reach the primary cluster")
+	}
+
+	// Both clusters return a cursor of the same shape
+	if stats := set.Mirror.Stats(); stats.Diffs != 0 || stats.Failed != 0 || stats.Dropped != 0 {
+		t.Errorf("unexpected mirror statistics %+v", stats)
+	}
+
+	// The shadow replicaset does not know the count command
+	count := newQueryMessage(3, "test.$cmd", bson.D{{Name: "count", Value: "users"}})
+	set.Mirror.Submit(addInt32(nil, int32(len(count)+4)), count, 0, newReplyMessage(3, bson.D{{Name: "n", Value: 1}, {Name: "ok", Value: 1}}))
+
+	waitFor(t, "the count to be mirrored", func() bool {
+		return set.Mirror.Stats().Mirrored == 2
+	})
+
+	if set.Mirror.Stats().Diffs != 1 {
+		t.Errorf("expected the count replies to differ got %+v", set.Mirror.Stats())
+	}
+}
+
+func TestMirrorKillsShadowCursors(t *testing.T) {
+	primary := startFakeReplSet(t, 1)
+	shadow := startFakeReplSet(t, 1)
+
+	// More documents than fit in the first batch leave the cursors open
+	for _, server := range []*fakeMongod{primary.Members[0], shadow.Members[0]} {
+		server.SetCollection("test.users", bson.M{"a": 1}, bson.M{"a": 2}, bson.M{"a": 3})
+	}
+
+	set := startTestReplSet(t, primary)
+	set.Mirror = NewMirror(NewReplSet(shadow.Uri(), 10000), 1, false)
+	err := set.Mirror.Start(1)
+	if err != nil {
+		t.Fatalf("failed to start mirror %v", err)
+	}
+
+	t.Cleanup(set.Mirror.Close)
+	client := newReplSetClient(t, set)
+
+	for i, request := range [][]byte{
+		newQueryMessage(1, "test.$cmd", bson.D{{Name: "find", Value: "users"}}),
+		newQueryMessage(2, "test.users", bson.D{}),
+	} {
+		client.Request(request)
+
+		waitFor(t, "the cursor to be killed on the shadow replicaset", func() bool {
+			return set.Mirror.Stats().Mirrored == int64(i+1) && len(openCursors(shadow.Members[0])) == 0
+		})
+	}
+
+	if !shadow.Members[0].Received(OP_KILL_CURSORS, "") {
+		t.Errorf("expected the shadow replicaset to receive OP_KILL_CURSORS")
+	}
+
+	// The client cursors on the primary cluster are left alone
+	if len(openCursors(primary.Members[0])) != 2 {
+		t.Errorf("expected the client cursors to stay open got %v", openCursors(primary.Members[0]))
+	}
+}
+
+func TestMirrorClose(t *testing.T) {
+	shadow := startFakeReplSet(t, 1)
+	mirror := NewMirror(NewReplSet(shadow.Uri(), 10000), 1, false)
+	err := mirror.Start(2)
+	if err != nil {
+		t.Fatalf("failed to start mirror %v", err)
+	}
+
+	mirror.Close()
+	if mirror.Set.Session != nil {
+		t.Errorf("expected the shadow replicaset to be closed")
+	}
+
+	// Nothing executes operations submitted after the mirror was closed
+	find := newQueryMessage(1, "test.$cmd", bson.D{{Name: "find", Value: "users"}})
+	mirror.Submit(addInt32(nil, int32(len(find)+4)), find, 0, nil)
+	time.Sleep(50 * time.Millisecond)
+
+	if countCommands(shadow.Members[0], "find") != 0 || mirror.Stats().Mirrored != 0 {
+		t.Errorf("expected the closed mirror not to execute the find")
+	}
+
+	// Closing twice is harmless
+	mirror.Close()
+}
+
+// Cursor ids the fake server still has open
+func openCursors(server *fakeMongod) []int64 {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	cursorIds := make([]int64, 0)
+	for cursorId := range server.cursors {
+		cursorIds = append(cursorIds, cursorId)
+	}
+
+	return cursorIds
+}
+
+func TestReplyDiff(t *testing.T) {
+	reply := newReplyMessage(1, bson.D{{Name: "n", Value: 1}, {Name: "ok", Value: 1}})
+
+	for _, test := range []struct {
+		name     string
+		expected []byte
+		reply    []byte
+		diff     string
+	}{
+		{"same reply", reply, reply, ""},
+		{"same shape", reply, newReplyMessage(2, bson.D{{Name: "n", Value: 5}, {Name: "ok", Value: 1}}), ""},
+		{"no replies", nil, nil, ""},
+		{
+			"field type",
+			reply,
+			newReplyMessage(1, bson.D{{Name: "n", Value: "5"}, {Name: "ok", Value: 1}}),
+			"expected [flags 0 documents 1 {n:0x10, ok:0x10}] got [flags 0 documents 1 {n:0x02, ok:0x10}]",
+		},
+		{
+			"missing field",
+			reply,
+			newReplyMessage(1, bson.D{{Name: "ok", Value: 1}}),
+			"expected [flags 0 documents 1 {n:0x10, ok:0x10}] got [flags 0 documents 1 {ok:0x10}]",
+		},
+		{
+			"number of documents",
+			reply,
+			newReplyMessage(1, bson.D{{Name: "n", Value: 1}, {Name: "ok", Value: 1}}, bson.M{"n": 2}),
+			"expected [flags 0 documents 1 {n:0x10, ok:0x10}] got [flags 0 documents 2 {n:0x10, ok:0x10}]",
+		},
+		{"no reply", reply, nil, "expected [flags 0 documents 1 {n:0x10, ok:0x10}] got [no reply]"},
+		{"invalid reply", reply, []byte{1, 2}, "expected [flags 0 documents 1 {n:0x10, ok:0x10}] got [invalid reply]"},
+	} {
+		diff := replyDiff(test.expected, test.reply)
+		if diff != test.diff {
+			t.Errorf("%s: expected diff %q got %q", test.name, test.diff, diff)
+		}
+	}
+
+	// Flags are part of the shape
+	failed := newReplyMessage(1, bson.D{{Name: "n", Value: 1}, {Name: "ok", Value: 1}})
+	failed[12] = 2
+	if diff := replyDiff(reply, failed); !strings.Contains(diff, "got [flags 2 ") {
+		t.Errorf("expected the flags to differ got %q", diff)
+	}
+}
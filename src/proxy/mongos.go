@@ -0,0 +1,327 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/mgo.v2"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Routers within this window of the fastest router are all eligible for
+// selection, identical to the drivers localThresholdMS default
+const latencyWindow = 15 * time.Millisecond
+
+// Weight of a new sample in the router round trip time average
+const latencyWeight = 0.2
+
+// Default interval between router health checks
+const defaultHealthCheckInterval = 5 * time.Second
+
+// A single mongos router fronted by the proxy
+type Router struct {
+	Address  string
+	uri      string
+	session  *mgo.Session
+	healthy  bool
+	latency  time.Duration
+	isMaster *isMasterResult
+}
+
+func NewMongosSet(uri string, timeout time.Duration) *MongosSet {
+	set := new(MongosSet)
+	set.uri = uri
+	set.Timeout = timeout
+	set.Interval = defaultHealthCheckInterval
+	return set
+}
+
+// A set of mongos routers in front of a sharded cluster, the proxy load
+// balances over the healthy routers and fails over when one goes down
+type MongosSet struct {
+	uri      string
+	Timeout  time.Duration
+	Interval time.Duration
+	Routers  []*Router
+	Recorder *Recorder
+	mutex    sync.RWMutex
+	counter  int
+	// Closed to stop the monitor, which closes stopped when it returns
+	done    chan bool
+	stopped chan bool
+	closed  bool
+}
+
+func (p *MongosSet) Start() error {
+	// Build a direct connection uri for every router listed
+	uris, addresses, err := routerUris(p.uri)
+	if err != nil {
+		return err
+	}
+
+	for i, address := range addresses {
+		p.Routers = append(p.Routers, &Router{Address: address, uri: uris[i]})
+	}
+
+	// Run the first health check synchronously
+	p.checkRouters()
+
+	// We need at least one router to be able to serve traffic
+	if p.Select(nil) == nil {
+		p.Close()
+		return errors.New(fmt.Sprintf("no healthy mongos router found in %v", addresses))
+	}
+
+	// Keep monitoring the routers
+	p.mutex.Lock()
+	p.done = make(chan bool)
+	p.stopped = make(chan bool)
+	go p.monitor(p.done, p.stopped)
+	p.mutex.Unlock()
+	return nil
+}
+
+// Periodically check the health and latency of all the routers until
+// done is closed
+func (p *MongosSet) monitor(done chan bool, stopped chan bool) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	defer close(stopped)
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			p.checkRouters()
+		}
+	}
+}
+
+// Stop monitoring the routers and close their sessions, the set can not
+// be used anymore
+func (p *MongosSet) Close() {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+
+	p.closed = true
+	done, stopped := p.done, p.stopped
+	p.mutex.Unlock()
+
+	// Let a running health check finish before closing the sessions
+	if done != nil {
+		close(done)
+		<-stopped
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, router := range p.Routers {
+		if router.session != nil {
+			router.session.Close()
+			router.session = nil
+		}
+
+		router.healthy = false
+	}
+}
+
+func (p *MongosSet) checkRouters() {
+	var wait sync.WaitGroup
+
+	for _, router := range p.Routers {
+		wait.Add(1)
+		go func(router *Router) {
+			defer wait.Done()
+			p.checkRouter(router)
+		}(router)
+	}
+
+	wait.Wait()
+}
+
+// Run ismaster against the router and record the outcome and round trip time
+func (p *MongosSet) checkRouter(router *Router) {
+	duration := time.Duration(p.Timeout * time.Millisecond)
+
+	// Lazily connect to the router
+	p.mutex.RLock()
+	session := router.session
+	p.mutex.RUnlock()
+
+	if session == nil {
+		var err error
+		session, err = mgo.DialWithTimeout(router.uri, duration)
+		if err != nil {
+			log.Printf("failed to connect to mongos %s %v", router.Address, err)
+			p.updateRouter(router, false, 0, nil)
+			return
+		}
+
+		p.mutex.Lock()
+		router.session = session
+		p.mutex.Unlock()
+	}
+
+	// Time the ismaster command
+	isMaster := &isMasterResult{}
+	start := time.Now()
+	err := session.Run("ismaster", isMaster)
+	roundTrip := time.Since(start)
+
+	if err != nil {
+		log.Printf("health check against mongos %s failed %v", router.Address, err)
+		// Force the session to reconnect on the next check
+		session.Refresh()
+		p.updateRouter(router, false, 0, nil)
+		return
+	}
+
+	if isMaster.Msg != "isdbgrid" {
+		log.Printf("server %s is not a mongos router", router.Address)
+	}
+
+	p.updateRouter(router, true, roundTrip, isMaster)
+}
+
+func (p *MongosSet) updateRouter(router *Router, healthy bool, roundTrip time.Duration, isMaster *isMasterResult) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if healthy != router.healthy {
+		log.Printf("mongos %s healthy changed to %v", router.Address, healthy)
+	}
+
+	router.healthy = healthy
+	if !healthy {
+		return
+	}
+
+	// Update the weighted round trip time
+	if router.latency == 0 {
+		router.latency = roundTrip
+	} else {
+		router.latency = time.Duration(latencyWeight*float64(roundTrip) + (1-latencyWeight)*float64(router.latency))
+	}
+
+	router.isMaster = isMaster
+}
+
+// Select a healthy router inside the latency window, rotating between
+// the eligible routers. Routers in exclude are not considered
+func (p *MongosSet) Select(exclude map[string]bool) *Router {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	// Locate the fastest healthy router
+	var fastest time.Duration = -1
+	for _, router := range p.Routers {
+		if router.healthy && !exclude[router.Address] && (fastest == -1 || router.latency < fastest) {
+			fastest = router.latency
+		}
+	}
+
+	if fastest == -1 {
+		return nil
+	}
+
+	// Collect all the routers inside the latency window
+	eligible := make([]*Router, 0, len(p.Routers))
+	for _, router := range p.Routers {
+		if router.healthy && !exclude[router.Address] && router.latency <= fastest+latencyWindow {
+			eligible = append(eligible, router)
+		}
+	}
+
+	p.counter = p.counter + 1
+	return eligible[p.counter%len(eligible)]
+}
+
+// Mark a router as down after a failed operation, the health check
+// will bring it back once it responds again
+func (p *MongosSet) MarkFailed(address string) {
+	for _, router := range p.Routers {
+		if router.Address == address {
+			p.updateRouter(router, false, 0, nil)
+		}
+	}
+}
+
+// Returns the last ismaster result of any healthy router
+func (p *MongosSet) IsMaster() *isMasterResult {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	for _, router := range p.Routers {
+		if router.healthy && router.isMaster != nil {
+			return router.isMaster
+		}
+	}
+
+	return nil
+}
+
+// Split the seed list of a connection uri into one direct connection
+// uri per host, keeping the credentials, database and options
+func routerUris(uri string) ([]string, []string, error) {
+	const prefix = "mongodb://"
+	if !strings.HasPrefix(uri, prefix) {
+		return nil, nil, errors.New(fmt.Sprintf("uri %s does not start with %s", uri, prefix))
+	}
+
+	rest := uri[len(prefix):]
+
+	// Split of the database and options
+	suffix := ""
+	if index := strings.IndexByte(rest, '/'); index != -1 {
+		suffix = rest[index:]
+		rest = rest[:index]
+	}
+
+	// Split of the credentials
+	credentials := ""
+	if index := strings.LastIndex(rest, "@"); index != -1 {
+		credentials = rest[:index+1]
+		rest = rest[index+1:]
+	}
+
+	// Force a direct connection to every single router
+	if suffix == "" {
+		suffix = "/"
+	}
+
+	if strings.Contains(suffix, "?") {
+		suffix = suffix + "&connect=direct"
+	} else {
+		suffix = suffix + "?connect=direct"
+	}
+
+	addresses := make([]string, 0)
+	uris := make([]string, 0)
+
+	for _, address := range strings.Split(rest, ",") {
+		if address == "" {
+			continue
+		}
+
+		// Default port
+		if !strings.Contains(address, ":") {
+			address = address + ":27017"
+		}
+
+		addresses = append(addresses, address)
+		uris = append(uris, prefix+credentials+address+suffix)
+	}
+
+	if len(addresses) == 0 {
+		return nil, nil, errors.New(fmt.Sprintf("uri %s does not contain any hosts", uri))
+	}
+
+	return uris, addresses, nil
+}
@@ -0,0 +1,363 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mongo"
+	"net"
+	"time"
+)
+
+// Per client connection state for a sharded cluster
+type MongosContext struct {
+	Connections  map[string]net.Conn
+	Cursors      map[int64]string
+	Transactions map[string]string
+}
+
+// Routing decision for a single message
+type mongosRequest struct {
+	opCode      int32
+	command     string
+	cursorIds   []int64
+	transaction string
+}
+
+// Return the connection to the router, connecting if needed
+func (p *MongosContext) connection(address string, timeout time.Duration) (net.Conn, error) {
+	if connection, ok := p.Connections[address]; ok {
+		return connection, nil
+	}
+
+	// Timeout duration
+	duration := time.Duration(timeout * time.Millisecond)
+
+	log.Printf("connect to mongos %s", address)
+	connection, err := net.DialTimeout("tcp", address, duration)
+	if err != nil {
+		return nil, err
+	}
+
+	p.Connections[address] = connection
+	return connection, nil
+}
+
+// Drop a broken connection to a router
+func (p *MongosContext) drop(address string) {
+	if connection, ok := p.Connections[address]; ok {
+		connection.Close()
+		delete(p.Connections, address)
+	}
+}
+
+func (p *MongosContext) close() {
+	for address := range p.Connections {
+		p.drop(address)
+	}
+}
+
+// Work out what cursors and transaction a message belongs to
+func parseMongosRequest(wireMessage []byte) *mongosRequest {
+	request := &mongosRequest{opCode: readInt32(wireMessage[8:12])}
+
+	switch request.opCode {
+	case OP_GET_MORE:
+		_, cursorId, err := parseGetMore(wireMessage)
+		if err == nil {
+			request.cursorIds = []int64{cursorId}
+		}
+	case OP_KILL_CURSORS:
+		cursorIds, err := parseKillCursors(wireMessage)
+		if err == nil {
+			request.cursorIds = cursorIds
+		}
+	case OP_QUERY:
+		query, err := parseQuery(wireMessage)
+		if err != nil || !query.IsCommand() {
+			break
+		}
+
+		request.command = commandName(query.Query)
+
+		// Read the fields we pin on in place
+		command := mongo.RawDocument(query.Query)
+
+		// Unwrap a command sent with a read preference
+		if value, err := command.Lookup("$query"); err == nil {
+			if document, err := value.Document(); err == nil {
+				command = document
+			}
+		}
+
+		if value, err := command.Lookup("getMore"); err == nil {
+			if cursorId, err := value.AsInt64(); err == nil && cursorId != 0 {
+				request.cursorIds = []int64{cursorId}
+			}
+		} else if value, err := command.Lookup("cursors"); err == nil {
+			request.cursorIds = rawCursorIds(value)
+		}
+
+		// Transactions are identified by the session id and transaction number
+		lsid, err := command.Lookup("lsid", "id")
+		if err != nil {
+			break
+		}
+
+		txnNumber, err := command.Lookup("txnNumber")
+		if err != nil {
+			break
+		}
+
+		_, id, err := lsid.Binary()
+		number, numberErr := txnNumber.AsInt64()
+		if err == nil && numberErr == nil && number != 0 {
+			request.transaction = fmt.Sprintf("%x:%d", id, number)
+		}
+	}
+
+	return request
+}
+
+// Locate the router the request is pinned to if any
+func (p *MongosContext) pinned(request *mongosRequest) string {
+	if request.transaction != "" {
+		if address, ok := p.Transactions[request.transaction]; ok {
+			return address
+		}
+	}
+
+	for _, cursorId := range request.cursorIds {
+		if address, ok := p.Cursors[cursorId]; ok {
+			return address
+		}
+	}
+
+	return ""
+}
+
+// Update the pins after a successful round trip to a router
+func (p *MongosContext) update(request *mongosRequest, address string, responseMessage []byte) {
+	// Pin the transaction for its lifetime
+	if request.transaction != "" {
+		if request.command == "commitTransaction" || request.command == "abortTransaction" {
+			delete(p.Transactions, request.transaction)
+		} else {
+			p.Transactions[request.transaction] = address
+		}
+	}
+
+	updateCursors(p.Cursors, request, address, responseMessage)
+}
+
+// Record the owner of the cursor returned by the reply, forgetting the
+// cursors of the request that were killed or exhausted
+func updateCursors(cursors map[int64]string, request *mongosRequest, owner string, responseMessage []byte) {
+	// Killed cursors are no longer owned
+	if request.opCode == OP_KILL_CURSORS || request.command == "killCursors" {
+		for _, cursorId := range request.cursorIds {
+			delete(cursors, cursorId)
+		}
+	}
+
+	if responseMessage == nil {
+		return
+	}
+
+	reply, err := parseReply(responseMessage)
+	if err != nil {
+		return
+	}
+
+	cursorId := replyCursorId(request, reply)
+	if cursorId != 0 {
+		cursors[cursorId] = owner
+	} else {
+		// An exhausted cursor no longer needs to be pinned
+		for _, id := range request.cursorIds {
+			delete(cursors, id)
+		}
+	}
+}
+
+// The cursor id returned by the reply to the request, 0 if there is none
+func replyCursorId(request *mongosRequest, reply *replyMessage) int64 {
+	// Legacy cursors are returned in the reply header
+	cursorId := reply.CursorId
+
+	// Command cursors are returned in the reply document
+	if request.opCode == OP_QUERY && request.command != "" {
+		value, err := mongo.RawDocument(reply.FirstDocument()).Lookup("cursor", "id")
+		if err == nil {
+			if id, err := value.AsInt64(); err == nil {
+				cursorId = id
+			}
+		}
+	}
+
+	return cursorId
+}
+
+// Cursor ids of an array, skipping anything that is not a number
+func rawCursorIds(value mongo.Raw) []int64 {
+	array, err := value.Array()
+	if err != nil {
+		return nil
+	}
+
+	var cursorIds []int64
+	iterator := array.Iterator()
+	for iterator.Next() {
+		if cursorId, err := iterator.Element().Value().AsInt64(); err == nil {
+			cursorIds = append(cursorIds, cursorId)
+		}
+	}
+
+	return cursorIds
+}
+
+// Send the message to the router and read the reply if one is expected
+func roundTrip(connection net.Conn, messageSizeBytes []byte, wireMessage []byte, opCode int32) ([]byte, []byte, bool, error) {
+	err := writeWireMessage(connection, messageSizeBytes, wireMessage)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	if !expectsReply(opCode) {
+		return nil, nil, true, nil
+	}
+
+	responseMessageSizeBytes, responseMessage, err := readWireMessage(connection)
+	return responseMessageSizeBytes, responseMessage, true, err
+}
+
+func HandleMongosConnection(set *MongosSet, conn net.Conn) {
+	// Create connection context
+	context := &MongosContext{
+		Connections:  make(map[string]net.Conn),
+		Cursors:      make(map[int64]string),
+		Transactions: make(map[string]string),
+	}
+
+	// Identify the connection in the recording
+	var connectionId uint64
+	if set.Recorder != nil {
+		connectionId = set.Recorder.NewConnectionId()
+	}
+
+	// Clean up connections on exit
+	defer conn.Close()
+	defer context.close()
+
+	// Start reading of messages
+	for {
+		messageSizeBytes, wireMessage, err := readWireMessage(conn)
+		if err != nil {
+			log.Printf("failed to read wire protocol message from connection %v", err)
+			break
+		}
+
+		// Record the client request
+		if set.Recorder != nil {
+			set.Recorder.Record(RECORD_REQUEST, connectionId, wireMessage)
+		}
+
+		// Answer ismaster ourselves presenting a single mongos
+		if isIsMaster(wireMessage) {
+			isMaster := set.IsMaster()
+			if isMaster == nil {
+				log.Printf("no healthy mongos router available")
+				break
+			}
+
+			ismasterCommandBytes, err := CreateResponseMessage(wireMessage[0:4], newIsMasterResponse(isMaster))
+			if err != nil {
+				log.Printf("failed to create ismaster command %v", err)
+				break
+			}
+
+			// Record the reply
+			if set.Recorder != nil {
+				set.Recorder.Record(RECORD_REPLY, connectionId, ismasterCommandBytes[4:])
+			}
+
+			conn.Write(ismasterCommandBytes)
+			continue
+		}
+
+		request := parseMongosRequest(wireMessage)
+		if request.opCode != OP_QUERY && request.opCode != OP_GET_MORE &&
+			request.opCode != OP_INSERT && request.opCode != OP_UPDATE &&
+			request.opCode != OP_DELETE && request.opCode != OP_KILL_CURSORS {
+			log.Printf("opcode %v not supported", request.opCode)
+			break
+		}
+
+		// Cursors and transactions must stay on the router that started them
+		address := context.pinned(request)
+		pinned := address != ""
+		excluded := make(map[string]bool)
+
+		var connection net.Conn
+		var responseMessageSizeBytes []byte
+		var responseMessage []byte
+
+		for {
+			if !pinned {
+				router := set.Select(excluded)
+				if router == nil {
+					err = errors.New("no healthy mongos router available")
+					break
+				}
+
+				address = router.Address
+			}
+
+			connection, err = context.connection(address, set.Timeout)
+			if err == nil {
+				var sent bool
+				responseMessageSizeBytes, responseMessage, sent, err = roundTrip(connection, messageSizeBytes, wireMessage, request.opCode)
+				if err == nil {
+					break
+				}
+
+				// The message reached the router, it is not safe to retry it
+				if sent {
+					set.MarkFailed(address)
+					context.drop(address)
+					break
+				}
+			}
+
+			log.Printf("failed to send message to mongos %s %v", address, err)
+			set.MarkFailed(address)
+			context.drop(address)
+
+			// A pinned operation can not fail over to another router
+			if pinned {
+				break
+			}
+
+			excluded[address] = true
+		}
+
+		if err != nil {
+			log.Printf("failed to execute operation against mongos %s %v", address, err)
+			break
+		}
+
+		// Record any cursors or transactions started
+		context.update(request, address, responseMessage)
+
+		// Write the reply to the client
+		if responseMessage != nil {
+			// Record the reply
+			if set.Recorder != nil {
+				set.Recorder.Record(RECORD_REPLY, connectionId, responseMessage)
+			}
+
+			conn.Write(responseMessageSizeBytes)
+			conn.Write(responseMessage)
+		}
+	}
+}
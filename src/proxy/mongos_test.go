@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRouterUris(t *testing.T) {
+	for _, test := range []struct {
+		uri       string
+		uris      []string
+		addresses []string
+	}{
+		{
+			"mongodb://localhost",
+			[]string{"mongodb://localhost:27017/?connect=direct"},
+			[]string{"localhost:27017"},
+		},
+		{
+			"mongodb://a:50000,b",
+			[]string{"mongodb://a:50000/?connect=direct", "mongodb://b:27017/?connect=direct"},
+			[]string{"a:50000", "b:27017"},
+		},
+		{
+			"mongodb://user:p@ss@a:1,b:2/admin",
+			[]string{"mongodb://user:p@ss@a:1/admin?connect=direct", "mongodb://user:p@ss@b:2/admin?connect=direct"},
+			[]string{"a:1", "b:2"},
+		},
+		{
+			"mongodb://a:1,,b:2/?w=1",
+			[]string{"mongodb://a:1/?w=1&connect=direct", "mongodb://b:2/?w=1&connect=direct"},
+			[]string{"a:1", "b:2"},
+		},
+	} {
+		uris, addresses, err := routerUris(test.uri)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", test.uri, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(uris, test.uris) || !reflect.DeepEqual(addresses, test.addresses) {
+			t.Errorf("%s: expected %v %v got %v %v", test.uri, test.uris, test.addresses, uris, addresses)
+		}
+	}
+
+	for _, uri := range []string{"localhost:27017", "mongodb://", "mongodb:///admin", "mongodb://user@/admin"} {
+		if _, _, err := routerUris(uri); err == nil {
+			t.Errorf("%s: expected an error", uri)
+		}
+	}
+}
+
+// Set of routers with the given health and latency in milliseconds, a
+// negative latency is an unhealthy router
+func newTestMongosSet(latencies ...int) *MongosSet {
+	set := NewMongosSet("mongodb://localhost", 10000)
+	for i, latency := range latencies {
+		set.Routers = append(set.Routers, &Router{
+			Address: string(rune('a' + i)),
+			healthy: latency >= 0,
+			latency: time.Duration(latency) * time.Millisecond,
+		})
+	}
+
+	return set
+}
+
+func TestMongosSetSelect(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		latencies []int
+		exclude   map[string]bool
+		selected  []string
+	}{
+		{"single router", []int{5}, nil, []string{"a"}},
+		{"inside the window", []int{1, 10, 16}, nil, []string{"a", "b", "c"}},
+		{"outside the window", []int{1, 17, 40}, nil, []string{"a"}},
+		{"fastest unhealthy", []int{-1, 20, 30, 36}, nil, []string{"b", "c"}},
+		{"fastest excluded", []int{1, 20, 30}, map[string]bool{"a": true}, []string{"b", "c"}},
+		{"all unhealthy", []int{-1, -1}, nil, nil},
+		{"all excluded", []int{1, 2}, map[string]bool{"a": true, "b": true}, nil},
+	} {
+		set := newTestMongosSet(test.latencies...)
+
+		// Rotate over the eligible routers in order
+		counts := make(map[string]int)
+		var previous *Router
+		for i := 0; i < 2*len(test.selected); i++ {
+			router := set.Select(test.exclude)
+			if router == nil {
+				t.Fatalf("%s: expected a router", test.name)
+			}
+
+			if len(test.selected) > 1 && router == previous {
+				t.Errorf("%s: selected %s twice in a row", test.name, router.Address)
+			}
+
+			counts[router.Address] = counts[router.Address] + 1
+			previous = router
+		}
+
+		for _, address := range test.selected {
+			if counts[address] != 2 {
+				t.Errorf("%s: expected %s to be selected twice got %v", test.name, address, counts)
+			}
+		}
+
+		if len(test.selected) == 0 && set.Select(test.exclude) != nil {
+			t.Errorf("%s: expected no router", test.name)
+		}
+	}
+}
+
+func TestMongosSetMarkFailed(t *testing.T) {
+	set := newTestMongosSet(1, 2)
+
+	set.MarkFailed("a")
+	for i := 0; i < 4; i++ {
+		if router := set.Select(nil); router == nil || router.Address != "b" {
+			t.Fatalf("expected router b got %v", router)
+		}
+	}
+
+	// Unknown routers are ignored
+	set.MarkFailed("unknown")
+	if set.Routers[0].healthy || !set.Routers[1].healthy {
+		t.Errorf("unexpected router health %v %v", set.Routers[0].healthy, set.Routers[1].healthy)
+	}
+
+	set.MarkFailed("b")
+	if router := set.Select(nil); router != nil {
+		t.Errorf("expected no healthy router got %v", router.Address)
+	}
+}
+
+func TestMongosSetClose(t *testing.T) {
+	router := startFakeMongod(t)
+	router.Mongos = true
+
+	set := NewMongosSet("mongodb://"+router.Address, 10000)
+	set.Interval = 10 * time.Millisecond
+	err := set.Start()
+	if err != nil {
+		t.Fatalf("failed to start mongos set %v", err)
+	}
+
+	// Let the monitor run a few health checks
+	waitFor(t, "health checks", func() bool {
+		return countCommands(router, "ismaster") >= 3
+	})
+
+	set.Close()
+	set.Close()
+
+	select {
+	case <-set.stopped:
+	default:
+		t.Fatalf("expected the monitor to be stopped")
+	}
+
+	if set.Routers[0].session != nil || set.Select(nil) != nil {
+		t.Errorf("expected the router sessions to be closed")
+	}
+
+	// No more health checks once closed
+	checks := countCommands(router, "ismaster")
+	time.Sleep(50 * time.Millisecond)
+	if countCommands(router, "ismaster") != checks {
+		t.Errorf("expected no health checks after Close")
+	}
+}
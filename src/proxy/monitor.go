@@ -23,7 +23,7 @@ func updateWorldView(context *ConnectionContext, session *mgo.Session, duration
 	// Validate if our current primary is one of the ones
 	// listed in the master list
 	for _, mserver := range masterServers {
-		if mserver == context.Primary.Address {
+		if context.Primary != nil && mserver == context.Primary.Address {
 			newMaster = false
 			break
 		}
@@ -34,9 +34,11 @@ func updateWorldView(context *ConnectionContext, session *mgo.Session, duration
 	// 1. Close all connections
 	// 2. Reconnect according to the live server list
 	if newMaster {
-		log.Printf("New primary at %v from %v", masterServers[0], context.Primary.Address)
+		if context.Primary != nil {
+			log.Printf("New primary at %v from %v", masterServers[0], context.Primary.Address)
+			context.Primary.Connection.Close()
+		}
 
-		context.Primary.Connection.Close()
 		// Close all the connection
 		for _, con := range context.Secondaries {
 			con.Connection.Close()
@@ -97,7 +99,13 @@ func updateContext(context *ConnectionContext, session *mgo.Session, timeout tim
 	// Establish what server is the master
 	err := session.Run("ismaster", isMaster)
 	if err != nil {
-		log.Fatalf("failed to execute ismaster using mgo %s", err)
+		// The session socket is dead after a stepdown, refresh and retry once
+		session.Refresh()
+		err = session.Run("ismaster", isMaster)
+	}
+
+	if err != nil {
+		log.Printf("failed to execute ismaster using mgo %s", err)
 		return nil, err
 	}
 
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Magic bytes at the start of a recording file, the last byte is the version
+var recordingMagic = []byte{'M', 'O', 'N', 'G', 'O', 'R', 'E', 1}
+
+// Direction of a recorded message
+const RECORD_REQUEST = 1
+const RECORD_REPLY = 2
+
+// Interval between flushes of the recording to disk
+const recordingFlushInterval = time.Second
+
+// A single recorded wire protocol message
+type Record struct {
+	Direction    byte
+	ConnectionId uint64
+	Offset       time.Duration
+	Message      []byte
+}
+
+func NewRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+
+	recorder := new(Recorder)
+	recorder.file = file
+	recorder.writer = bufio.NewWriter(file)
+	recorder.start = time.Now()
+
+	// Write the file header
+	_, err = recorder.writer.Write(recordingMagic)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	go recorder.flush()
+	return recorder, nil
+}
+
+// Records every client request and backend reply to a file. Each record
+// is the direction byte, the connection id and the microseconds since
+// the start of the recording as uvarints, followed by the wire message
+type Recorder struct {
+	file        *os.File
+	writer      *bufio.Writer
+	start       time.Time
+	connections uint64
+	closed      bool
+	mutex       sync.Mutex
+}
+
+// Allocate an id for a new client connection
+func (p *Recorder) NewConnectionId() uint64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.connections = p.connections + 1
+	return p.connections
+}
+
+// Record a wire protocol message (without the size prefix)
+func (p *Recorder) Record(direction byte, connectionId uint64, wireMessage []byte) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	// Build the record header
+	header := make([]byte, 1+2*binary.MaxVarintLen64+4)
+	header[0] = direction
+	index := 1
+	index = index + binary.PutUvarint(header[index:], connectionId)
+	index = index + binary.PutUvarint(header[index:], uint64(time.Since(p.start)/time.Microsecond))
+
+	// Size prefix of the wire message
+	writeU32(header, index, uint32(len(wireMessage)+4))
+	index = index + 4
+
+	_, err := p.writer.Write(header[:index])
+	if err == nil {
+		_, err = p.writer.Write(wireMessage)
+	}
+
+	if err != nil {
+		log.Printf("failed to write recording %v", err)
+	}
+}
+
+// Periodically flush the buffered records to disk
+func (p *Recorder) flush() {
+	for {
+		time.Sleep(recordingFlushInterval)
+
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			return
+		}
+
+		err := p.writer.Flush()
+		p.mutex.Unlock()
+
+		if err != nil {
+			log.Printf("failed to flush recording %v", err)
+		}
+	}
+}
+
+// Flush and close the recording
+func (p *Recorder) Close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return nil
+	}
+
+	p.closed = true
+	err := p.writer.Flush()
+	if err != nil {
+		p.file.Close()
+		return err
+	}
+
+	return p.file.Close()
+}
+
+func OpenRecording(path string) (*RecordingReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &RecordingReader{file, bufio.NewReader(file)}
+
+	// Validate the file header
+	magic := make([]byte, len(recordingMagic))
+	_, err = io.ReadFull(reader.reader, magic)
+	if err != nil || string(magic) != string(recordingMagic) {
+		file.Close()
+		return nil, errors.New(fmt.Sprintf("%s is not a mongor recording", path))
+	}
+
+	return reader, nil
+}
+
+// Reads the records of a recording file in order
+type RecordingReader struct {
+	file   *os.File
+	reader *bufio.Reader
+}
+
+// Returns the next record, or io.EOF at the end of the recording
+func (p *RecordingReader) Next() (*Record, error) {
+	direction, err := p.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	record := &Record{Direction: direction}
+
+	record.ConnectionId, err = binary.ReadUvarint(p.reader)
+	if err != nil {
+		return nil, corruptRecording(err)
+	}
+
+	offset, err := binary.ReadUvarint(p.reader)
+	if err != nil {
+		return nil, corruptRecording(err)
+	}
+
+	record.Offset = time.Duration(offset) * time.Microsecond
+
+	// Read the wire message
+	messageSizeBytes := make([]byte, 4)
+	_, err = io.ReadFull(p.reader, messageSizeBytes)
+	if err != nil {
+		return nil, corruptRecording(err)
+	}
+
+	messageSize := readInt32(messageSizeBytes)
+	if messageSize < 16 {
+		return nil, errors.New(fmt.Sprintf("corrupt recording, message size %v", messageSize))
+	}
+
+	record.Message = make([]byte, messageSize)
+	copy(record.Message, messageSizeBytes)
+	_, err = io.ReadFull(p.reader, record.Message[4:])
+	if err != nil {
+		return nil, corruptRecording(err)
+	}
+
+	return record, nil
+}
+
+func (p *RecordingReader) Close() error {
+	return p.file.Close()
+}
+
+func corruptRecording(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return errors.New(fmt.Sprintf("corrupt recording %v", err))
+}
+
+func writeU32(buffer []byte, index int, value uint32) {
+	buffer[index+3] = byte((value >> 24) & 0xff)
+	buffer[index+2] = byte((value >> 16) & 0xff)
+	buffer[index+1] = byte((value >> 8) & 0xff)
+	buffer[index] = byte(value & 0xff)
+}
@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"bytes"
+	"gopkg.in/mgo.v2/bson"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Create an OP_QUERY wire message without the size prefix
+func newQueryMessage(requestId int32, collection string, query interface{}) []byte {
+	data, err := bson.Marshal(query)
+	if err != nil {
+		panic(err)
+	}
+
+	message := make([]byte, 0)
+	message = addInt32(message, requestId)
+	message = addInt32(message, 0)
+	message = addInt32(message, OP_QUERY)
+	message = addInt32(message, 0)
+	message = append(message, []byte(collection)...)
+	message = append(message, 0)
+	message = addInt32(message, 0)
+	message = addInt32(message, -1)
+	return append(message, data...)
+}
+
+// Create an OP_REPLY wire message without the size prefix
+func newReplyMessage(responseTo int32, documents ...interface{}) []byte {
+	message := make([]byte, 0)
+	message = addInt32(message, 0)
+	message = addInt32(message, responseTo)
+	message = addInt32(message, OP_REPLY)
+	message = addInt32(message, 0)
+	message = addInt64(message, 0)
+	message = addInt32(message, 0)
+	message = addInt32(message, int32(len(documents)))
+
+	for _, document := range documents {
+		data, err := bson.Marshal(document)
+		if err != nil {
+			panic(err)
+		}
+
+		message = append(message, data...)
+	}
+
+	return message
+}
+
+// Start a stand in server answering every request expecting a reply
+// with the reply document returned by handler
+func startStandIn(t *testing.T, handler func(wireMessage []byte) interface{}) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen %v", err)
+	}
+
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer conn.Close()
+
+				for {
+					_, wireMessage, err := readWireMessage(conn)
+					if err != nil {
+						return
+					}
+
+					if !expectsReply(readInt32(wireMessage[8:12])) {
+						continue
+					}
+
+					reply := newReplyMessage(readInt32(wireMessage[0:4]), handler(wireMessage))
+					conn.Write(addInt32(nil, int32(len(reply)+4)))
+					conn.Write(reply)
+				}
+			}(conn)
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func TestRecordingRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording")
+
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("failed to create recorder %v", err)
+	}
+
+	request := newQueryMessage(1, "test.$cmd", bson.D{{Name: "find", Value: "users"}})
+	reply := newReplyMessage(1, bson.M{"ok": 1})
+
+	connection1 := recorder.NewConnectionId()
+	connection2 := recorder.NewConnectionId()
+	recorder.Record(RECORD_REQUEST, connection1, request)
+	recorder.Record(RECORD_REPLY, connection2, reply)
+
+	err = recorder.Close()
+	if err != nil {
+		t.Fatalf("failed to close recorder %v", err)
+	}
+
+	reader, err := OpenRecording(path)
+	if err != nil {
+		t.Fatalf("failed to open recording %v", err)
+	}
+
+	defer reader.Close()
+
+	expected := []struct {
+		direction    byte
+		connectionId uint64
+		message      []byte
+	}{
+		{RECORD_REQUEST, connection1, request},
+		{RECORD_REPLY, connection2, reply},
+	}
+
+	for _, e := range expected {
+		record, err := reader.Next()
+		if err != nil {
+			t.Fatalf("failed to read record %v", err)
+		}
+
+		if record.Direction != e.direction || record.ConnectionId != e.connectionId {
+			t.Errorf("unexpected record %v:%v", record.Direction, record.ConnectionId)
+		}
+
+		if readInt32(record.Message) != int32(len(e.message)+4) || !bytes.Equal(record.Message[4:], e.message) {
+			t.Errorf("recorded message does not match")
+		}
+	}
+
+	_, err = reader.Next()
+	if err == nil {
+		t.Errorf("expected end of recording")
+	}
+}
+
+func TestOpenRecordingRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording")
+	ioutil.WriteFile(path, []byte("not a recording"), 0644)
+
+	_, err := OpenRecording(path)
+	if err == nil {
+		t.Fatalf("expected an error opening a file that is not a recording")
+	}
+}
+
+func TestReplayAgainstStandIn(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording")
+
+	recorder, err := NewRecorder(path)
+	if err != nil {
+		t.Fatalf("failed to create recorder %v", err)
+	}
+
+	// Two client connections each running a command
+	for i := int32(1); i <= 2; i++ {
+		connectionId := recorder.NewConnectionId()
+		recorder.Record(RECORD_REQUEST, connectionId, newQueryMessage(i, "test.$cmd", bson.D{{Name: "count", Value: "users"}}))
+		recorder.Record(RECORD_REPLY, connectionId, newReplyMessage(i, bson.D{{Name: "n", Value: 10}, {Name: "ok", Value: 1.0}}))
+	}
+
+	recorder.Close()
+
+	// Same shape of reply, different values
+	target := startStandIn(t, func(wireMessage []byte) interface{} {
+		return bson.D{{Name: "n", Value: 11}, {Name: "ok", Value: 1.0}}
+	})
+
+	report, err := Replay(path, target, 0, time.Second)
+	if err != nil {
+		t.Fatalf("failed to replay %v", err)
+	}
+
+	if report.Connections != 2 || report.Requests != 2 || report.Replies != 2 {
+		t.Errorf("unexpected replay report %+v", report)
+	}
+
+	if len(report.Divergences) != 0 || len(report.Errors) != 0 {
+		t.Errorf("unexpected divergences %v errors %v", report.Divergences, report.Errors)
+	}
+
+	// Reply with an error document
+	target = startStandIn(t, func(wireMessage []byte) interface{} {
+		return bson.D{{Name: "ok", Value: 0.0}, {Name: "errmsg", Value: "failed"}}
+	})
+
+	report, err = Replay(path, target, 0, time.Second)
+	if err != nil {
+		t.Fatalf("failed to replay %v", err)
+	}
+
+	if len(report.Divergences) != 2 {
+		t.Errorf("expected 2 divergences got %v", report.Divergences)
+	}
+}
@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Outcome of replaying a recording
+type ReplayReport struct {
+	Connections int
+	Requests    int
+	Replies     int
+	Divergences []string
+	Errors      []string
+	mutex       sync.Mutex
+}
+
+func (p *ReplayReport) divergence(format string, args ...interface{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.Divergences = append(p.Divergences, fmt.Sprintf(format, args...))
+}
+
+func (p *ReplayReport) error(format string, args ...interface{}) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.Errors = append(p.Errors, fmt.Sprintf(format, args...))
+}
+
+func (p *ReplayReport) count(requests int, replies int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.Requests = p.Requests + requests
+	p.Replies = p.Replies + replies
+}
+
+// Recorded traffic of a single client connection
+type replayConnection struct {
+	id       uint64
+	requests []*Record
+	replies  map[int32]*Record
+}
+
+// Replay a recording against the target server. Every recorded client
+// connection is replayed on its own connection, sending the requests at
+// their original offsets divided by speed (0 sends as fast as possible),
+// and the shape of every reply is compared to the recorded reply
+func Replay(path string, target string, speed float64, timeout time.Duration) (*ReplayReport, error) {
+	reader, err := OpenRecording(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer reader.Close()
+
+	// Group the records by connection
+	connections := make(map[uint64]*replayConnection)
+	for {
+		record, err := reader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		connection := connections[record.ConnectionId]
+		if connection == nil {
+			connection = &replayConnection{id: record.ConnectionId, replies: make(map[int32]*Record)}
+			connections[record.ConnectionId] = connection
+		}
+
+		if record.Direction == RECORD_REQUEST {
+			connection.requests = append(connection.requests, record)
+		} else {
+			// Index replies by the request they respond to
+			connection.replies[readInt32(record.Message[8:12])] = record
+		}
+	}
+
+	// Replay the connections in the order they were recorded
+	ids := make([]uint64, 0, len(connections))
+	for id := range connections {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	report := &ReplayReport{Connections: len(connections)}
+	start := time.Now()
+	var wait sync.WaitGroup
+
+	for _, id := range ids {
+		wait.Add(1)
+		go func(connection *replayConnection) {
+			defer wait.Done()
+			replayConnectionRecords(connection, target, speed, timeout, start, report)
+		}(connections[id])
+	}
+
+	wait.Wait()
+	return report, nil
+}
+
+func replayConnectionRecords(connection *replayConnection, target string, speed float64, timeout time.Duration, start time.Time, report *ReplayReport) {
+	conn, err := net.DialTimeout("tcp", target, timeout)
+	if err != nil {
+		report.error("connection %v: failed to connect to %s %v", connection.id, target, err)
+		return
+	}
+
+	defer conn.Close()
+
+	for _, request := range connection.requests {
+		// Wait until the scaled offset of the request
+		if speed > 0 {
+			wait := time.Duration(float64(request.Offset)/speed) - time.Since(start)
+			if wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		requestId := readInt32(request.Message[4:8])
+		opCode := readInt32(request.Message[12:16])
+
+		// Send the request
+		_, err = conn.Write(request.Message)
+		if err != nil {
+			report.error("connection %v: failed to send request %v %v", connection.id, requestId, err)
+			return
+		}
+
+		if !expectsReply(opCode) {
+			report.count(1, 0)
+			continue
+		}
+
+		// Read the reply from the target
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		_, responseMessage, err := readWireMessage(conn)
+		if err != nil {
+			report.error("connection %v: failed to read reply to request %v %v", connection.id, requestId, err)
+			return
+		}
+
+		report.count(1, 1)
+
+		// Compare with the recorded reply
+		recorded := connection.replies[requestId]
+		if recorded == nil {
+			report.divergence("connection %v request %v: no reply recorded", connection.id, requestId)
+			continue
+		}
+
+		diff := replyDiff(recorded.Message[4:], responseMessage)
+		if diff != "" {
+			report.divergence("connection %v request %v: %s", connection.id, requestId, diff)
+		}
+	}
+}
@@ -1,23 +1,17 @@
 package proxy
 
 import (
-	"gopkg.in/mgo.v2/bson"
+	"mongo"
 )
 
-func CreateResponseMessage(requestId []byte, obj interface{}) ([]byte, error) {
-	// Serialize to bson
-	data, err := bson.Marshal(obj)
-	if err != nil {
-		return nil, err
-	}
-
-	// Total length
-	msgLength := len(data) + 16 + 20
+// Shared by all connections so struct types are only parsed once
+var responseParser = mongo.NewBSON()
 
+func CreateResponseMessage(requestId []byte, obj interface{}) ([]byte, error) {
 	// Create the reponse message
-	ismasterCommandBytes := make([]byte, 0)
-	// 16 byte header
-	ismasterCommandBytes = addInt32(ismasterCommandBytes, int32(msgLength))
+	ismasterCommandBytes := make([]byte, 0, 512)
+	// 16 byte header, the length is written once the document is encoded
+	ismasterCommandBytes = addInt32(ismasterCommandBytes, 0)
 	ismasterCommandBytes = append(ismasterCommandBytes, []byte{0, 0, 0, 0}...)
 	ismasterCommandBytes = append(ismasterCommandBytes, requestId...)
 	ismasterCommandBytes = addInt32(ismasterCommandBytes, int32(OP_REPLY))
@@ -26,6 +20,14 @@ func CreateResponseMessage(requestId []byte, obj interface{}) ([]byte, error) {
 	ismasterCommandBytes = append(ismasterCommandBytes, []byte{0, 0, 0, 0, 0, 0, 0, 0}...)
 	ismasterCommandBytes = append(ismasterCommandBytes, []byte{0, 0, 0, 0}...)
 	ismasterCommandBytes = addInt32(ismasterCommandBytes, int32(1))
-	ismasterCommandBytes = append(ismasterCommandBytes, data...)
+
+	// Serialize to bson directly after the header
+	ismasterCommandBytes, err := responseParser.MarshalAppend(ismasterCommandBytes, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	// Total length
+	addInt32(ismasterCommandBytes[:0], int32(len(ismasterCommandBytes)))
 	return ismasterCommandBytes, nil
 }
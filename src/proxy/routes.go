@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Routing configuration file, maps databases, users and listener
+// ports to named replicasets
+//
+//	{
+//	  "clusters": {"a": "mongodb://localhost:31000/admin", "b": "mongodb://localhost:32000/admin"},
+//	  "databases": {"tenant1": "b"},
+//	  "users": {"reporting": "b"},
+//	  "ports": {"50001": "b"},
+//	  "default": "a"
+//	}
+type RoutingConfig struct {
+	Clusters  map[string]string `json:"clusters"`
+	Databases map[string]string `json:"databases"`
+	Users     map[string]string `json:"users"`
+	Ports     map[string]string `json:"ports"`
+	Default   string            `json:"default"`
+}
+
+func NewRoutingTable(path string, timeout time.Duration) *RoutingTable {
+	table := new(RoutingTable)
+	table.path = path
+	table.Timeout = timeout
+	table.Sets = make(map[string]*ReplSet)
+	return table
+}
+
+// Routing table mapping client traffic to one of several replicasets
+type RoutingTable struct {
+	path     string
+	Timeout  time.Duration
+	Sets     map[string]*ReplSet
+	Recorder *Recorder
+	config   *RoutingConfig
+	mutex    sync.RWMutex
+}
+
+// Read and validate the routing configuration file
+func loadRoutingConfig(path string) (*RoutingConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &RoutingConfig{}
+	err = json.Unmarshal(data, config)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.Clusters) == 0 {
+		return nil, errors.New(fmt.Sprintf("routing config %s does not define any clusters", path))
+	}
+
+	// Ensure all routes point to known clusters
+	routes := []map[string]string{config.Databases, config.Users, config.Ports}
+	for _, route := range routes {
+		for key, cluster := range route {
+			if _, ok := config.Clusters[cluster]; !ok {
+				return nil, errors.New(fmt.Sprintf("route %s points to unknown cluster %s", key, cluster))
+			}
+		}
+	}
+
+	for port := range config.Ports {
+		if _, err := strconv.Atoi(port); err != nil {
+			return nil, errors.New(fmt.Sprintf("route port %s is not a number", port))
+		}
+	}
+
+	if config.Default != "" {
+		if _, ok := config.Clusters[config.Default]; !ok {
+			return nil, errors.New(fmt.Sprintf("default cluster %s is unknown", config.Default))
+		}
+	}
+
+	return config, nil
+}
+
+// Load the configuration and connect to all the replicasets
+func (p *RoutingTable) Start() error {
+	return p.Reload()
+}
+
+// Re-read the configuration file, connecting to any new replicasets.
+// Connections already established keep using their replicaset until
+// their next message is routed, replaced and removed replicasets are
+// closed once they are no longer used. Listeners are only started for
+// the ports routed when the proxy starts, new port routes need a restart
+func (p *RoutingTable) Reload() error {
+	config, err := loadRoutingConfig(p.path)
+	if err != nil {
+		return err
+	}
+
+	// Connect to any cluster we have not seen before, or whose uri changed
+	sets := make(map[string]*ReplSet)
+	started := make([]*ReplSet, 0)
+	for name, uri := range config.Clusters {
+		p.mutex.RLock()
+		set := p.Sets[name]
+		p.mutex.RUnlock()
+
+		if set == nil || set.uri != uri {
+			set = NewReplSet(uri, p.Timeout)
+			err = set.Start()
+			if err != nil {
+				// Do not leak the replicasets connected so far
+				for _, set := range started {
+					set.Close()
+				}
+
+				return errors.New(fmt.Sprintf("failed to connect to cluster %s %v", name, err))
+			}
+
+			started = append(started, set)
+		}
+
+		sets[name] = set
+	}
+
+	// Swap in the new table
+	p.mutex.Lock()
+	previousSets, previousConfig := p.Sets, p.config
+	p.Sets = sets
+	p.config = config
+	p.mutex.Unlock()
+
+	// Close the replicasets that were replaced or removed
+	for name, set := range previousSets {
+		if sets[name] != set {
+			set.Close()
+		}
+	}
+
+	// We are not listening to ports added since the proxy started
+	if previousConfig != nil {
+		for port := range config.Ports {
+			if _, ok := previousConfig.Ports[port]; !ok {
+				log.Printf("route for port %s requires a restart to listen to the port", port)
+			}
+		}
+	}
+
+	log.Printf("loaded routing table from %s with %v clusters", p.path, len(sets))
+	return nil
+}
+
+// Returns the replicaset of the named cluster
+func (p *RoutingTable) Cluster(name string) *ReplSet {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.Sets[name]
+}
+
+// Listener ports that have a route
+func (p *RoutingTable) Ports() []int {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	ports := make([]int, 0)
+	for port := range p.config.Ports {
+		number, _ := strconv.Atoi(port)
+		ports = append(ports, number)
+	}
+
+	return ports
+}
+
+// Returns the cluster for an operation. Database routes take precedence
+// over user routes, which take precedence over the listener port route
+func (p *RoutingTable) Route(port int, user string, database string) (string, *ReplSet) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	name := p.config.Default
+
+	if cluster, ok := p.config.Ports[strconv.Itoa(port)]; ok {
+		name = cluster
+	}
+
+	if cluster, ok := p.config.Users[user]; ok && user != "" {
+		name = cluster
+	}
+
+	if cluster, ok := p.config.Databases[database]; ok && database != "" {
+		name = cluster
+	}
+
+	return name, p.Sets[name]
+}
@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	"bytes"
+	"gopkg.in/mgo.v2/bson"
+	"log"
+	"net"
+)
+
+// Authentication fields used to identify the user of a connection
+type authenticationCommand struct {
+	Query     bson.Raw `bson:"$query"`
+	SaslStart int      `bson:"saslStart"`
+	Mechanism string   `bson:"mechanism"`
+	Payload   []byte   `bson:"payload"`
+	User      string   `bson:"user"`
+}
+
+// Connection state for one of the replicasets a client is routed to
+type tenantContext struct {
+	set      *ReplSet
+	context  *ConnectionContext
+	isMaster *isMasterResult
+}
+
+// Close all the connections of the context
+func (p *ConnectionContext) Close() {
+	if p.Primary != nil {
+		p.Primary.Connection.Close()
+	}
+
+	for _, con := range p.Secondaries {
+		con.Connection.Close()
+	}
+}
+
+// Extract the user from an authentication command
+func authenticationUser(query *queryMessage) string {
+	command := &authenticationCommand{}
+	err := bson.Unmarshal(query.Query, command)
+	if err != nil {
+		return ""
+	}
+
+	// Unwrap a command sent with a read preference
+	if command.Query.Kind == 0x03 {
+		err = command.Query.Unmarshal(command)
+		if err != nil {
+			return ""
+		}
+	}
+
+	// MONGODB-CR and x509 carry the user in the command
+	if command.User != "" {
+		return command.User
+	}
+
+	if command.SaslStart == 0 {
+		return ""
+	}
+
+	switch command.Mechanism {
+	case "PLAIN":
+		// [authzid] NUL authcid NUL passwd
+		parts := bytes.Split(command.Payload, []byte{0})
+		if len(parts) == 3 {
+			return string(parts[1])
+		}
+	default:
+		// SCRAM client first message n,,n=user,r=nonce
+		for _, part := range bytes.Split(command.Payload, []byte(",")) {
+			if bytes.HasPrefix(part, []byte("n=")) {
+				return string(part[2:])
+			}
+		}
+	}
+
+	return ""
+}
+
+// Route client connections to the replicaset mapped to the database,
+// authenticated user or listener port of every operation. Clients
+// authenticate against the replicaset their user is routed to, so
+// databases of an authenticated user should live on the same replicaset
+func HandleRoutedConnection(table *RoutingTable, port int, conn net.Conn) {
+	// Contexts by cluster name
+	tenants := make(map[string]*tenantContext)
+	// Cluster that returned a cursor
+	cursors := make(map[int64]string)
+	// Authenticated user of this connection
+	user := ""
+
+	// Identify the connection in the recording
+	var connectionId uint64
+	if table.Recorder != nil {
+		connectionId = table.Recorder.NewConnectionId()
+	}
+
+	// Clean up connections on exit
+	defer conn.Close()
+	defer func() {
+		for _, tenant := range tenants {
+			tenant.context.Close()
+			tenant.set.Release()
+		}
+	}()
+
+	// Start reading of messages
+	for {
+		messageSizeBytes, wireMessage, err := readWireMessage(conn)
+		if err != nil {
+			log.Printf("failed to read wire protocol message from connection %v", err)
+			break
+		}
+
+		// Record the client request
+		if table.Recorder != nil {
+			table.Recorder.Record(RECORD_REQUEST, connectionId, wireMessage)
+		}
+
+		// Get the database the operation targets
+		opCode := readInt32(wireMessage[8:12])
+		database := ""
+
+		switch opCode {
+		case OP_QUERY:
+			query, err := parseQuery(wireMessage)
+			if err != nil {
+				log.Printf("failed to parse query %v", err)
+				return
+			}
+
+			database = query.Database()
+
+			// Pick up the user from the authentication commands
+			if query.IsCommand() {
+				if commandUser := authenticationUser(query); commandUser != "" {
+					user = commandUser
+				}
+			}
+		case OP_GET_MORE:
+			collection, _, err := parseGetMore(wireMessage)
+			if err != nil {
+				log.Printf("failed to parse getmore %v", err)
+				return
+			}
+
+			database = databaseName(collection)
+		case OP_INSERT, OP_UPDATE, OP_DELETE:
+			// The full collection name follows the reserved int32
+			collection, _, err := readCString(wireMessage, 16)
+			if err != nil {
+				log.Printf("failed to parse collection name %v", err)
+				return
+			}
+
+			database = databaseName(collection)
+		}
+
+		// Cursors must be used and killed on the cluster that created them
+		request := parseMongosRequest(wireMessage)
+		owner := ""
+		for _, cursorId := range request.cursorIds {
+			if cluster, ok := cursors[cursorId]; ok {
+				owner = cluster
+				break
+			}
+		}
+
+		// Look up the replicaset for the operation, a replicaset closed by a
+		// reload since we looked it up has been replaced so look again
+		var name string
+		var set *ReplSet
+		for {
+			if owner != "" {
+				name, set = owner, table.Cluster(owner)
+			} else {
+				name, set = table.Route(port, user, database)
+			}
+
+			if set == nil || set.Acquire() {
+				break
+			}
+		}
+
+		if set == nil {
+			log.Printf("no cluster routed for database [%s] user [%s] port [%v]", database, user, port)
+			break
+		}
+
+		// Connect to the replicaset the first time we use it, or again if it
+		// was replaced by a configuration reload
+		tenant := tenants[name]
+		if tenant == nil || tenant.set != set {
+			if tenant != nil {
+				tenant.context.Close()
+				tenant.set.Release()
+			}
+
+			// The tenant holds on to the replicaset until it is replaced
+			tenant = &tenantContext{set: set, context: &ConnectionContext{}}
+			tenant.context.Secondaries = make([]*ServerConnection, 0)
+			tenants[name] = tenant
+
+			tenant.isMaster, err = updateContext(tenant.context, set.Session, set.Timeout)
+			if err != nil {
+				log.Printf("failed to connect to cluster %s %v", name, err)
+				break
+			}
+		} else {
+			// The tenant already holds on to the replicaset
+			set.Release()
+
+			// Update our view of the world to match the one from the mgo driver
+			err = updateWorldView(tenant.context, set.Session, set.Timeout)
+			if err != nil {
+				log.Printf("failed to update view of the world %v", err)
+				break
+			}
+		}
+
+		// Route the message to the replicaset
+		responseMessage, err := processMessage(tenant.context, tenant.isMaster, conn, messageSizeBytes, wireMessage)
+		if err != nil {
+			log.Printf("%v", err)
+			break
+		}
+
+		// Record the reply
+		if table.Recorder != nil && responseMessage != nil {
+			table.Recorder.Record(RECORD_REPLY, connectionId, responseMessage)
+		}
+
+		// Remember which cluster owns the legacy or command cursor
+		updateCursors(cursors, request, name, responseMessage)
+	}
+}
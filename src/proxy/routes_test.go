@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestRoutingConfig(t *testing.T, path string, config string) {
+	err := ioutil.WriteFile(path, []byte(config), 0644)
+	if err != nil {
+		t.Fatalf("failed to write routing config %v", err)
+	}
+}
+
+func TestLoadRoutingConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+
+	for _, test := range []struct {
+		config string
+		err    string
+	}{
+		{`{"clusters": {"a": "mongodb://a"}, "databases": {"db": "a"}, "ports": {"50001": "a"}, "default": "a"}`, ""},
+		{`{"clusters": {}}`, "does not define any clusters"},
+		{`{"clusters": {"a": "mongodb://a"}, "users": {"reporting": "b"}}`, "route reporting points to unknown cluster b"},
+		{`{"clusters": {"a": "mongodb://a"}, "ports": {"port": "a"}}`, "route port port is not a number"},
+		{`{"clusters": {"a": "mongodb://a"}, "default": "b"}`, "default cluster b is unknown"},
+		{`{"clusters": `, "unexpected end of JSON input"},
+	} {
+		writeTestRoutingConfig(t, path, test.config)
+		_, err := loadRoutingConfig(path)
+
+		if test.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", test.config, err)
+		} else if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("%s: expected error %q got %v", test.config, test.err, err)
+		}
+	}
+}
+
+func TestRoutingTableRoute(t *testing.T) {
+	table := NewRoutingTable("", 10000)
+	table.Sets = map[string]*ReplSet{"a": NewReplSet("a", 10000), "b": NewReplSet("b", 10000), "c": NewReplSet("c", 10000)}
+	table.config = &RoutingConfig{
+		Databases: map[string]string{"tenant1": "c"},
+		Users:     map[string]string{"reporting": "b"},
+		Ports:     map[string]string{"50001": "a"},
+	}
+
+	for _, test := range []struct {
+		port     int
+		user     string
+		database string
+		name     string
+	}{
+		{50000, "", "test", ""},
+		{50001, "", "test", "a"},
+		{50001, "reporting", "test", "b"},
+		{50001, "reporting", "tenant1", "c"},
+		{50000, "", "tenant1", "c"},
+	} {
+		name, set := table.Route(test.port, test.user, test.database)
+		if name != test.name || set != table.Sets[test.name] {
+			t.Errorf("%v %s %s: expected cluster [%s] got [%s]", test.port, test.user, test.database, test.name, name)
+		}
+	}
+}
+
+func TestReplSetClose(t *testing.T) {
+	set := NewReplSet("mongodb://localhost", 10000)
+
+	// Closing keeps the set alive while connections use it
+	if !set.Acquire() || !set.Acquire() {
+		t.Fatalf("expected to acquire the set")
+	}
+
+	set.Close()
+	set.Close()
+	if set.Acquire() {
+		t.Errorf("expected a closed set to not be acquired")
+	}
+
+	if set.users != 2 {
+		t.Errorf("expected 2 users got %v", set.users)
+	}
+
+	set.Release()
+	set.Release()
+	if set.users != 0 || !set.closed {
+		t.Errorf("expected a closed set without users got %v users", set.users)
+	}
+}
+
+func TestRoutingTableReloadInvalidUri(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "routes.json")
+	writeTestRoutingConfig(t, path, `{"clusters": {"a": "mongodb://127.0.0.1:1/?unknown=option"}, "default": "a"}`)
+
+	table := NewRoutingTable(path, 10000)
+	err := table.Start()
+	if err == nil || !strings.Contains(err.Error(), "failed to connect to cluster a") {
+		t.Fatalf("expected a connection error got %v", err)
+	}
+
+	// Nothing is routed without a table
+	if len(table.Sets) != 0 {
+		t.Errorf("expected no clusters got %v", table.Sets)
+	}
+}
+
+func TestRoutingTableReloadClosesSets(t *testing.T) {
+	a := startFakeReplSet(t, 1)
+	b := startFakeReplSet(t, 1)
+	c := startFakeReplSet(t, 1)
+
+	table, path := startTestRoutingTable(t, `{
+		"clusters": {"a": "`+a.Uri()+`", "b": "`+b.Uri()+`"},
+		"default": "a"
+	}`)
+
+	setA, setB := table.Sets["a"], table.Sets["b"]
+
+	// A connection is still using cluster a
+	if !setA.Acquire() {
+		t.Fatalf("expected to acquire cluster a")
+	}
+
+	// Point a to another replicaset and drop b
+	writeTestRoutingConfig(t, path, `{"clusters": {"a": "`+c.Uri()+`"}, "default": "a"}`)
+	err := table.Reload()
+	if err != nil {
+		t.Fatalf("failed to reload routing table %v", err)
+	}
+
+	if table.Sets["a"] == setA || table.Sets["b"] != nil {
+		t.Fatalf("expected cluster a to be replaced and b to be removed")
+	}
+
+	// The removed replicaset is closed right away
+	if setB.Session != nil || setB.Acquire() {
+		t.Errorf("expected cluster b to be closed")
+	}
+
+	waitFor(t, "the connections to cluster b to close", func() bool {
+		return b.Members[0].OpenConnections() == 0
+	})
+
+	// The replaced replicaset is closed once the connection releases it
+	if setA.Session == nil || setA.Acquire() {
+		t.Errorf("expected cluster a to be closed but still connected")
+	}
+
+	setA.Release()
+	if setA.Session != nil {
+		t.Errorf("expected cluster a to be disconnected once released")
+	}
+
+	waitFor(t, "the connections to cluster a to close", func() bool {
+		return a.Members[0].OpenConnections() == 0
+	})
+}
+
+func TestRoutingTableReloadFailure(t *testing.T) {
+	a := startFakeReplSet(t, 1)
+	b := startFakeReplSet(t, 1)
+
+	table, path := startTestRoutingTable(t, `{"clusters": {"a": "`+a.Uri()+`"}, "default": "a"}`)
+	setA := table.Sets["a"]
+
+	// The new cluster b connects but c can not be started
+	writeTestRoutingConfig(t, path, `{
+		"clusters": {"a": "`+a.Uri()+`", "b": "`+b.Uri()+`", "c": "mongodb://127.0.0.1:1/?unknown=option"},
+		"default": "a"
+	}`)
+
+	// Cluster order is random, reload a few times so b is connected first
+	for i := 0; i < 10; i++ {
+		if table.Reload() == nil {
+			t.Fatalf("expected the reload to fail")
+		}
+	}
+
+	// The partially started clusters are closed
+	waitFor(t, "the connections to cluster b to close", func() bool {
+		return b.Members[0].OpenConnections() == 0
+	})
+
+	// The current table is left untouched
+	if len(table.Sets) != 1 || table.Sets["a"] != setA || setA.Session == nil {
+		t.Errorf("expected the routing table to be unchanged got %v", table.Sets)
+	}
+}
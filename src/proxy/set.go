@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"gopkg.in/mgo.v2"
+	"sync"
 	"time"
 )
 
@@ -13,9 +14,16 @@ func NewReplSet(uri string, timeout time.Duration) *ReplSet {
 }
 
 type ReplSet struct {
-	uri     string
-	Session *mgo.Session
-	Timeout time.Duration
+	uri      string
+	Session  *mgo.Session
+	Timeout  time.Duration
+	Mirror   *Mirror
+	Recorder *Recorder
+	// Connections using the set, a closed set keeps its session open
+	// until the last of them releases it
+	mutex  sync.Mutex
+	users  int
+	closed bool
 }
 
 func (p *ReplSet) Start() error {
@@ -28,3 +36,51 @@ func (p *ReplSet) Start() error {
 	p.Session = session
 	return nil
 }
+
+// Register a connection using the set, returns false if the set was
+// closed and can not be used anymore
+func (p *ReplSet) Acquire() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return false
+	}
+
+	p.users = p.users + 1
+	return true
+}
+
+// Release a set acquired by a connection, closing the session if the
+// set was closed and this was the last connection using it
+func (p *ReplSet) Release() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.users = p.users - 1
+	if p.closed && p.users == 0 {
+		p.closeSession()
+	}
+}
+
+// Close the set, the session is closed once no connection uses it
+func (p *ReplSet) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+	if p.users == 0 {
+		p.closeSession()
+	}
+}
+
+func (p *ReplSet) closeSession() {
+	if p.Session != nil {
+		p.Session.Close()
+		p.Session = nil
+	}
+}
@@ -0,0 +1,293 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mongo"
+	"net"
+	"strings"
+)
+
+// Parsed OP_QUERY message
+type queryMessage struct {
+	Flags      int32
+	Collection string
+	Skip       int32
+	Return     int32
+	Query      []byte
+}
+
+// Parsed OP_REPLY message
+type replyMessage struct {
+	Flags          int32
+	CursorId       int64
+	StartingFrom   int32
+	NumberReturned int32
+	Documents      []byte
+}
+
+// Read a full wire protocol message from the connection, returns the
+// 4 byte message size prefix and the remainder of the message
+func readWireMessage(conn net.Conn) ([]byte, []byte, error) {
+	messageSizeBytes := make([]byte, 4)
+
+	// Read the message size
+	_, err := io.ReadFull(conn, messageSizeBytes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Get the message size
+	messageSize := readInt32(messageSizeBytes)
+	if messageSize < 16 {
+		return nil, nil, errors.New(fmt.Sprintf("wire protocol message size %v is smaller than the header", messageSize))
+	}
+
+	// Read the entire message into memory
+	wireMessage := make([]byte, messageSize-4)
+	_, err = io.ReadFull(conn, wireMessage)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return messageSizeBytes, wireMessage, nil
+}
+
+// Write a wire protocol message split in the size prefix and the remainder
+func writeWireMessage(conn net.Conn, messageSizeBytes []byte, wireMessage []byte) error {
+	_, err := conn.Write(messageSizeBytes)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Write(wireMessage)
+	return err
+}
+
+// Does the opcode expect a reply from the server
+func expectsReply(opCode int32) bool {
+	return opCode == OP_QUERY || opCode == OP_GET_MORE
+}
+
+// Read the cstring located at index, returns the string and the index after it
+func readCString(b []byte, index int) (string, int, error) {
+	if index >= len(b) {
+		return "", index, errors.New("cstring starts outside of the wire protocol message")
+	}
+
+	end := bytes.IndexByte(b[index:], 0x00)
+	if end == -1 {
+		return "", index, errors.New("cstring is not null terminated")
+	}
+
+	return string(b[index : index+end]), index + end + 1, nil
+}
+
+// Parse an OP_QUERY message (wire message without the size prefix)
+func parseQuery(wireMessage []byte) (*queryMessage, error) {
+	if len(wireMessage) < 16 {
+		return nil, errors.New("OP_QUERY message is too short")
+	}
+
+	query := &queryMessage{}
+	query.Flags = readInt32(wireMessage[12:16])
+
+	// Read the full collection name
+	collection, index, err := readCString(wireMessage, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ensure we have the skip, return and the document size
+	if index+12 > len(wireMessage) {
+		return nil, errors.New("OP_QUERY message is too short")
+	}
+
+	query.Collection = collection
+	query.Skip = readInt32(wireMessage[index : index+4])
+	query.Return = readInt32(wireMessage[index+4 : index+8])
+
+	// Get the query document
+	documentSize := int(readInt32(wireMessage[index+8 : index+12]))
+	if documentSize < 5 || index+8+documentSize > len(wireMessage) {
+		return nil, errors.New(fmt.Sprintf("OP_QUERY document size %v is invalid", documentSize))
+	}
+
+	query.Query = wireMessage[index+8 : index+8+documentSize]
+	return query, nil
+}
+
+// Database name of the query namespace
+func (p *queryMessage) Database() string {
+	return databaseName(p.Collection)
+}
+
+// Is the query a command
+func (p *queryMessage) IsCommand() bool {
+	return strings.HasSuffix(p.Collection, ".$cmd")
+}
+
+// Database name of a full collection name
+func databaseName(collection string) string {
+	index := strings.IndexByte(collection, '.')
+	if index == -1 {
+		return collection
+	}
+
+	return collection[:index]
+}
+
+// Parse the cursor id of an OP_GET_MORE message
+func parseGetMore(wireMessage []byte) (string, int64, error) {
+	if len(wireMessage) < 16 {
+		return "", 0, errors.New("OP_GET_MORE message is too short")
+	}
+
+	// Read the full collection name
+	collection, index, err := readCString(wireMessage, 16)
+	if err != nil {
+		return "", 0, err
+	}
+
+	// Skip the number to return
+	if index+12 > len(wireMessage) {
+		return "", 0, errors.New("OP_GET_MORE message is too short")
+	}
+
+	return collection, readInt64(wireMessage[index+4 : index+12]), nil
+}
+
+// Parse the cursor ids of an OP_KILL_CURSORS message
+func parseKillCursors(wireMessage []byte) ([]int64, error) {
+	if len(wireMessage) < 20 {
+		return nil, errors.New("OP_KILL_CURSORS message is too short")
+	}
+
+	// Number of cursor ids in the message
+	numberOfCursors := int(readInt32(wireMessage[16:20]))
+	if numberOfCursors < 0 || 20+numberOfCursors*8 > len(wireMessage) {
+		return nil, errors.New(fmt.Sprintf("OP_KILL_CURSORS cursor count %v is invalid", numberOfCursors))
+	}
+
+	cursorIds := make([]int64, numberOfCursors)
+	for i := 0; i < numberOfCursors; i++ {
+		cursorIds[i] = readInt64(wireMessage[20+i*8:])
+	}
+
+	return cursorIds, nil
+}
+
+// Create an OP_KILL_CURSORS wire message without the size prefix
+func newKillCursorsMessage(requestId int32, cursorIds ...int64) []byte {
+	message := make([]byte, 0, 20+len(cursorIds)*8)
+	message = addInt32(message, requestId)
+	message = addInt32(message, 0)
+	message = addInt32(message, OP_KILL_CURSORS)
+	message = addInt32(message, 0)
+	message = addInt32(message, int32(len(cursorIds)))
+
+	for _, cursorId := range cursorIds {
+		message = addInt64(message, cursorId)
+	}
+
+	return message
+}
+
+// Parse an OP_REPLY message (wire message without the size prefix)
+func parseReply(wireMessage []byte) (*replyMessage, error) {
+	if len(wireMessage) < 32 {
+		return nil, errors.New("OP_REPLY message is too short")
+	}
+
+	reply := &replyMessage{}
+	reply.Flags = readInt32(wireMessage[12:16])
+	reply.CursorId = readInt64(wireMessage[16:24])
+	reply.StartingFrom = readInt32(wireMessage[24:28])
+	reply.NumberReturned = readInt32(wireMessage[28:32])
+	reply.Documents = wireMessage[32:]
+	return reply, nil
+}
+
+// Returns the first document of the reply
+func (p *replyMessage) FirstDocument() []byte {
+	if p.NumberReturned < 1 || len(p.Documents) < 5 {
+		return nil
+	}
+
+	documentSize := int(readInt32(p.Documents))
+	if documentSize < 5 || documentSize > len(p.Documents) {
+		return nil
+	}
+
+	return p.Documents[:documentSize]
+}
+
+func readInt64(b []byte) int64 {
+	return int64((uint64(b[0]) << 0) |
+		(uint64(b[1]) << 8) |
+		(uint64(b[2]) << 16) |
+		(uint64(b[3]) << 24) |
+		(uint64(b[4]) << 32) |
+		(uint64(b[5]) << 40) |
+		(uint64(b[6]) << 48) |
+		(uint64(b[7]) << 56))
+}
+
+// Name of the first field of a command document, unwrapping $query
+func commandName(document []byte) string {
+	iterator := mongo.RawDocument(document).Iterator()
+	if !iterator.Next() {
+		return ""
+	}
+
+	// Unwrap a command wrapped in $query
+	element := iterator.Element()
+	if query, err := element.Value().Document(); err == nil && element.Key() == "$query" {
+		return commandName(query)
+	}
+
+	return element.Key()
+}
+
+// Read preference mode of an OP_QUERY message, empty if there is none
+func readPreferenceMode(wireMessage []byte) string {
+	if readInt32(wireMessage[8:12]) != OP_QUERY {
+		return ""
+	}
+
+	query, err := parseQuery(wireMessage)
+	if err != nil {
+		return ""
+	}
+
+	for _, key := range []string{"$readPreference", "$readpreference"} {
+		value, err := mongo.RawDocument(query.Query).Lookup(key, "mode")
+		if err != nil {
+			continue
+		}
+
+		mode, err := value.StringValue()
+		if err == nil {
+			return mode
+		}
+	}
+
+	return ""
+}
+
+// Is the wire message an ismaster command
+func isIsMaster(wireMessage []byte) bool {
+	if readInt32(wireMessage[8:12]) != OP_QUERY {
+		return false
+	}
+
+	query, err := parseQuery(wireMessage)
+	if err != nil || !query.IsCommand() {
+		return false
+	}
+
+	name := commandName(query.Query)
+	return name == "isMaster" || name == "ismaster"
+}